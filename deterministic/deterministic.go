@@ -0,0 +1,275 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package deterministic provides the clock and random number generator substituted for the
+// time and math/rand functions of the code under test by the deterministic rewriter.
+//
+// The functions behave as the ones they stand for until Enable is called, which the rewriter
+// does in test binaries only. The clock is then virtual: it starts at DD_DETERMINISTIC_TIME, in
+// the RFC 3339 format, or at 2000-01-01T00:00:00Z, and only advances when Sleep or Advance are
+// called. The generator is seeded with DD_DETERMINISTIC_SEED, or a random seed. The seed is
+// logged to stderr when enabled so that failing runs can be reproduced
+package deterministic
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// SeedEnvVar is the environment variable holding the seed of the generator
+	SeedEnvVar = "DD_DETERMINISTIC_SEED"
+	// TimeEnvVar is the environment variable holding the start time of the clock
+	TimeEnvVar = "DD_DETERMINISTIC_TIME"
+)
+
+// DefaultTime is the start time of the clock when TimeEnvVar isn't set
+var DefaultTime = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	mu      sync.Mutex
+	enabled bool
+	now     time.Time
+	seed    int64
+	rng     *rand.Rand
+)
+
+func init() {
+	now = DefaultTime
+	if s := os.Getenv(TimeEnvVar); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "deterministic: invalid %s: %v\n", TimeEnvVar, err)
+		} else {
+			now = t
+		}
+	}
+
+	seed = time.Now().UnixNano()
+	if s := os.Getenv(SeedEnvVar); s != "" {
+		parsed, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "deterministic: invalid %s: %v\n", SeedEnvVar, err)
+		} else {
+			seed = parsed
+		}
+	}
+	rng = rand.New(rand.NewSource(seed))
+}
+
+// Enable switches the functions standing for the time and math/rand ones to the virtual clock
+// and the seeded generator
+func Enable() {
+	mu.Lock()
+	defer mu.Unlock()
+	if enabled {
+		return
+	}
+	enabled = true
+	fmt.Fprintf(os.Stderr, "deterministic: seed %d, set %s=%d to reproduce\n", seed, SeedEnvVar, seed)
+}
+
+// Enabled reports whether Enable was called
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
+
+// SetTime sets the time of the clock
+func SetTime(t time.Time) {
+	mu.Lock()
+	defer mu.Unlock()
+	now = t
+}
+
+// Advance moves the clock forward by d
+func Advance(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	now = now.Add(d)
+}
+
+// SetSeed reseeds the generator
+func SetSeed(s int64) {
+	mu.Lock()
+	defer mu.Unlock()
+	seed = s
+	rng = rand.New(rand.NewSource(s))
+}
+
+// CurrentSeed returns the seed of the generator
+func CurrentSeed() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return seed
+}
+
+// Now stands for time.Now and returns the time of the clock
+func Now() time.Time {
+	if !Enabled() {
+		return time.Now()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return now
+}
+
+// Sleep stands for time.Sleep. It advances the clock by d and yields the processor
+func Sleep(d time.Duration) {
+	if !Enabled() {
+		time.Sleep(d)
+		return
+	}
+	if d > 0 {
+		Advance(d)
+	}
+	runtime.Gosched()
+}
+
+// Since stands for time.Since
+func Since(t time.Time) time.Duration {
+	return Now().Sub(t)
+}
+
+// Until stands for time.Until
+func Until(t time.Time) time.Duration {
+	return t.Sub(Now())
+}
+
+// withRand calls f with the generator, which isn't safe for concurrent use
+func withRand[T any](f func(r *rand.Rand) T) T {
+	mu.Lock()
+	defer mu.Unlock()
+	return f(rng)
+}
+
+// The functions below stand for their math/rand counterparts, which they call until enabled
+
+func ExpFloat64() float64 {
+	if !Enabled() {
+		return rand.ExpFloat64()
+	}
+	return withRand((*rand.Rand).ExpFloat64)
+}
+
+func Float32() float32 {
+	if !Enabled() {
+		return rand.Float32()
+	}
+	return withRand((*rand.Rand).Float32)
+}
+
+func Float64() float64 {
+	if !Enabled() {
+		return rand.Float64()
+	}
+	return withRand((*rand.Rand).Float64)
+}
+
+func Int() int {
+	if !Enabled() {
+		return rand.Int()
+	}
+	return withRand((*rand.Rand).Int)
+}
+
+func Int31() int32 {
+	if !Enabled() {
+		return rand.Int31()
+	}
+	return withRand((*rand.Rand).Int31)
+}
+
+func Int63() int64 {
+	if !Enabled() {
+		return rand.Int63()
+	}
+	return withRand((*rand.Rand).Int63)
+}
+
+func NormFloat64() float64 {
+	if !Enabled() {
+		return rand.NormFloat64()
+	}
+	return withRand((*rand.Rand).NormFloat64)
+}
+
+func Uint32() uint32 {
+	if !Enabled() {
+		return rand.Uint32()
+	}
+	return withRand((*rand.Rand).Uint32)
+}
+
+func Uint64() uint64 {
+	if !Enabled() {
+		return rand.Uint64()
+	}
+	return withRand((*rand.Rand).Uint64)
+}
+
+func Int31n(n int32) int32 {
+	if !Enabled() {
+		return rand.Int31n(n)
+	}
+	return withRand(func(r *rand.Rand) int32 { return r.Int31n(n) })
+}
+
+func Int63n(n int64) int64 {
+	if !Enabled() {
+		return rand.Int63n(n)
+	}
+	return withRand(func(r *rand.Rand) int64 { return r.Int63n(n) })
+}
+
+func Intn(n int) int {
+	if !Enabled() {
+		return rand.Intn(n)
+	}
+	return withRand(func(r *rand.Rand) int { return r.Intn(n) })
+}
+
+func Perm(n int) []int {
+	if !Enabled() {
+		return rand.Perm(n)
+	}
+	return withRand(func(r *rand.Rand) []int { return r.Perm(n) })
+}
+
+func Shuffle(n int, swap func(i, j int)) {
+	if !Enabled() {
+		rand.Shuffle(n, swap)
+		return
+	}
+	withRand(func(r *rand.Rand) struct{} {
+		r.Shuffle(n, swap)
+		return struct{}{}
+	})
+}
+
+func Read(p []byte) (int, error) {
+	if !Enabled() {
+		return rand.Read(p)
+	}
+	return withRand(func(r *rand.Rand) int {
+		n, _ := r.Read(p)
+		return n
+	}), nil
+}
+
+// Seed stands for the deprecated rand.Seed and reseeds the generator
+func Seed(s int64) {
+	if !Enabled() {
+		rand.Seed(s)
+		return
+	}
+	SetSeed(s)
+}
@@ -0,0 +1,59 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package deterministic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// enable enables the package for the duration of the test
+func enable(t *testing.T) {
+	Enable()
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		enabled = false
+	})
+}
+
+func TestDisabled(t *testing.T) {
+	require.False(t, Enabled())
+	defer SetTime(Now())
+
+	SetTime(DefaultTime)
+	require.WithinDuration(t, time.Now(), Now(), time.Minute)
+	start := time.Now()
+	Sleep(time.Millisecond)
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond)
+}
+
+func TestClock(t *testing.T) {
+	enable(t)
+	defer SetTime(Now())
+
+	SetTime(DefaultTime)
+	start := Now()
+	require.Equal(t, DefaultTime, start)
+
+	Sleep(time.Hour)
+	require.Equal(t, time.Hour, Since(start))
+	Advance(time.Minute)
+	require.Equal(t, -61*time.Minute, Until(start))
+}
+
+func TestRand(t *testing.T) {
+	enable(t)
+	defer SetSeed(CurrentSeed())
+
+	SetSeed(42)
+	first := []any{Int(), Intn(10), Float64(), Perm(5)}
+	SetSeed(42)
+	require.Equal(t, first, []any{Int(), Intn(10), Float64(), Perm(5)})
+	require.EqualValues(t, 42, CurrentSeed())
+}
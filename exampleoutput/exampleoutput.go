@@ -0,0 +1,95 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package exampleoutput wraps the runnable examples of the test binaries, so that test runners
+// can report them as tests, with their expected and actual output when they fail.
+package exampleoutput
+
+import (
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Result is the result of a run of an example
+type Result struct {
+	// Name is the name of the example, as in ExampleFoo_bar
+	Name string
+	// Expected is the output expected by the `// Output:` comment of the example
+	Expected string
+	// Actual is the output the example wrote to the standard output
+	Actual string
+	// Unordered reports whether the output is compared regardless of the order of the lines
+	Unordered bool
+	// Passed reports whether the example printed the expected output without panicking
+	Passed bool
+	// Panic is the value the example panicked with, if any
+	Panic    any
+	Duration time.Duration
+}
+
+// Wrap returns e with a function capturing the output of e.F and calling report once it is
+// done. The output is still written to the standard output, so that the testing package checks
+// it as usual, and panics are reported, then propagated
+func Wrap(e testing.InternalExample, report func(Result)) testing.InternalExample {
+	f := e.F
+	e.F = func() {
+		stdout := os.Stdout
+		r, w, err := os.Pipe()
+		if err != nil {
+			f()
+			return
+		}
+		os.Stdout = w
+		output := make(chan string)
+		go func() {
+			var buf strings.Builder
+			_, _ = io.Copy(&buf, r)
+			r.Close()
+			output <- buf.String()
+		}()
+
+		start := time.Now()
+		done := false
+		defer func() {
+			res := Result{Name: e.Name, Expected: e.Output, Unordered: e.Unordered, Duration: time.Since(start)}
+			if !done {
+				res.Panic = recover()
+			}
+			w.Close()
+			os.Stdout = stdout
+			res.Actual = <-output
+			_, _ = io.WriteString(stdout, res.Actual)
+			res.Passed = res.Panic == nil && Matches(res.Actual, res.Expected, res.Unordered)
+			report(res)
+			if res.Panic != nil {
+				panic(res.Panic)
+			}
+		}()
+		f()
+		done = true
+	}
+	return e
+}
+
+// Matches reports whether the actual output of an example matches the expected one, following
+// the rules of the testing package: surrounding spaces are ignored, and the lines of unordered
+// outputs are compared once sorted
+func Matches(actual, expected string, unordered bool) bool {
+	actual, expected = strings.TrimSpace(actual), strings.TrimSpace(expected)
+	if unordered {
+		return sortLines(actual) == sortLines(expected)
+	}
+	return actual == expected
+}
+
+func sortLines(output string) string {
+	lines := strings.Split(output, "\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
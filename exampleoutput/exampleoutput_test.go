@@ -0,0 +1,97 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package exampleoutput
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+// captureStdout runs f with the standard output redirected to a file and returns what f wrote
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = file
+	defer func() {
+		os.Stdout = stdout
+	}()
+	f()
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWrap(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		print     string
+		expected  string
+		unordered bool
+		passed    bool
+	}{
+		{name: "passed", print: "hello\n", expected: "hello\n", passed: true},
+		{name: "failed", print: "hello\n", expected: "bye\n"},
+		{name: "unordered", print: "b\na\n", expected: "a\nb\n", unordered: true, passed: true},
+		{name: "ordered", print: "b\na\n", expected: "a\nb\n"},
+		{name: "spaces", print: "\n hello \n\n", expected: "hello", passed: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var res Result
+			e := Wrap(testing.InternalExample{
+				Name:      "ExampleFoo",
+				F:         func() { fmt.Print(tc.print) },
+				Output:    tc.expected,
+				Unordered: tc.unordered,
+			}, func(r Result) { res = r })
+
+			if out := captureStdout(t, e.F); out != tc.print {
+				t.Errorf("output not forwarded, got %q", out)
+			}
+			if res.Name != "ExampleFoo" || res.Actual != tc.print || res.Expected != tc.expected || res.Passed != tc.passed {
+				t.Errorf("unexpected result %+v", res)
+			}
+		})
+	}
+}
+
+func TestWrapPanic(t *testing.T) {
+	var res Result
+	e := Wrap(testing.InternalExample{
+		Name: "ExampleFoo",
+		F: func() {
+			fmt.Println("before")
+			panic("boom")
+		},
+		Output: "before\n",
+	}, func(r Result) { res = r })
+
+	var recovered any
+	out := captureStdout(t, func() {
+		defer func() { recovered = recover() }()
+		e.F()
+	})
+	if recovered != "boom" {
+		t.Fatalf("panic not propagated, got %v", recovered)
+	}
+	if out != "before\n" || res.Actual != "before\n" || res.Panic != "boom" || res.Passed {
+		t.Errorf("unexpected result %+v, output %q", res, out)
+	}
+}
+
+func ExampleMatches() {
+	fmt.Println(Matches("b\na\n", "a\nb", true))
+	fmt.Println(Matches("b\na\n", "a\nb", false))
+	// Output:
+	// true
+	// false
+}
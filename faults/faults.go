@@ -0,0 +1,178 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package faults controls at runtime the calls instrumented by the fault injection processor.
+// Instrumented calls consult the controller before running and either return a configured
+// error or are delayed by a configured latency.
+//
+// Faults are only injected when the DD_FAULTS environment variable is set. It holds a JSON
+// object, or the path of a file holding one, keyed by the instrumented functions:
+//
+//	{"database/sql.(*DB).QueryContext": {"error": "connection refused", "latency": "200ms", "rate": 0.5}}
+package faults
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvVar is the environment variable enabling fault injection
+const EnvVar = "DD_FAULTS"
+
+// ErrInjected is matched by errors.Is for every injected error
+var ErrInjected = errors.New("injected fault")
+
+// Fault describes the fault injected in the calls to a function
+type Fault struct {
+	// Err is the error returned instead of calling the function. Functions without
+	// an error result are only delayed
+	Err error
+	// Latency delays the calls
+	Latency time.Duration
+	// Rate is the probability, between 0 and 1, of injecting the fault in a call.
+	// The fault is injected in every call when zero
+	Rate float64
+}
+
+// Error is the error returned by calls in which a fault is injected
+type Error struct {
+	// Site is the function in which the fault was injected
+	Site    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is ErrInjected
+func (e *Error) Is(target error) bool {
+	return target == ErrInjected
+}
+
+var (
+	mu      sync.RWMutex
+	enabled bool
+	faults  = make(map[string]Fault)
+)
+
+func init() {
+	spec := os.Getenv(EnvVar)
+	if spec == "" {
+		return
+	}
+	loaded, err := parse(spec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "faults: invalid %s: %v\n", EnvVar, err)
+		return
+	}
+	enabled = true
+	faults = loaded
+}
+
+// parse reads the faults of a JSON object or file
+func parse(spec string) (map[string]Fault, error) {
+	data := []byte(spec)
+	if !strings.HasPrefix(strings.TrimSpace(spec), "{") {
+		var err error
+		if data, err = os.ReadFile(spec); err != nil {
+			return nil, err
+		}
+	}
+
+	var rules map[string]struct {
+		Error   string  `json:"error"`
+		Latency string  `json:"latency"`
+		Rate    float64 `json:"rate"`
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+	parsed := make(map[string]Fault, len(rules))
+	for site, rule := range rules {
+		f := Fault{Rate: rule.Rate}
+		if rule.Error != "" {
+			f.Err = &Error{Site: site, Message: rule.Error}
+		}
+		if rule.Latency != "" {
+			latency, err := time.ParseDuration(rule.Latency)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", site, err)
+			}
+			f.Latency = latency
+		}
+		parsed[site] = f
+	}
+	return parsed, nil
+}
+
+// Enabled reports whether faults are injected
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return enabled
+}
+
+// SetEnabled enables or disables fault injection, overriding the environment
+func SetEnabled(e bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	enabled = e
+}
+
+// Set configures the fault injected in the calls to site, such as "database/sql.(*DB).QueryContext"
+func Set(site string, f Fault) {
+	mu.Lock()
+	defer mu.Unlock()
+	faults[site] = f
+}
+
+// Reset removes all the configured faults
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	faults = make(map[string]Fault)
+}
+
+// lookup returns the fault to inject in the current call to site
+func lookup(site string) (Fault, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if !enabled {
+		return Fault{}, false
+	}
+	f, ok := faults[site]
+	if !ok || (f.Rate > 0 && rand.Float64() >= f.Rate) {
+		return Fault{}, false
+	}
+	return f, true
+}
+
+// Inject is called by the instrumented calls to site before running. It applies the configured
+// latency and returns the configured error, in which case the call doesn't run
+func Inject(site string) error {
+	f, ok := lookup(site)
+	if !ok {
+		return nil
+	}
+	if f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+	return f.Err
+}
+
+// Delay is called by the instrumented calls to site without an error result before running.
+// It applies the configured latency
+func Delay(site string) {
+	if f, ok := lookup(site); ok && f.Latency > 0 {
+		time.Sleep(f.Latency)
+	}
+}
@@ -0,0 +1,60 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package faults
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	spec := `{"database/sql.(*DB).QueryContext": {"error": "connection refused", "latency": "20ms", "rate": 0.5}}`
+	parsed, err := parse(spec)
+	require.NoError(t, err)
+	f := parsed["database/sql.(*DB).QueryContext"]
+	require.Equal(t, 20*time.Millisecond, f.Latency)
+	require.Equal(t, 0.5, f.Rate)
+	require.EqualError(t, f.Err, "connection refused")
+	require.True(t, errors.Is(f.Err, ErrInjected))
+
+	path := filepath.Join(t.TempDir(), "faults.json")
+	require.NoError(t, os.WriteFile(path, []byte(spec), 0644))
+	fromFile, err := parse(path)
+	require.NoError(t, err)
+	require.Equal(t, parsed, fromFile)
+
+	_, err = parse(`{"net/http.Get": {"latency": "soon"}}`)
+	require.Error(t, err)
+}
+
+func TestInject(t *testing.T) {
+	defer Reset()
+	defer SetEnabled(Enabled())
+
+	injected := errors.New("boom")
+	Set("net/http.Get", Fault{Err: injected, Latency: 10 * time.Millisecond})
+
+	SetEnabled(false)
+	require.NoError(t, Inject("net/http.Get"))
+
+	SetEnabled(true)
+	start := time.Now()
+	require.ErrorIs(t, Inject("net/http.Get"), injected)
+	require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+	require.NoError(t, Inject("net/http.Post"))
+
+	start = time.Now()
+	Delay("net/http.Get")
+	require.GreaterOrEqual(t, time.Since(start), 10*time.Millisecond)
+
+	Reset()
+	require.NoError(t, Inject("net/http.Get"))
+}
@@ -0,0 +1,146 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package fuzzing wraps the functions passed to testing.F.Fuzz, so that test runners can
+// report every seed corpus entry and fuzzing input as a test, and capture the failing inputs
+// along with their entry in the testdata/fuzz corpus.
+package fuzzing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Input is an input of a fuzz function
+type Input struct {
+	// Name is the name of the test running the input, as in FuzzFoo/seed#0
+	Name string
+	// Values are the arguments of the fuzz function following *testing.T
+	Values []any
+	// CorpusPath is the path, relative to the package directory, of the corpus entry of the
+	// input: the seed corpus file it was read from, or the file the fuzzing engine writes
+	// failing inputs to
+	CorpusPath string
+	// Failed reports whether the input failed the test
+	Failed bool
+	// Panic is the value the fuzz function panicked with, if any
+	Panic any
+}
+
+// Wrap returns a function of the type of ff calling ff, then report once the input is done.
+// The panics of ff are reported, then propagated. ff is returned as is if it isn't a valid
+// fuzz function, for testing.F.Fuzz to report the error
+func Wrap(ff any, report func(t *testing.T, in Input)) any {
+	fn := reflect.ValueOf(ff)
+	if fn.Kind() != reflect.Func || fn.Type().NumIn() == 0 || fn.Type().In(0) != reflect.TypeOf((*testing.T)(nil)) {
+		return ff
+	}
+	return reflect.MakeFunc(fn.Type(), func(args []reflect.Value) []reflect.Value {
+		t := args[0].Interface().(*testing.T)
+		values := make([]any, len(args)-1)
+		for i, arg := range args[1:] {
+			values[i] = arg.Interface()
+		}
+		done := false
+		defer func() {
+			in := Input{Name: t.Name(), Values: values, Failed: t.Failed()}
+			if !done {
+				// ff panicked, or called runtime.Goexit through t.FailNow
+				in.Panic = recover()
+				in.Failed = in.Failed || in.Panic != nil
+			}
+			if in.Failed {
+				in.CorpusPath = corpusPath(in.Name, values)
+			}
+			report(t, in)
+			if in.Panic != nil {
+				panic(in.Panic)
+			}
+		}()
+		results := fn.Call(args)
+		done = true
+		return results
+	}).Interface()
+}
+
+// corpusPath returns the path of the corpus entry of the input named name
+func corpusPath(name string, values []any) string {
+	fuzzName, entry, _ := strings.Cut(name, "/")
+	if entry != "" {
+		if path := filepath.Join("testdata", "fuzz", fuzzName, entry); fileExists(path) {
+			return path
+		}
+	}
+	path, err := CorpusPath(fuzzName, values...)
+	if err != nil {
+		return ""
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// CorpusPath returns the path, relative to the package directory, of the file the fuzzing
+// engine writes the input made of values to when it fails the fuzz test fuzzName
+func CorpusPath(fuzzName string, values ...any) (string, error) {
+	data, err := Marshal(values...)
+	if err != nil {
+		return "", err
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))[:16]
+	return filepath.Join("testdata", "fuzz", fuzzName, sum), nil
+}
+
+// Marshal encodes values in the `go test fuzz v1` format of the corpus files
+func Marshal(values ...any) ([]byte, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no value to marshal")
+	}
+	b := bytes.NewBufferString("go test fuzz v1\n")
+	for _, value := range values {
+		switch v := value.(type) {
+		case int, int8, int16, int64, uint, uint16, uint32, uint64, bool:
+			fmt.Fprintf(b, "%T(%v)\n", v, v)
+		case float32:
+			if math.IsNaN(float64(v)) && math.Float32bits(v) != math.Float32bits(float32(math.NaN())) {
+				fmt.Fprintf(b, "math.Float32frombits(0x%x)\n", math.Float32bits(v))
+			} else {
+				fmt.Fprintf(b, "%T(%v)\n", v, v)
+			}
+		case float64:
+			if math.IsNaN(v) && math.Float64bits(v) != math.Float64bits(math.NaN()) {
+				fmt.Fprintf(b, "math.Float64frombits(0x%x)\n", math.Float64bits(v))
+			} else {
+				fmt.Fprintf(b, "%T(%v)\n", v, v)
+			}
+		case string:
+			fmt.Fprintf(b, "string(%q)\n", v)
+		case rune:
+			if utf8.ValidRune(v) {
+				fmt.Fprintf(b, "rune(%q)\n", v)
+			} else {
+				fmt.Fprintf(b, "int32(%v)\n", v)
+			}
+		case byte:
+			fmt.Fprintf(b, "byte(%q)\n", v)
+		case []byte:
+			fmt.Fprintf(b, "[]byte(%q)\n", v)
+		default:
+			return nil, fmt.Errorf("unsupported fuzz argument type %T", v)
+		}
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,103 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package fuzzing
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMarshal(t *testing.T) {
+	data, err := Marshal([]byte("a\n"), "b", 3, int32(-1), 'x', byte('y'), 1.5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `go test fuzz v1
+[]byte("a\n")
+string("b")
+int(3)
+int32(-1)
+rune('x')
+byte('y')
+float64(1.5)
+bool(true)
+`
+	if string(data) != expected {
+		t.Fatalf("unexpected encoding:\n%s", data)
+	}
+
+	if _, err := Marshal(struct{}{}); err == nil {
+		t.Fatal("expected an error for unsupported types")
+	}
+}
+
+const failingEnvVar = "FUZZING_TEST_FAILING"
+
+func FuzzWrapped(f *testing.F) {
+	f.Add("ok", 1)
+	f.Add("fail", 2)
+	f.Add("panic", 3)
+	f.Fuzz(Wrap(func(t *testing.T, s string, n int) {
+		if os.Getenv(failingEnvVar) == "" {
+			return
+		}
+		switch s {
+		case "fail":
+			t.Fatal("failed")
+		case "panic":
+			panic("boom")
+		}
+	}, func(t *testing.T, in Input) {
+		t.Logf("report %s failed=%v panic=%v corpus=%s values=%v", in.Name, in.Failed, in.Panic, in.CorpusPath, in.Values)
+	}).(func(*testing.T, string, int)))
+}
+
+func TestWrap(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^FuzzWrapped$", "-test.v")
+	cmd.Env = append(os.Environ(), failingEnvVar+"=1")
+	out, _ := cmd.CombinedOutput()
+	output := string(out)
+
+	failPath, _ := CorpusPath("FuzzWrapped", "fail", 2)
+	panicPath, _ := CorpusPath("FuzzWrapped", "panic", 3)
+	for _, expected := range []string{
+		"report FuzzWrapped/seed#0 failed=false panic=<nil> corpus= values=[ok 1]",
+		"report FuzzWrapped/seed#1 failed=true panic=<nil> corpus=" + failPath + " values=[fail 2]",
+		"report FuzzWrapped/seed#2 failed=true panic=boom corpus=" + panicPath + " values=[panic 3]",
+		"panic: boom",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in:\n%s", expected, output)
+		}
+	}
+}
+
+func TestCorpusPathSeedFile(t *testing.T) {
+	dir := t.TempDir()
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	entry := filepath.Join("testdata", "fuzz", "FuzzFoo", "crash")
+	if err := os.MkdirAll(filepath.Dir(entry), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(entry, []byte("go test fuzz v1\nstring(\"x\")\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if path := corpusPath("FuzzFoo/crash", []any{"x"}); path != entry {
+		t.Errorf("unexpected corpus path %q", path)
+	}
+	generated, _ := CorpusPath("FuzzFoo", "x")
+	if path := corpusPath("FuzzFoo/seed#0", []any{"x"}); path != generated {
+		t.Errorf("unexpected corpus path %q, expected %q", path, generated)
+	}
+}
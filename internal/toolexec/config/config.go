@@ -0,0 +1,323 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package config holds the YAML configuration of the toolexec proxies and
+// the wiring of the processors it describes
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/processors"
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// CallRule redirects the calls to a function to a wrapper function
+type CallRule struct {
+	// Replace is the rule, in the `importpath.Func -> importpath.Wrapper` format
+	Replace string `yaml:"replace"`
+	// Source is the directory of the wrapper package, when it must be injected in the build
+	Source string `yaml:"source,omitempty"`
+}
+
+// HooksConfig describes the prologue inserted at the start of selected functions
+type HooksConfig struct {
+	// Package is the import path of the hook package
+	Package string `yaml:"package"`
+	// Source is the directory of the hook package, when it must be injected in the build
+	Source string `yaml:"source,omitempty"`
+	// Prologue is the text/template of the inserted statements, rendered with a processors.PrologueData
+	Prologue string `yaml:"prologue"`
+	// Functions selects the instrumented functions
+	Functions processors.FuncSelector `yaml:"functions,omitempty"`
+}
+
+// GoroutinesConfig describes the launcher through which goroutines are started
+type GoroutinesConfig struct {
+	// Launcher is the `func(func())` launcher function, in the `importpath.Func` format
+	Launcher string `yaml:"launcher"`
+	// Source is the directory of the launcher package, when it must be injected in the build
+	Source string `yaml:"source,omitempty"`
+}
+
+// FaultsConfig describes the calls whose failures and latency are controlled at runtime
+type FaultsConfig struct {
+	// Package is the import path of the controller package, such as the faults package of this module
+	Package string `yaml:"package"`
+	// Source is the directory of the controller package, when it must be injected in the build
+	Source string `yaml:"source,omitempty"`
+	// Calls lists the wrapped functions, such as `net/http.Get` or `database/sql.(*DB).QueryContext`
+	Calls []string `yaml:"calls"`
+}
+
+// DeterministicConfig describes the clock and random number generator substituted for the
+// time and math/rand functions in test builds
+type DeterministicConfig struct {
+	// Package is the import path of the replacement package, such as the deterministic package of this module
+	Package string `yaml:"package"`
+	// Source is the directory of the replacement package, when it must be injected in the build
+	Source string `yaml:"source,omitempty"`
+}
+
+type Config struct {
+	// Inject maps to-be-injected packages directories to their import paths
+	Inject map[string]string `yaml:"inject,omitempty"`
+	// BlankImports maps the directories of the packages blank imported by every main package,
+	// test binaries aside, to their import paths
+	BlankImports map[string]string `yaml:"blank_imports,omitempty"`
+	// Replace holds an old:new map of go files to be replaced. Old files are either paths, or
+	// `importpath:filename` rules selecting files by package import path and base name, both
+	// accepting wildcards. See processors.SwapRule
+	Replace map[string]string `yaml:"replace,omitempty"`
+	// Patches maps import path patterns to the unified diff patches applied to the Go files of
+	// the matching packages. The patches of the patterns matching a package are applied in the
+	// sorted order of the patterns
+	Patches map[string][]string `yaml:"patches,omitempty"`
+	// Generate lists the Go files rendered from templates and added to the selected packages
+	Generate []processors.GeneratedFile `yaml:"generate,omitempty"`
+	// Calls lists the rules redirecting function calls to wrappers
+	Calls []CallRule `yaml:"calls,omitempty"`
+	// Hooks inserts a prologue at the start of selected functions
+	Hooks *HooksConfig `yaml:"hooks,omitempty"`
+	// Goroutines starts goroutines through a launcher function
+	Goroutines *GoroutinesConfig `yaml:"goroutines,omitempty"`
+	// Faults wraps calls so that a controller package can make them fail or slow them down
+	Faults *FaultsConfig `yaml:"faults,omitempty"`
+	// Deterministic makes time and randomness of the code under test controllable in test builds
+	Deterministic *DeterministicConfig `yaml:"deterministic,omitempty"`
+	// Verify is the policy applied when an injected package is missing from a linked binary
+	// (off, warn or fail). Injected packages are not verified when empty
+	Verify string `yaml:"verify,omitempty"`
+	// Packages selects the packages all processors apply to
+	Packages processors.PackageSelector `yaml:"packages,omitempty"`
+	// Selectors maps processor names to the packages they apply to, among the ones selected by
+	// Packages. It is the only way to select the packages of a single processor. See ProcessorNames
+	Selectors map[string]processors.PackageSelector `yaml:"selectors,omitempty"`
+
+	// replaceRules are the `importpath:filename` entries of Replace
+	replaceRules []processors.SwapRule
+	// callReplacements are the parsed Calls rules
+	callReplacements []processors.CallReplacement
+	// funcHooks is the processor described by Hooks
+	funcHooks *processors.FuncHooks
+	// goLauncher is the processor described by Goroutines
+	goLauncher *processors.GoLauncher
+	// faultInjector is the processor described by Faults
+	faultInjector *processors.FaultInjector
+	// deterministicRewriter is the processor described by Deterministic
+	deterministicRewriter *processors.DeterministicRewriter
+}
+
+// ProcessorNames are the names of the processors in the Selectors of a configuration
+var ProcessorNames = []string{
+	"gotest",
+	"patches",
+	"generate",
+	"replace",
+	"inject",
+	"calls",
+	"hooks",
+	"goroutines",
+	"faults",
+	"deterministic",
+	"blank_imports",
+}
+
+// Selector returns the selector of the packages the processor named name applies to
+func (cfg *Config) Selector(name string) processors.PackageSelector {
+	selector, ok := cfg.Selectors[name]
+	if !ok {
+		return cfg.Packages
+	}
+	return processors.PackageSelector{And: []processors.PackageSelector{cfg.Packages, selector}}
+}
+
+// Parse reads the YAML configuration file at path. Relative file paths
+// are resolved against the current working directory
+func Parse(path string) (Config, error) {
+	var cfg Config
+	yamlFile, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err = yaml.Unmarshal(yamlFile, &cfg); err != nil {
+		return cfg, err
+	}
+	if cfg.Verify != "" {
+		if _, err = processors.ParseVerifyPolicy(cfg.Verify); err != nil {
+			return cfg, err
+		}
+	}
+	for name := range cfg.Selectors {
+		if !slices.Contains(ProcessorNames, name) {
+			return cfg, fmt.Errorf("selectors: unknown processor %q, expected one of %s", name, strings.Join(ProcessorNames, ", "))
+		}
+	}
+
+	absReplace := make(map[string]string, len(cfg.Replace))
+	for src, dst := range cfg.Replace {
+		delete(cfg.Replace, src)
+		dstAbs, _ := filepath.Abs(dst)
+		if strings.Contains(src, ":") {
+			rule, err := processors.ParseSwapRule(src, dstAbs)
+			if err != nil {
+				return cfg, err
+			}
+			cfg.replaceRules = append(cfg.replaceRules, rule)
+			continue
+		}
+		srcAbs, _ := filepath.Abs(src)
+		absReplace[srcAbs] = dstAbs
+	}
+	cfg.Replace = absReplace
+
+	for importPath, patches := range cfg.Patches {
+		for i, patch := range patches {
+			patches[i], _ = filepath.Abs(patch)
+		}
+		cfg.Patches[importPath] = patches
+	}
+
+	for i := range cfg.Generate {
+		cfg.Generate[i].Template, _ = filepath.Abs(cfg.Generate[i].Template)
+	}
+
+	for _, rule := range cfg.Calls {
+		source := rule.Source
+		if source != "" {
+			source, _ = filepath.Abs(source)
+		}
+		repl, err := processors.ParseCallReplacement(rule.Replace, source)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.callReplacements = append(cfg.callReplacements, repl)
+	}
+
+	if cfg.Hooks != nil {
+		source := cfg.Hooks.Source
+		if source != "" {
+			source, _ = filepath.Abs(source)
+		}
+		hooks, err := processors.NewFuncHooks(cfg.Hooks.Package, source, cfg.Hooks.Prologue, cfg.Hooks.Functions)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.funcHooks = &hooks
+	}
+
+	if cfg.Goroutines != nil {
+		launcher, err := processors.ParseFuncRef(cfg.Goroutines.Launcher)
+		if err != nil {
+			return cfg, err
+		}
+		source := cfg.Goroutines.Source
+		if source != "" {
+			source, _ = filepath.Abs(source)
+		}
+		goLauncher := processors.NewGoLauncher(launcher, source)
+		cfg.goLauncher = &goLauncher
+	}
+
+	if cfg.Faults != nil {
+		if cfg.Faults.Package == "" || len(cfg.Faults.Calls) == 0 {
+			return cfg, fmt.Errorf("faults: package and calls are required")
+		}
+		source := cfg.Faults.Source
+		if source != "" {
+			source, _ = filepath.Abs(source)
+		}
+		injector := processors.NewFaultInjector(cfg.Faults.Package, source, cfg.Faults.Calls)
+		cfg.faultInjector = &injector
+	}
+
+	if cfg.Deterministic != nil {
+		if cfg.Deterministic.Package == "" {
+			return cfg, fmt.Errorf("deterministic: package is required")
+		}
+		source := cfg.Deterministic.Source
+		if source != "" {
+			source, _ = filepath.Abs(source)
+		}
+		rewriter := processors.NewDeterministicRewriter(cfg.Deterministic.Package, source)
+		cfg.deterministicRewriter = &rewriter
+	}
+	return cfg, err
+}
+
+// Process applies the processors described by cfg to cmd
+func (cfg *Config) Process(cmd proxy.Command) {
+	if len(cfg.Patches) > 0 {
+		patcher := processors.NewPatchApplier(cfg.Patches)
+		patcher.Selector = cfg.Selector("patches")
+		proxy.ProcessCommand(cmd, patcher.ProcessCompile)
+	}
+	if len(cfg.Generate) > 0 {
+		generator := processors.NewFileGenerator(cfg.Generate)
+		generator.Selector = cfg.Selector("generate")
+		proxy.ProcessCommand(cmd, generator.ProcessCompile)
+	}
+	if len(cfg.Replace) > 0 || len(cfg.replaceRules) > 0 {
+		swapper := processors.NewGoFileSwapperWithRules(cfg.Replace, cfg.replaceRules)
+		swapper.Selector = cfg.Selector("replace")
+		proxy.ProcessCommand(cmd, swapper.ProcessCompile)
+	}
+	for path, importPath := range cfg.Inject {
+		pkgInj := processors.NewPackageInjector(importPath, path)
+		pkgInj.Selector = cfg.Selector("inject")
+		proxy.ProcessCommand(cmd, pkgInj.ProcessCompile)
+		proxy.ProcessCommand(cmd, pkgInj.ProcessLink)
+	}
+	if len(cfg.callReplacements) > 0 {
+		replacer := processors.NewCallReplacer(cfg.callReplacements)
+		replacer.Selector = cfg.Selector("calls")
+		proxy.ProcessCommand(cmd, replacer.ProcessCompile)
+		proxy.ProcessCommand(cmd, replacer.ProcessLink)
+	}
+	if cfg.funcHooks != nil {
+		cfg.funcHooks.Selector = cfg.Selector("hooks")
+		proxy.ProcessCommand(cmd, cfg.funcHooks.ProcessCompile)
+		proxy.ProcessCommand(cmd, cfg.funcHooks.ProcessLink)
+	}
+	if cfg.goLauncher != nil {
+		cfg.goLauncher.Selector = cfg.Selector("goroutines")
+		proxy.ProcessCommand(cmd, cfg.goLauncher.ProcessCompile)
+		proxy.ProcessCommand(cmd, cfg.goLauncher.ProcessLink)
+	}
+	if cfg.faultInjector != nil {
+		cfg.faultInjector.Selector = cfg.Selector("faults")
+		proxy.ProcessCommand(cmd, cfg.faultInjector.ProcessCompile)
+		proxy.ProcessCommand(cmd, cfg.faultInjector.ProcessLink)
+	}
+	if cfg.deterministicRewriter != nil {
+		cfg.deterministicRewriter.Selector = cfg.Selector("deterministic")
+		proxy.ProcessCommand(cmd, cfg.deterministicRewriter.ProcessCompile)
+		proxy.ProcessCommand(cmd, cfg.deterministicRewriter.ProcessLink)
+	}
+	if len(cfg.BlankImports) > 0 {
+		importer := processors.NewBlankImporter(cfg.BlankImports)
+		importer.Selector = cfg.Selector("blank_imports")
+		proxy.ProcessCommand(cmd, importer.ProcessCompile)
+		proxy.ProcessCommand(cmd, importer.ProcessLink)
+	}
+	if cfg.Verify != "" {
+		policy, _ := processors.ParseVerifyPolicy(cfg.Verify)
+		importPaths := make([]string, 0, len(cfg.Inject)+len(cfg.BlankImports))
+		for _, importPath := range cfg.Inject {
+			importPaths = append(importPaths, importPath)
+		}
+		for _, importPath := range cfg.BlankImports {
+			importPaths = append(importPaths, importPath)
+		}
+		verifier := processors.NewLinkVerifier(policy, importPaths...)
+		proxy.RegisterPostProcessor(cmd, verifier.PostProcessLink)
+	}
+}
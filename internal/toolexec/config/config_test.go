@@ -0,0 +1,59 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelectors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+packages:
+  include: ["github.com/foo/..."]
+selectors:
+  gotest:
+    exclude: [".../vendor/..."]
+  faults:
+    include: ["github.com/foo/.../internal"]
+`), 0644))
+	cfg, err := Parse(path)
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		processor  string
+		importPath string
+		match      bool
+	}{
+		"gotest":                {processor: "gotest", importPath: "github.com/foo/bar", match: true},
+		"gotest/vendor":         {processor: "gotest", importPath: "github.com/foo/vendor/github.com/baz"},
+		"gotest/global":         {processor: "gotest", importPath: "github.com/baz"},
+		"inject/vendor":         {processor: "inject", importPath: "github.com/foo/vendor/github.com/baz", match: true},
+		"inject/global":         {processor: "inject", importPath: "github.com/baz"},
+		"blank_imports/package": {processor: "blank_imports", importPath: "github.com/foo/bar", match: true},
+		"faults/internal":       {processor: "faults", importPath: "github.com/foo/bar/internal", match: true},
+		"faults/parent":         {processor: "faults", importPath: "github.com/foo/internal"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cmd := proxy.MustParseCommand([]string{"compile", "-o", "/work/b002/_pkg_.a", "-p", tc.importPath, "/src/bar.go"}).(*proxy.CompileCommand)
+			selector := cfg.Selector(tc.processor)
+			require.Equal(t, tc.match, selector.Match(cmd))
+		})
+	}
+}
+
+func TestSelectorsUnknownProcessor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cfg.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("selectors:\n  gotests:\n    test: true\n"), 0644))
+	_, err := Parse(path)
+	require.ErrorContains(t, err, `unknown processor "gotests"`)
+}
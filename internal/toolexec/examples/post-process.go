@@ -0,0 +1,35 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package examples
+
+import (
+	"log"
+	"os"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/utils"
+)
+
+func ExampleRecordArtifactSize() {
+	// In a real use case command arguments should be populated by reading from os.Args
+	args := []string{"/random/link", "-o", "/tmp/randomBuild/b001/exe/a.out", "-importcfg", "/tmp/randomBuild/b001/importcfg.link", "/tmp/randomBuild/b001/_pkg_.a"}
+	cmd, err := proxy.ParseCommand(args)
+	utils.ExitIfError(err)
+	proxy.RegisterPostProcessor(cmd, RecordArtifactSize)
+	proxy.MustRunCommand(cmd)
+}
+
+func RecordArtifactSize(cmd *proxy.LinkCommand, res proxy.CommandResult) error {
+	if res.ExitCode != 0 {
+		return nil
+	}
+	for _, output := range res.Outputs {
+		if info, err := os.Stat(output); err == nil {
+			log.Printf("[%s] %s: %d bytes (%s)\n", cmd.Stage(), output, info.Size(), res.Duration)
+		}
+	}
+	return nil
+}
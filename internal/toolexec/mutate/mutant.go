@@ -0,0 +1,200 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package mutate
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"sort"
+)
+
+// Kind is the kind of change introduced by a mutant
+type Kind string
+
+const (
+	// KindConditional negates a comparison or a boolean expression
+	KindConditional Kind = "conditional"
+	// KindArithmetic changes an arithmetic operator
+	KindArithmetic Kind = "arithmetic"
+	// KindBoundary includes or excludes the boundary of a comparison
+	KindBoundary Kind = "boundary"
+	// KindStatement removes a statement
+	KindStatement Kind = "statement"
+)
+
+// Mutant is a single change of a Go file
+type Mutant struct {
+	// File is the path of the mutated file
+	File   string
+	Line   int
+	Column int
+	Kind   Kind
+	// Description describes the change, such as "replaced + with -"
+	Description string
+
+	// offset and end delimit the replaced bytes of the file
+	offset, end int
+	replacement string
+}
+
+func (m Mutant) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", m.File, m.Line, m.Column, m.Kind, m.Description)
+}
+
+// Apply returns the source of the mutated file
+func (m Mutant) Apply(src []byte) []byte {
+	mutated := make([]byte, 0, len(src)-(m.end-m.offset)+len(m.replacement))
+	mutated = append(mutated, src[:m.offset]...)
+	mutated = append(mutated, m.replacement...)
+	return append(mutated, src[m.end:]...)
+}
+
+var (
+	// conditionals maps operators to their negation
+	conditionals = map[token.Token]token.Token{
+		token.EQL:  token.NEQ,
+		token.NEQ:  token.EQL,
+		token.LSS:  token.GEQ,
+		token.GEQ:  token.LSS,
+		token.GTR:  token.LEQ,
+		token.LEQ:  token.GTR,
+		token.LAND: token.LOR,
+		token.LOR:  token.LAND,
+	}
+	// boundaries maps comparison operators to the operator including or excluding their boundary
+	boundaries = map[token.Token]token.Token{
+		token.LSS: token.LEQ,
+		token.LEQ: token.LSS,
+		token.GTR: token.GEQ,
+		token.GEQ: token.GTR,
+	}
+	// arithmetics maps arithmetic operators to their replacement
+	arithmetics = map[token.Token]token.Token{
+		token.ADD:        token.SUB,
+		token.SUB:        token.ADD,
+		token.MUL:        token.QUO,
+		token.QUO:        token.MUL,
+		token.REM:        token.MUL,
+		token.ADD_ASSIGN: token.SUB_ASSIGN,
+		token.SUB_ASSIGN: token.ADD_ASSIGN,
+		token.MUL_ASSIGN: token.QUO_ASSIGN,
+		token.QUO_ASSIGN: token.MUL_ASSIGN,
+		token.INC:        token.DEC,
+		token.DEC:        token.INC,
+	}
+)
+
+// Enumerate returns the mutants of the Go file at path with source src. Generated files
+// have no mutants. Mutants keep the lines of the file unchanged, so that the positions
+// reported against the mutated file are valid in the original one
+func Enumerate(path string, src []byte) ([]Mutant, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if ast.IsGenerated(file) {
+		return nil, nil
+	}
+
+	var mutants []Mutant
+	add := func(pos token.Pos, end token.Pos, kind Kind, replacement, description string) {
+		p := fset.Position(pos)
+		mutants = append(mutants, Mutant{
+			File:        path,
+			Line:        p.Line,
+			Column:      p.Column,
+			Kind:        kind,
+			Description: description,
+			offset:      p.Offset,
+			end:         fset.Position(end).Offset,
+			replacement: replacement,
+		})
+	}
+	replaceOp := func(pos token.Pos, op, repl token.Token, kind Kind) {
+		add(pos, pos+token.Pos(len(op.String())), kind, repl.String(), fmt.Sprintf("replaced %s with %s", op, repl))
+	}
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.GenDecl:
+			// Constant expressions are often array lengths or other compile time values
+			return x.Tok != token.CONST
+		case *ast.BinaryExpr:
+			if repl, ok := conditionals[x.Op]; ok {
+				replaceOp(x.OpPos, x.Op, repl, KindConditional)
+			}
+			if repl, ok := boundaries[x.Op]; ok {
+				replaceOp(x.OpPos, x.Op, repl, KindBoundary)
+			}
+			if repl, ok := arithmetics[x.Op]; ok {
+				replaceOp(x.OpPos, x.Op, repl, KindArithmetic)
+			}
+		case *ast.UnaryExpr:
+			if x.Op == token.NOT {
+				add(x.OpPos, x.OpPos+1, KindConditional, "", "removed !")
+			}
+		case *ast.AssignStmt:
+			if repl, ok := arithmetics[x.Tok]; ok {
+				replaceOp(x.TokPos, x.Tok, repl, KindArithmetic)
+			}
+		case *ast.IncDecStmt:
+			replaceOp(x.TokPos, x.Tok, arithmetics[x.Tok], KindArithmetic)
+		case *ast.BlockStmt:
+			removeStatements(x.List, add)
+		case *ast.CaseClause:
+			removeStatements(x.Body, add)
+		case *ast.CommClause:
+			removeStatements(x.Body, add)
+		}
+		return true
+	})
+
+	// Blank out removed statements while keeping their line breaks
+	for i, m := range mutants {
+		if m.Kind == KindStatement {
+			mutants[i].replacement = string(blank(src[m.offset:m.end]))
+		}
+	}
+	sort.SliceStable(mutants, func(i, j int) bool {
+		return mutants[i].offset < mutants[j].offset
+	})
+	return mutants, nil
+}
+
+// removeStatements adds the mutants removing the statements of a statement list whose
+// removal is likely to compile: calls, increments and assignments to existing variables
+func removeStatements(stmts []ast.Stmt, add func(pos, end token.Pos, kind Kind, replacement, description string)) {
+	for _, stmt := range stmts {
+		switch x := stmt.(type) {
+		case *ast.ExprStmt:
+			if _, ok := x.X.(*ast.CallExpr); !ok {
+				continue
+			}
+		case *ast.IncDecStmt:
+		case *ast.AssignStmt:
+			if x.Tok == token.DEFINE {
+				continue
+			}
+		default:
+			continue
+		}
+		add(stmt.Pos(), stmt.End(), KindStatement, "", "removed statement")
+	}
+}
+
+// blank replaces the characters of src other than line breaks with spaces
+func blank(src []byte) []byte {
+	return bytes.Map(func(r rune) rune {
+		if r == '\n' {
+			return r
+		}
+		return ' '
+	}, src)
+}
@@ -0,0 +1,78 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package mutate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnumerate(t *testing.T) {
+	src := []byte(`package calc
+
+const size = 1 + 2
+
+func Clamp(x, max int) int {
+	if x > max && !negative(x) {
+		return max
+	}
+	x += 1
+	log(x,
+		max)
+	return x
+}
+`)
+	mutants, err := Enumerate("calc.go", src)
+	require.NoError(t, err)
+
+	var described []string
+	for _, m := range mutants {
+		described = append(described, m.String())
+	}
+	require.Equal(t, []string{
+		"calc.go:6:7: conditional: replaced > with <=",
+		"calc.go:6:7: boundary: replaced > with >=",
+		"calc.go:6:13: conditional: replaced && with ||",
+		"calc.go:6:16: conditional: removed !",
+		"calc.go:9:2: statement: removed statement",
+		"calc.go:9:4: arithmetic: replaced += with -=",
+		"calc.go:10:2: statement: removed statement",
+	}, described)
+
+	require.Equal(t, `package calc
+
+const size = 1 + 2
+
+func Clamp(x, max int) int {
+	if x > max && negative(x) {
+		return max
+	}
+	x += 1
+	log(x,
+		max)
+	return x
+}
+`, string(mutants[3].Apply(src)))
+
+	// Removed statements keep the following lines in place
+	removed := string(mutants[6].Apply(src))
+	require.NotContains(t, removed, "log(")
+	require.Equal(t, strings.Count(string(src), "\n"), strings.Count(removed, "\n"))
+	require.Contains(t, removed, "\n\treturn x\n}\n")
+}
+
+func TestEnumerateGenerated(t *testing.T) {
+	mutants, err := Enumerate("gen.go", []byte(`// Code generated by stringer. DO NOT EDIT.
+
+package calc
+
+func F(a, b int) int { return a + b }
+`))
+	require.NoError(t, err)
+	require.Empty(t, mutants)
+}
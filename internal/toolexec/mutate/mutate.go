@@ -0,0 +1,250 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package mutate implements the mutate subcommand, which measures the quality of the tests of
+// selected packages by checking whether they fail when the package code is mutated.
+// Each mutant is compiled by the toolexec proxy substituting the mutated file for the original
+// one, and is killed when the package tests fail
+package mutate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Status is the outcome of the tests run against a mutant
+type Status string
+
+const (
+	// StatusKilled means that the tests failed
+	StatusKilled Status = "KILLED"
+	// StatusSurvived means that the tests passed
+	StatusSurvived Status = "SURVIVED"
+	// StatusNotViable means that the mutant doesn't compile
+	StatusNotViable Status = "NOT VIABLE"
+)
+
+// Options configure a mutation testing run
+type Options struct {
+	// Toolexec is the toolexec proxy building the mutants. It defaults to the running executable
+	Toolexec string
+	// Timeout is the timeout of the tests run against each mutant
+	Timeout time.Duration
+	// Run is passed to `go test -run`
+	Run string
+	// Dir is the directory the go commands run in
+	Dir string
+	// Output receives the report
+	Output io.Writer
+}
+
+// Result is the outcome of the tests run against a mutant
+type Result struct {
+	Mutant Mutant
+	Status Status
+}
+
+// Summary counts the results of a package
+type Summary struct {
+	Killed, Survived, NotViable int
+}
+
+// Score returns the ratio of killed mutants among the viable ones
+func (s Summary) Score() float64 {
+	if s.Killed+s.Survived == 0 {
+		return 0
+	}
+	return float64(s.Killed) / float64(s.Killed+s.Survived)
+}
+
+// Main runs the mutate subcommand with its command line arguments and returns its exit code:
+//
+//	mutate [-timeout d] [-run regexp] [packages]
+func Main(args []string) int {
+	opts := Options{Output: os.Stdout}
+	fs := flag.NewFlagSet("mutate", flag.ContinueOnError)
+	fs.DurationVar(&opts.Timeout, "timeout", time.Minute, "timeout of the tests run against each mutant")
+	fs.StringVar(&opts.Run, "run", "", "run only the tests matching the regular expression")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	patterns := fs.Args()
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+
+	if err := Run(patterns, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "mutate: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// listedPackage is the output of `go list -json`
+type listedPackage struct {
+	ImportPath   string
+	Dir          string
+	GoFiles      []string
+	TestGoFiles  []string
+	XTestGoFiles []string
+}
+
+// Run enumerates the mutants of the packages matching patterns, runs the package tests
+// against each of them and reports the killed and surviving mutants
+func Run(patterns []string, opts Options) error {
+	if opts.Toolexec == "" {
+		self, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		opts.Toolexec = self
+	}
+	if opts.Output == nil {
+		opts.Output = io.Discard
+	}
+
+	pkgs, err := listPackages(opts.Dir, patterns)
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "dd-mutate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for _, pkg := range pkgs {
+		if len(pkg.TestGoFiles)+len(pkg.XTestGoFiles) == 0 {
+			fmt.Fprintf(opts.Output, "%s: no test files\n", pkg.ImportPath)
+			continue
+		}
+		if out, err := goTest(pkg, opts, nil); err != nil {
+			fmt.Fprintf(opts.Output, "%s: tests fail without mutants, skipping\n%s", pkg.ImportPath, out)
+			continue
+		}
+
+		var summary Summary
+		n := 0
+		for _, name := range pkg.GoFiles {
+			path := filepath.Join(pkg.Dir, name)
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			mutants, err := Enumerate(path, src)
+			if err != nil {
+				return err
+			}
+			for _, m := range mutants {
+				n++
+				mutantFile := filepath.Join(tmpDir, strconv.Itoa(n), name)
+				if err := os.MkdirAll(filepath.Dir(mutantFile), 0755); err != nil {
+					return err
+				}
+				if err := os.WriteFile(mutantFile, m.Apply(src), 0644); err != nil {
+					return err
+				}
+				res, err := testMutant(pkg, m, mutantFile, opts)
+				if err != nil {
+					return err
+				}
+				switch res.Status {
+				case StatusKilled:
+					summary.Killed++
+				case StatusSurvived:
+					summary.Survived++
+				case StatusNotViable:
+					summary.NotViable++
+				}
+				fmt.Fprintf(opts.Output, "%-10s %s\n", res.Status, displayMutant(m, opts.Dir))
+			}
+		}
+		fmt.Fprintf(opts.Output, "%s: %d mutants, %d killed, %d survived, %d not viable (score %.1f%%)\n",
+			pkg.ImportPath, n, summary.Killed, summary.Survived, summary.NotViable, summary.Score()*100)
+	}
+	return nil
+}
+
+// listPackages lists the packages matching patterns
+func listPackages(dir string, patterns []string) ([]listedPackage, error) {
+	cmd := exec.Command("go", append([]string{"list", "-json=ImportPath,Dir,GoFiles,TestGoFiles,XTestGoFiles"}, patterns...)...)
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list: %w", err)
+	}
+
+	var pkgs []listedPackage
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var pkg listedPackage
+		if err := dec.Decode(&pkg); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// testMutant runs the package tests against the mutant written at mutantFile
+func testMutant(pkg listedPackage, m Mutant, mutantFile string, opts Options) (Result, error) {
+	out, err := goTest(pkg, opts, []string{
+		"-toolexec", opts.Toolexec,
+		"-gcflags", pkg.ImportPath + "=" + Flag(mutantFile),
+	})
+	res := Result{Mutant: m, Status: StatusSurvived}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+	case !errors.As(err, &exitErr):
+		return res, err
+	case bytes.Contains(out, []byte("[build failed]")) || bytes.Contains(out, []byte("[setup failed]")):
+		res.Status = StatusNotViable
+	default:
+		res.Status = StatusKilled
+	}
+	return res, nil
+}
+
+// goTest runs the tests of pkg with the extra go test flags
+func goTest(pkg listedPackage, opts Options, flags []string) ([]byte, error) {
+	args := []string{"test", "-count=1"}
+	if opts.Timeout > 0 {
+		args = append(args, "-timeout", opts.Timeout.String())
+	}
+	if opts.Run != "" {
+		args = append(args, "-run", opts.Run)
+	}
+	args = append(append(args, flags...), pkg.ImportPath)
+
+	cmd := exec.Command("go", args...)
+	cmd.Dir = opts.Dir
+	cmd.Env = append(os.Environ(), EnvVar+"=1")
+	return cmd.CombinedOutput()
+}
+
+// displayMutant formats m with its file path relative to dir when possible
+func displayMutant(m Mutant, dir string) string {
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	if rel, err := filepath.Rel(dir, m.File); err == nil && !filepath.IsAbs(rel) && len(rel) > 0 && rel[0] != '.' {
+		m.File = rel
+	}
+	return m.String()
+}
@@ -0,0 +1,85 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package mutate
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(EnvVar) != "" && len(os.Args) > 1 && filepath.IsAbs(os.Args[1]) {
+		// The test binary is the toolexec proxy of the mutant builds
+		log.SetOutput(io.Discard)
+		cmd := proxy.MustParseCommand(os.Args[1:])
+		proxy.ProcessCommand(cmd, ProcessCompile)
+		proxy.MustRunCommand(cmd)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and tests every mutant")
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/calc\n\ngo 1.21\n",
+		"calc.go": `package calc
+
+func Add(a, b int) int {
+	return a + b
+}
+
+func Small(x int) bool {
+	return x < 10
+}
+`,
+		"calc_test.go": `package calc
+
+import "testing"
+
+func TestAdd(t *testing.T) {
+	if Add(2, 3) != 5 {
+		t.Fatal("Add")
+	}
+}
+
+func TestSmall(t *testing.T) {
+	if !Small(1) || Small(20) {
+		t.Fatal("Small")
+	}
+}
+`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	var out bytes.Buffer
+	err := Run([]string{"./..."}, Options{Dir: dir, Timeout: time.Minute, Output: &out})
+	require.NoError(t, err)
+
+	// The boundary of Small isn't tested
+	require.Equal(t, strings.Join([]string{
+		"KILLED     calc.go:4:11: arithmetic: replaced + with -",
+		"KILLED     calc.go:8:11: conditional: replaced < with >=",
+		"SURVIVED   calc.go:8:11: boundary: replaced < with <=",
+		"example.com/calc: 3 mutants, 2 killed, 1 survived, 0 not viable (score 66.7%)",
+		"",
+	}, "\n"), out.String())
+}
@@ -0,0 +1,57 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package mutate
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/processors"
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// EnvVar is set in the environment of the go commands building mutants, in which the
+// toolexec proxy only substitutes mutated files
+const EnvVar = "DD_TOOLEXEC_MUTATE"
+
+// flagPrefix prefixes the compiler flag passing the mutated file of a package. The flag is
+// passed through -gcflags so that the go command caches each mutant separately, and removed
+// by ProcessCompile before running the compiler
+const flagPrefix = "-dd_mutant="
+
+// Flag returns the compiler flag substituting mutantFile for the package file with the same base name
+func Flag(mutantFile string) string {
+	return flagPrefix + mutantFile
+}
+
+// ProcessCompile visits a compile command and substitutes the mutated file passed with Flag
+func ProcessCompile(cmd *proxy.CompileCommand) {
+	var flags []string
+	for _, arg := range cmd.Args() {
+		if strings.HasPrefix(arg, flagPrefix) {
+			flags = append(flags, arg)
+		}
+	}
+
+	swapMap := make(map[string]string)
+	for _, arg := range flags {
+		mutantFile := strings.TrimPrefix(arg, flagPrefix)
+		if err := cmd.RemoveParam(arg); err != nil {
+			log.Printf("couldn't remove param: %v\n", err)
+		}
+		for _, file := range cmd.GoFiles() {
+			if filepath.Base(file) == filepath.Base(mutantFile) {
+				swapMap[file] = mutantFile
+			}
+		}
+	}
+	if len(swapMap) == 0 {
+		return
+	}
+	swapper := processors.NewGoFileSwapper(swapMap)
+	swapper.ProcessCompile(cmd)
+}
@@ -0,0 +1,32 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package mutate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+func TestProcessCompile(t *testing.T) {
+	cmd, err := proxy.ParseCommand([]string{
+		"/usr/local/go/pkg/tool/linux_amd64/compile", "-o", "/tmp/work/b002/_pkg_.a", "-trimpath", "/tmp/work/b002=>",
+		"-p", "example.com/calc", "-importcfg", "/tmp/work/b002/importcfg",
+		Flag("/tmp/mutants/3/calc.go"), "-pack", "/src/calc/calc.go", "/src/calc/util.go",
+	})
+	require.NoError(t, err)
+	compileCmd := cmd.(*proxy.CompileCommand)
+
+	ProcessCompile(compileCmd)
+	require.Equal(t, []string{
+		"/usr/local/go/pkg/tool/linux_amd64/compile", "-o", "/tmp/work/b002/_pkg_.a", "-trimpath", "/tmp/work/b002=>",
+		"-p", "example.com/calc", "-importcfg", "/tmp/work/b002/importcfg",
+		"-pack", "/tmp/mutants/3/calc.go", "/src/calc/util.go",
+	}, compileCmd.Args())
+	require.Equal(t, "/src/calc/calc.go", compileCmd.PathMappings()["/tmp/mutants/3/calc.go"].Original)
+}
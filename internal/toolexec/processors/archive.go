@@ -0,0 +1,121 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// archiveMagic starts the ar archives written by the compiler
+	archiveMagic = "!<arch>\n"
+	// archiveHeaderSize is the size of the header of every archive member
+	archiveHeaderSize = 60
+	// objectMember is the name of the archive member holding the object file of the package
+	objectMember = "_go_.o"
+	// objectMagic starts the object file, see cmd/internal/goobj
+	objectMagic = "\x00go120ld"
+	// importedPkgSize is the size of an entry of the autolib block of the object file, a string
+	// reference (length and offset) followed by the fingerprint of the package
+	importedPkgSize = 4 + 4 + 8
+)
+
+// archiveReferences reports whether the package compiled into the archive at path imports
+// importPath. The archive layout and the object file format are those of the current toolchains:
+// when they aren't recognized, the whole archive is scanned for importPath instead, which may
+// also report packages merely mentioning it
+func archiveReferences(path, importPath string) (bool, error) {
+	imports, err := archiveImports(path)
+	if err == nil {
+		return slices.Contains(imports, importPath), nil
+	}
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false, err
+	}
+	fmt.Fprintf(os.Stderr, "warning: %v, scanning the whole archive for %s\n", err, importPath)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(data, []byte(importPath)), nil
+}
+
+// archiveImports returns the import paths of the packages the package compiled into the archive
+// at path depends on, as read by the linker. Blank imports are included
+func archiveImports(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := archiveMember(data, objectMember)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	imports, err := objectImports(obj)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return imports, nil
+}
+
+// archiveMember returns the content of the member of the ar archive data named name
+func archiveMember(data []byte, name string) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(archiveMagic)) {
+		return nil, errors.New("not an archive")
+	}
+	for off := len(archiveMagic); off+archiveHeaderSize <= len(data); {
+		header := data[off : off+archiveHeaderSize]
+		size, err := strconv.Atoi(strings.TrimSpace(string(header[48:58])))
+		if err != nil || size < 0 || off+archiveHeaderSize+size > len(data) {
+			return nil, fmt.Errorf("invalid archive header at %d", off)
+		}
+		off += archiveHeaderSize
+		if strings.TrimSpace(string(header[:16])) == name {
+			return data[off : off+size], nil
+		}
+		// Members are aligned on even offsets
+		off += size + size%2
+	}
+	return nil, fmt.Errorf("no %s in archive", name)
+}
+
+// objectImports returns the packages listed in the autolib block of the object file data
+func objectImports(data []byte) ([]string, error) {
+	start := bytes.Index(data, []byte(objectMagic))
+	if start < 0 {
+		return nil, errors.New("not a Go object file")
+	}
+	obj := data[start:]
+	// The magic is followed by the fingerprint, the flags and the offsets of the blocks,
+	// starting with the autolib block
+	offsets := len(objectMagic) + 8 + 4
+	if len(obj) < offsets+8 {
+		return nil, errors.New("truncated object file")
+	}
+	begin := binary.LittleEndian.Uint32(obj[offsets:])
+	end := binary.LittleEndian.Uint32(obj[offsets+4:])
+	if begin > end || int(end) > len(obj) {
+		return nil, errors.New("invalid autolib block")
+	}
+
+	imports := make([]string, 0, (end-begin)/importedPkgSize)
+	for off := begin; off+importedPkgSize <= end; off += importedPkgSize {
+		length := binary.LittleEndian.Uint32(obj[off:])
+		strOff := binary.LittleEndian.Uint32(obj[off+4:])
+		if uint64(strOff)+uint64(length) > uint64(len(obj)) {
+			return nil, errors.New("invalid import path reference")
+		}
+		imports = append(imports, string(obj[strOff:strOff+length]))
+	}
+	return imports, nil
+}
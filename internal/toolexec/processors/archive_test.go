@@ -0,0 +1,100 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// buildArchives writes the packages of the example.com/app module, keyed by their directory, and
+// returns the paths of their compiled archives
+func buildArchives(t *testing.T, packages map[string]string) map[string]string {
+	dir := t.TempDir()
+	files := map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n"}
+	for pkg, content := range packages {
+		files[filepath.Join(pkg, "pkg.go")] = content
+	}
+	writeFiles(t, dir, files)
+
+	archives := make(map[string]string, len(packages))
+	for pkg := range packages {
+		archive := filepath.Join(dir, pkg+".a")
+		cmd := exec.Command("go", "build", "-o", archive, "./"+pkg)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		archives[pkg] = archive
+	}
+	return archives
+}
+
+func TestArchiveImports(t *testing.T) {
+	archives := buildArchives(t, map[string]string{
+		"agent": "package agent\n",
+		"lib":   "package lib\n\nimport (\n\t\"fmt\"\n\n\t_ \"example.com/app/agent\"\n)\n\nfunc Hello() { fmt.Println(\"hello\") }\n",
+	})
+
+	imports, err := archiveImports(archives["lib"])
+	require.NoError(t, err)
+	// Blank imports are listed, as the linker needs them
+	require.ElementsMatch(t, []string{"fmt", "example.com/app/agent"}, imports)
+	imports, err = archiveImports(archives["agent"])
+	require.NoError(t, err)
+	require.Empty(t, imports)
+
+	invalid := filepath.Join(t.TempDir(), "invalid.a")
+	require.NoError(t, os.WriteFile(invalid, []byte("go object example.com/app/agent"), 0644))
+	_, err = archiveImports(invalid)
+	require.ErrorContains(t, err, "not an archive")
+	_, err = archiveImports(filepath.Join(t.TempDir(), "missing.a"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// TestArchiveReferences checks that the imports of archives of an unknown object file format, as
+// written by a future toolchain, are found by scanning the whole archive
+func TestArchiveReferences(t *testing.T) {
+	archives := buildArchives(t, map[string]string{
+		"agent": "package agent\n",
+		"lib":   "package lib\n\nimport _ \"example.com/app/agent\"\n",
+		"other": "package other\n",
+	})
+	for _, name := range []string{"lib", "other"} {
+		data, err := os.ReadFile(archives[name])
+		require.NoError(t, err)
+		require.Contains(t, string(data), objectMagic)
+		unknown := filepath.Join(t.TempDir(), name+".a")
+		require.NoError(t, os.WriteFile(unknown, bytes.ReplaceAll(data, []byte(objectMagic), []byte("\x00go999ld")), 0644))
+		archives[name+"/unknown"] = unknown
+	}
+
+	for name, tc := range map[string]struct {
+		archive    string
+		references bool
+	}{
+		"lib":           {archive: "lib", references: true},
+		"other":         {archive: "other"},
+		"lib/unknown":   {archive: "lib/unknown", references: true},
+		"other/unknown": {archive: "other/unknown"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			references, err := archiveReferences(archives[tc.archive], "example.com/app/agent")
+			require.NoError(t, err)
+			require.Equal(t, tc.references, references)
+		})
+	}
+
+	_, err := archiveImports(archives["lib/unknown"])
+	require.ErrorContains(t, err, "not a Go object file")
+	_, err = archiveReferences(filepath.Join(t.TempDir(), "missing.a"), "example.com/app/agent")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
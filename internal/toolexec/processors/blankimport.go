@@ -0,0 +1,79 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// blankImportsFileName is the name of the file synthesized in main packages
+const blankImportsFileName = "zz_dd_blank_imports.go"
+
+// BlankImporter adds blank imports of packages to every main package, so that
+// their initialization code runs in the resulting binaries. The imported packages
+// are injected in the build with a PackageInjector.
+//
+// Test binaries are left unchanged: neither the test main package generated by `go test`
+// nor main packages compiled with their `_test.go` files get the imports
+type BlankImporter struct {
+	importPaths []string
+	injectors   []PackageInjector
+	// Selector selects the main packages the imports are added to
+	Selector PackageSelector
+}
+
+// NewBlankImporter initializes a command processor that adds blank imports to main packages.
+// imports maps the source directories of the imported packages to their import paths
+func NewBlankImporter(imports map[string]string) BlankImporter {
+	b := BlankImporter{}
+	for sourceDir, importPath := range imports {
+		b.importPaths = append(b.importPaths, importPath)
+		b.injectors = append(b.injectors, NewPackageInjector(importPath, sourceDir))
+	}
+	slices.Sort(b.importPaths)
+	return b
+}
+
+// ProcessCompile visits a compile command and, if it compiles a main package, makes the imported
+// packages available to it and adds a file holding the blank imports
+func (b *BlankImporter) ProcessCompile(cmd *proxy.CompileCommand) {
+	if cmd.Flags.Package != "main" || len(cmd.GoFiles()) == 0 || IsTestCompile(cmd) || !b.Selector.Match(cmd) {
+		return
+	}
+
+	log.Printf("[%s] Adding blank imports to main package\n", cmd.Stage())
+	for i := range b.injectors {
+		proxy.ProcessCommand(cmd, b.injectors[i].ProcessCompile)
+	}
+
+	var src strings.Builder
+	src.WriteString("// Code generated by rd-toolexec. DO NOT EDIT.\n\npackage main\n\nimport (\n")
+	for _, importPath := range b.importPaths {
+		fmt.Fprintf(&src, "\t_ %q\n", importPath)
+	}
+	src.WriteString(")\n")
+
+	path := filepath.Join(filepath.Dir(cmd.Flags.Output), blankImportsFileName)
+	if err := os.WriteFile(path, []byte(src.String()), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't write %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	cmd.AddFiles([]string{path})
+}
+
+// ProcessLink visits a link command and includes the imported packages and their dependencies
+func (b *BlankImporter) ProcessLink(cmd *proxy.LinkCommand) {
+	for i := range b.injectors {
+		proxy.ProcessCommand(cmd, b.injectors[i].ProcessLink)
+	}
+}
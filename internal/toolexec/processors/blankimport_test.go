@@ -0,0 +1,88 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newBlankImportCommand returns the compile command of package pkg, with its output and its
+// importcfg in the b001 stage of a new work directory. The package is made of the main.go file
+// unless other file names are given
+func newBlankImportCommand(t *testing.T, pkg string, names ...string) *proxy.CompileCommand {
+	workDir := t.TempDir()
+	stageDir := filepath.Join(workDir, "b001")
+	if len(names) == 0 {
+		names = []string{"main.go"}
+	}
+	files := map[string]string{"b001/importcfg": "# import config\npackagefile fmt=/cache/fmt.a\n"}
+	args := []string{"/path/compile", "-o", filepath.Join(stageDir, "_pkg_.a"), "-p", pkg, "-importcfg", filepath.Join(stageDir, "importcfg")}
+	for _, name := range names {
+		files["src/"+name] = "package main\n"
+		args = append(args, filepath.Join(workDir, "src", name))
+	}
+	writeFiles(t, workDir, files)
+	return proxy.MustParseCommand(args).(*proxy.CompileCommand)
+}
+
+func TestBlankImporterSkip(t *testing.T) {
+	for name, tc := range map[string]struct {
+		pkg      string
+		files    []string
+		selector PackageSelector
+	}{
+		"non-main":   {pkg: "example.com/app/lib"},
+		"unselected": {pkg: "main", selector: PackageSelector{Exclude: []string{"main"}}},
+		"testmain":   {pkg: "main", files: []string{"_testmain.go"}},
+		"test":       {pkg: "main", files: []string{"main.go", "main_test.go"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cmd := newBlankImportCommand(t, tc.pkg, tc.files...)
+			files := cmd.GoFiles()
+			importer := NewBlankImporter(map[string]string{"/src/agent": "example.com/agent"})
+			importer.Selector = tc.selector
+			importer.ProcessCompile(cmd)
+
+			require.Equal(t, files, cmd.GoFiles())
+			require.NoFileExists(t, filepath.Join(filepath.Dir(cmd.Flags.Output), blankImportsFileName))
+		})
+	}
+}
+
+func TestBlankImporter(t *testing.T) {
+	sourceDir := t.TempDir()
+	writeFiles(t, sourceDir, map[string]string{
+		"go.mod":   "module example.com/agent\n\ngo 1.22\n",
+		"agent.go": "package agent\n",
+	})
+	cmd := newBlankImportCommand(t, "main")
+	stageDir := filepath.Dir(cmd.Flags.Output)
+	importer := NewBlankImporter(map[string]string{sourceDir: "example.com/agent"})
+	importer.ProcessCompile(cmd)
+
+	path := filepath.Join(stageDir, blankImportsFileName)
+	require.Equal(t, path, cmd.GoFiles()[1])
+	src, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "// Code generated by rd-toolexec. DO NOT EDIT.\n\npackage main\n\nimport (\n\t_ \"example.com/agent\"\n)\n", string(src))
+
+	// The imported package is built and made available to the main package and to its link
+	file, err := os.Open(cmd.Flags.ImportCfg)
+	require.NoError(t, err)
+	defer file.Close()
+	reg := parseImportConfig(file)
+	require.Contains(t, reg.PackageFile, "example.com/agent")
+	state, err := LoadFromFile(stateFilePath(filepath.Dir(stageDir)))
+	require.NoError(t, err)
+	require.Contains(t, state.Deps, "example.com/agent")
+	t.Cleanup(func() { os.RemoveAll(state.Deps["example.com/agent"].SourceDir) })
+}
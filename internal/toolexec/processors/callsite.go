@@ -0,0 +1,177 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// FuncRef references a package level function
+type FuncRef struct {
+	ImportPath string
+	Name       string
+}
+
+// CallReplacement redirects the calls to a package level function to a wrapper function
+// with the same signature
+type CallReplacement struct {
+	// Target is the function whose calls are replaced
+	Target FuncRef
+	// Wrapper is the function called instead of Target
+	Wrapper FuncRef
+	// Source is the directory of the wrapper package, which is injected in the build.
+	// It is empty when the wrapper package is already available to the compiled packages
+	Source string
+}
+
+// CallReplacer rewrites the calls to functions into calls to their wrappers
+type CallReplacer struct {
+	replacements []CallReplacement
+	// injectors maps the import paths of the wrapper packages to their injectors
+	injectors map[string]*PackageInjector
+	// Selector selects the packages whose calls are rewritten
+	Selector PackageSelector
+}
+
+// ParseFuncRef parses a function reference in the `importpath.Func` format
+func ParseFuncRef(ref string) (FuncRef, error) {
+	slash := strings.LastIndex(ref, "/")
+	dot := strings.LastIndex(ref[slash+1:], ".")
+	if dot <= 0 {
+		return FuncRef{}, fmt.Errorf("invalid function reference %q, expected importpath.Func", ref)
+	}
+	name := ref[slash+dot+2:]
+	if !token.IsIdentifier(name) {
+		return FuncRef{}, fmt.Errorf("invalid function reference %q: %q is not a function name", ref, name)
+	}
+	return FuncRef{ImportPath: ref[:slash+dot+1], Name: name}, nil
+}
+
+func (f FuncRef) String() string {
+	return f.ImportPath + "." + f.Name
+}
+
+// ParseCallReplacement parses a replacement rule in the `importpath.Func -> importpath.Wrapper` format
+func ParseCallReplacement(rule, source string) (CallReplacement, error) {
+	target, wrapper, ok := strings.Cut(rule, "->")
+	if !ok {
+		return CallReplacement{}, fmt.Errorf("invalid call replacement %q, expected importpath.Func -> importpath.Wrapper", rule)
+	}
+	r := CallReplacement{Source: source}
+	var err error
+	if r.Target, err = ParseFuncRef(strings.TrimSpace(target)); err != nil {
+		return r, err
+	}
+	if r.Wrapper, err = ParseFuncRef(strings.TrimSpace(wrapper)); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// NewCallReplacer initializes a command processor that redirects calls according to replacements,
+// and injects the wrapper packages in the packages whose calls are rewritten
+func NewCallReplacer(replacements []CallReplacement) CallReplacer {
+	r := CallReplacer{
+		replacements: replacements,
+		injectors:    make(map[string]*PackageInjector),
+	}
+	for _, repl := range replacements {
+		if _, ok := r.injectors[repl.Wrapper.ImportPath]; !ok && repl.Source != "" {
+			injector := NewPackageInjector(repl.Wrapper.ImportPath, repl.Source)
+			r.injectors[repl.Wrapper.ImportPath] = &injector
+		}
+	}
+	return r
+}
+
+// ProcessCompile visits a compile command and rewrites the calls of its Go files
+func (r *CallReplacer) ProcessCompile(cmd *proxy.CompileCommand) {
+	if cmd.Flags.Std || !r.Selector.Match(cmd) {
+		return
+	}
+	for _, repl := range r.replacements {
+		if cmd.Flags.Package == repl.Wrapper.ImportPath {
+			// The wrapper package is likely to call the function it wraps
+			return
+		}
+	}
+
+	used := make(map[string]bool)
+	_, err := RewriteGoFiles(cmd, func(fset *token.FileSet, file *ast.File) (bool, error) {
+		return replaceCalls(fset, file, r.replacements, used), nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.Flags.Package, err)
+		os.Exit(1)
+	}
+
+	for importPath := range used {
+		if injector, ok := r.injectors[importPath]; ok {
+			proxy.ProcessCommand(cmd, injector.ProcessCompile)
+		}
+	}
+}
+
+// ProcessLink visits a link command and includes the wrapper packages and their dependencies
+func (r *CallReplacer) ProcessLink(cmd *proxy.LinkCommand) {
+	for _, injector := range r.injectors {
+		proxy.ProcessCommand(cmd, injector.ProcessLink)
+	}
+}
+
+// replaceCalls rewrites the calls of file according to replacements. The import paths of the
+// wrappers that are called are added to used. It reports whether file was modified
+func replaceCalls(fset *token.FileSet, file *ast.File, replacements []CallReplacement, used map[string]bool) bool {
+	modified := false
+	for _, repl := range replacements {
+		name := ImportName(file, repl.Target.ImportPath)
+		if name == "" || name == "_" {
+			continue
+		}
+		if name == "." {
+			log.Printf("====> Skipping dot import of %s\n", repl.Target.ImportPath)
+			continue
+		}
+
+		var calls []*ast.CallExpr
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); ok && sel.Sel.Name == repl.Target.Name {
+				// Identifiers resolved to a local declaration shadow the package name
+				if x, ok := sel.X.(*ast.Ident); ok && x.Name == name && x.Obj == nil {
+					calls = append(calls, call)
+				}
+			}
+			return true
+		})
+		if len(calls) == 0 {
+			continue
+		}
+
+		wrapperName := AddImport(fset, file, repl.Wrapper.ImportPath)
+		for _, call := range calls {
+			log.Printf("====> Replacing call to %s at %s\n", repl.Target, fset.Position(call.Pos()))
+			call.Fun = &ast.SelectorExpr{
+				X:   &ast.Ident{NamePos: call.Fun.Pos(), Name: wrapperName},
+				Sel: &ast.Ident{Name: repl.Wrapper.Name},
+			}
+		}
+		RemoveUnusedImport(fset, file, repl.Target.ImportPath)
+		used[repl.Wrapper.ImportPath] = true
+		modified = true
+	}
+	return modified
+}
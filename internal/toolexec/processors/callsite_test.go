@@ -0,0 +1,127 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCallReplacement(t *testing.T) {
+	r, err := ParseCallReplacement("net/http.Get -> github.com/acme/httptrace.Get", "")
+	require.NoError(t, err)
+	require.Equal(t, FuncRef{ImportPath: "net/http", Name: "Get"}, r.Target)
+	require.Equal(t, FuncRef{ImportPath: "github.com/acme/httptrace", Name: "Get"}, r.Wrapper)
+
+	r, err = ParseCallReplacement("os.Exit -> gopkg.in/guard.v1.Exit", "")
+	require.NoError(t, err)
+	require.Equal(t, FuncRef{ImportPath: "os", Name: "Exit"}, r.Target)
+	require.Equal(t, FuncRef{ImportPath: "gopkg.in/guard.v1", Name: "Exit"}, r.Wrapper)
+
+	for _, rule := range []string{"os.Exit", "os -> guard.Exit", "os.Exit -> guard.", "os.Exit -> guard.1Exit", "os.Exit -> .Exit"} {
+		_, err := ParseCallReplacement(rule, "")
+		require.Error(t, err, rule)
+	}
+}
+
+func TestReplaceCalls(t *testing.T) {
+	replacements := []CallReplacement{
+		{Target: FuncRef{"net/http", "Get"}, Wrapper: FuncRef{"github.com/acme/httptrace", "Get"}},
+		{Target: FuncRef{"os", "Exit"}, Wrapper: FuncRef{"github.com/acme/exitguard", "Exit"}},
+	}
+
+	for name, tc := range map[string]struct {
+		source   string
+		expected string
+		used     []string
+	}{
+		"unchanged": {
+			source: `package lib
+
+import "fmt"
+
+func F() { fmt.Println() }
+`,
+		},
+		"replaced": {
+			source: `package lib
+
+import (
+	"net/http"
+	"os"
+)
+
+func F() {
+	http.Get("http://localhost")
+	_ = http.StatusOK
+	os.Exit(1)
+}
+`,
+			expected: `package lib
+
+import (
+	__dd_exitguard "github.com/acme/exitguard"
+	__dd_httptrace "github.com/acme/httptrace"
+	"net/http"
+)
+
+func F() {
+	__dd_httptrace.Get("http://localhost")
+	_ = http.StatusOK
+	__dd_exitguard.Exit(1)
+}
+`,
+			used: []string{"github.com/acme/exitguard", "github.com/acme/httptrace"},
+		},
+		"alias-and-shadowing": {
+			source: `package lib
+
+import nethttp "net/http"
+
+func F(http fakeClient) {
+	nethttp.Get("http://localhost")
+	http.Get("http://localhost")
+}
+`,
+			expected: `package lib
+
+import (
+	__dd_httptrace "github.com/acme/httptrace"
+)
+
+func F(http fakeClient) {
+	__dd_httptrace.Get("http://localhost")
+	http.Get("http://localhost")
+}
+`,
+			used: []string{"github.com/acme/httptrace"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "lib.go", tc.source, parser.ParseComments)
+			require.NoError(t, err)
+
+			used := make(map[string]bool)
+			modified := replaceCalls(fset, file, replacements, used)
+			require.Equal(t, tc.expected != "", modified)
+			require.Len(t, used, len(tc.used))
+			for _, importPath := range tc.used {
+				require.True(t, used[importPath])
+			}
+			if modified {
+				var buf bytes.Buffer
+				require.NoError(t, format.Node(&buf, fset, file))
+				require.Equal(t, tc.expected, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// deterministicFileName is the name of the file enabling the replacement package, synthesized
+// in the test main package
+const deterministicFileName = "zz_dd_deterministic.go"
+
+var (
+	// deterministicTimeFuncs are the time functions reading or waiting on the clock
+	deterministicTimeFuncs = []string{"Now", "Sleep", "Since", "Until"}
+	// deterministicRandFuncs are the math/rand functions using the global generator
+	deterministicRandFuncs = []string{
+		"ExpFloat64", "Float32", "Float64", "Int", "Int31", "Int31n", "Int63", "Int63n", "Intn",
+		"NormFloat64", "Perm", "Read", "Seed", "Shuffle", "Uint32", "Uint64",
+	}
+)
+
+// DeterministicRewriter redirects the calls of the code under test to time and math/rand
+// functions to a controllable clock and random number generator, such as the ones of the
+// deterministic package of this module. The replacement package declares functions with the
+// same names and signatures as the replaced ones, and an `Enable()` function.
+//
+// Whether a package ends up in a test binary isn't known when it is compiled, and its archive is
+// shared by all the builds using it, so every selected package is rewritten and the replacement
+// functions behave as the replaced ones until enabled. The build of a test binary is recognized
+// from the compilation of the test main package generated by `go test`, which is added a file
+// enabling the replacement package before the tests run. The test files themselves are left
+// unchanged so that tests can use the real clock and control the replacement package
+type DeterministicRewriter struct {
+	importPath   string
+	replacements []CallReplacement
+	injector     *PackageInjector
+	// Selector selects the packages the processor applies to
+	Selector PackageSelector
+}
+
+// NewDeterministicRewriter initializes a command processor redirecting time and math/rand calls
+// to the package at importPath. The package is injected from source unless empty
+func NewDeterministicRewriter(importPath, source string) DeterministicRewriter {
+	d := DeterministicRewriter{importPath: importPath}
+	for _, target := range []struct {
+		importPath string
+		funcs      []string
+	}{
+		{"time", deterministicTimeFuncs},
+		{"math/rand", deterministicRandFuncs},
+	} {
+		for _, name := range target.funcs {
+			d.replacements = append(d.replacements, CallReplacement{
+				Target:  FuncRef{ImportPath: target.importPath, Name: name},
+				Wrapper: FuncRef{ImportPath: importPath, Name: name},
+				Source:  source,
+			})
+		}
+	}
+	if source != "" {
+		injector := NewPackageInjector(importPath, source)
+		d.injector = &injector
+	}
+	return d
+}
+
+// ProcessCompile visits a compile command and rewrites the calls of its code, or enables the
+// replacement package if it compiles the test main package
+func (d *DeterministicRewriter) ProcessCompile(cmd *proxy.CompileCommand) {
+	if IsTestMainCompile(cmd) {
+		d.enable(cmd)
+		return
+	}
+	if cmd.Flags.Std || cmd.Flags.Package == d.importPath || !d.Selector.Match(cmd) {
+		return
+	}
+
+	modified, err := RewriteGoFiles(cmd, d.rewrite)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.Flags.Package, err)
+		os.Exit(1)
+	}
+	if modified && d.injector != nil {
+		proxy.ProcessCommand(cmd, d.injector.ProcessCompile)
+	}
+}
+
+// ProcessLink visits a link command and includes the replacement package and its dependencies
+func (d *DeterministicRewriter) ProcessLink(cmd *proxy.LinkCommand) {
+	if d.injector != nil {
+		proxy.ProcessCommand(cmd, d.injector.ProcessLink)
+	}
+}
+
+// enable adds the file enabling the replacement package to the test main package compiled by cmd
+func (d *DeterministicRewriter) enable(cmd *proxy.CompileCommand) {
+	log.Printf("[%s] Enabling %s in test binary\n", cmd.Stage(), d.importPath)
+	if d.injector != nil {
+		proxy.ProcessCommand(cmd, d.injector.ProcessCompile)
+	}
+	src := fmt.Sprintf("// Code generated by rd-toolexec. DO NOT EDIT.\n\npackage main\n\nimport __dd_deterministic %q\n\nfunc init() { __dd_deterministic.Enable() }\n", d.importPath)
+	path := filepath.Join(filepath.Dir(cmd.Flags.Output), deterministicFileName)
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "couldn't write %s: %v\n", path, err)
+		os.Exit(1)
+	}
+	cmd.AddFiles([]string{path})
+}
+
+// rewrite redirects the calls of file unless it is a test file
+func (d *DeterministicRewriter) rewrite(fset *token.FileSet, file *ast.File) (bool, error) {
+	if name := fset.File(file.Pos()).Name(); strings.HasSuffix(name, "_test.go") || strings.HasSuffix(name, "_testmain.go") {
+		return false, nil
+	}
+	return replaceCalls(fset, file, d.replacements, make(map[string]bool)), nil
+}
@@ -0,0 +1,124 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeterministicRewrite(t *testing.T) {
+	d := NewDeterministicRewriter("example.com/deterministic", "")
+	source := `package lib
+
+import (
+	"math/rand"
+	"time"
+)
+
+func Jitter() time.Duration {
+	start := time.Now()
+	time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
+	r := rand.New(rand.NewSource(1))
+	return time.Since(start) + time.Duration(r.Intn(10))
+}
+`
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "lib.go", source, parser.ParseComments)
+	require.NoError(t, err)
+	modified, err := d.rewrite(fset, file)
+	require.NoError(t, err)
+	require.True(t, modified)
+
+	var buf bytes.Buffer
+	require.NoError(t, format.Node(&buf, fset, file))
+	require.Equal(t, `package lib
+
+import (
+	__dd_deterministic "example.com/deterministic"
+	"math/rand"
+	"time"
+)
+
+func Jitter() time.Duration {
+	start := __dd_deterministic.Now()
+	__dd_deterministic.Sleep(time.Duration(__dd_deterministic.Intn(10)) * time.Millisecond)
+	r := rand.New(rand.NewSource(1))
+	return __dd_deterministic.Since(start) + time.Duration(r.Intn(10))
+}
+`, buf.String())
+
+	// Test files are left unchanged
+	testFile, err := parser.ParseFile(fset, "lib_test.go", source, parser.ParseComments)
+	require.NoError(t, err)
+	modified, err = d.rewrite(fset, testFile)
+	require.NoError(t, err)
+	require.False(t, modified)
+}
+
+// TestDeterministicBuilds builds, through the proxy of the tests, a package tested only by an
+// external test package, whose code is compiled without any _test.go file. The clock is virtual
+// in its test binary and real in a program using the package
+func TestDeterministicBuilds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds programs through the proxy")
+	}
+	dir := t.TempDir()
+	proxyPath := filepath.Join(dir, "proxy")
+	out, err := exec.Command("go", "build", "-o", proxyPath, "../tests/proxy").CombinedOutput()
+	require.NoError(t, err, string(out))
+	deterministicDir, err := filepath.Abs(filepath.Join("..", "..", "..", "deterministic"))
+	require.NoError(t, err)
+
+	app := filepath.Join(dir, "app")
+	writeFiles(t, app, map[string]string{
+		"go.mod":     "module example.com/app\n\ngo 1.22\n",
+		"lib/lib.go": "package lib\n\nimport \"time\"\n\nfunc Year() int { return time.Now().Year() }\n",
+		"lib/lib_test.go": `package lib_test
+
+import (
+	"testing"
+
+	"example.com/app/lib"
+)
+
+func TestYear(t *testing.T) {
+	if year := lib.Year(); year != 2000 {
+		t.Fatalf("year %d, expected the virtual clock", year)
+	}
+}
+`,
+		"main.go": "package main\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/app/lib\"\n)\n\nfunc main() { fmt.Println(lib.Year() > 2000) }\n",
+		"cfg.yaml": `deterministic:
+  package: "github.com/tonyredondo/rd-toolexec/deterministic"
+  source: "` + deterministicDir + `"
+`,
+	})
+	goCommand := func(args ...string) *exec.Cmd {
+		args = append(args[:1:1], append([]string{"-toolexec", proxyPath + " " + filepath.Join(app, "cfg.yaml")}, args[1:]...)...)
+		cmd := exec.Command("go", args...)
+		cmd.Dir = app
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+		return cmd
+	}
+
+	out, err = goCommand("test", "-count=1", "./lib").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	// The proxy logs to stderr
+	out, err = goCommand("run", ".").Output()
+	require.NoError(t, err)
+	require.Equal(t, "true\n", string(out))
+}
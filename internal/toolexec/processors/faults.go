@@ -0,0 +1,392 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"maps"
+	"os"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// FaultInjector wraps the calls to selected functions so that a controller package decides
+// at runtime whether they fail or are delayed. Wrapped calls become
+//
+//	func(__dd_fn func(context.Context, string, ...any) (*sql.Rows, error), __dd_arg0 context.Context, ...) (__dd_r0 *sql.Rows, __dd_r1 error) {
+//		if __dd_err := controller.Inject("database/sql.(*DB).QueryContext"); __dd_err != nil {
+//			__dd_r1 = __dd_err
+//			return
+//		}
+//		return __dd_fn(__dd_arg0, ...)
+//	}(db.QueryContext, ctx, ...)
+//
+// The controller package declares `Inject(site string) error`, called by functions returning an
+// error last, and `Delay(site string)`, called by the others
+type FaultInjector struct {
+	controller string
+	injector   *PackageInjector
+	// targets are the names of the wrapped functions, in the format returned by funcSite
+	targets map[string]bool
+	// Selector selects the packages the processor applies to
+	Selector PackageSelector
+}
+
+// NewFaultInjector initializes a command processor wrapping the calls to targets, such as
+// `database/sql.(*DB).QueryContext` or `net/http.Get`. The controller package is injected from
+// source unless empty
+func NewFaultInjector(controller, source string, targets []string) FaultInjector {
+	f := FaultInjector{
+		controller: controller,
+		targets:    make(map[string]bool, len(targets)),
+	}
+	for _, target := range targets {
+		f.targets[target] = true
+	}
+	if source != "" {
+		injector := NewPackageInjector(controller, source)
+		f.injector = &injector
+	}
+	return f
+}
+
+// ProcessCompile visits a compile command and wraps the target calls of its Go files
+func (f *FaultInjector) ProcessCompile(cmd *proxy.CompileCommand) {
+	if cmd.Flags.Std || cmd.Flags.Package == f.controller || !f.Selector.Match(cmd) {
+		return
+	}
+
+	importable := importablePackages(cmd)
+	modified, err := RewriteTypedGoFiles(cmd, func(fset *token.FileSet, file *ast.File, info *types.Info) (bool, error) {
+		if info == nil {
+			return false, nil
+		}
+		return wrapFaultCalls(fset, file, info, cmd.Flags.Package, f.controller, f.targets, importable), nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.Flags.Package, err)
+		os.Exit(1)
+	}
+	if modified && f.injector != nil {
+		proxy.ProcessCommand(cmd, f.injector.ProcessCompile)
+	}
+}
+
+// ProcessLink visits a link command and includes the controller package and its dependencies
+func (f *FaultInjector) ProcessLink(cmd *proxy.LinkCommand) {
+	if f.injector != nil {
+		proxy.ProcessCommand(cmd, f.injector.ProcessLink)
+	}
+}
+
+// importablePackages maps the paths of the packages listed by the importcfg of cmd, as known to
+// the type checker, to the import paths the Go files of cmd can import them with. Vendored
+// packages are known by their mapped path but imported by their original path
+func importablePackages(cmd *proxy.CompileCommand) map[string]string {
+	importable := make(map[string]string)
+	cfg, err := os.Open(cmd.Flags.ImportCfg)
+	if err != nil {
+		return importable
+	}
+	reg := parseImportConfig(cfg)
+	cfg.Close()
+	for importPath := range reg.PackageFile {
+		importable[importPath] = importPath
+	}
+	for importPath, mapped := range reg.ImportMap {
+		importable[mapped] = importPath
+	}
+	return importable
+}
+
+// wrapFaultCalls wraps the calls of file, from package pkgPath, to targets and reports whether
+// file was modified. The closures only spell types of the packages imported by file or listed in
+// importable, as returned by importablePackages, since the compiler can't load any other package.
+// Calls whose closure would need one of those are left unchanged
+func wrapFaultCalls(fset *token.FileSet, file *ast.File, info *types.Info, pkgPath, controller string, targets map[string]bool, importable map[string]string) bool {
+	var (
+		controllerName string
+		imports        = make(map[string]string)
+		modified       bool
+	)
+	importable = maps.Clone(importable)
+	if importable == nil {
+		importable = make(map[string]string)
+	}
+	for _, spec := range file.Imports {
+		if importPath, err := strconv.Unquote(spec.Path.Value); err == nil {
+			if _, ok := importable[importPath]; !ok {
+				importable[importPath] = importPath
+			}
+		}
+	}
+	astutil.Apply(file, nil, func(c *astutil.Cursor) bool {
+		call, ok := c.Node().(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		site, method := calledFunc(call, info)
+		if site == "" || !targets[site] {
+			return true
+		}
+		if controllerName == "" {
+			controllerName = "__dd_" + DefaultPackageName(controller)
+		}
+		wrapped, err := wrapFaultCall(call, info, pkgPath, method, site, controllerName, importable, imports)
+		if err != nil {
+			log.Printf("====> Not wrapping call to %s at %s: %v\n", site, fset.Position(call.Pos()), err)
+			return true
+		}
+		log.Printf("====> Wrapping call to %s at %s\n", site, fset.Position(call.Pos()))
+		c.Replace(wrapped)
+		modified = true
+		return true
+	})
+	if !modified {
+		return false
+	}
+
+	astutil.AddNamedImport(fset, file, controllerName, controller)
+	for importPath, name := range imports {
+		astutil.AddNamedImport(fset, file, name, importPath)
+	}
+	return true
+}
+
+// calledFunc returns the full name of the function or method called by call, and whether it
+// is called through a method value. Method expressions and dynamic calls are ignored
+func calledFunc(call *ast.CallExpr, info *types.Info) (string, bool) {
+	fun := ast.Unparen(call.Fun)
+	switch x := fun.(type) {
+	case *ast.IndexExpr:
+		fun = x.X
+	case *ast.IndexListExpr:
+		fun = x.X
+	}
+
+	var ident *ast.Ident
+	method := false
+	switch x := fun.(type) {
+	case *ast.Ident:
+		ident = x
+	case *ast.SelectorExpr:
+		if sel := info.Selections[x]; sel != nil {
+			if sel.Kind() != types.MethodVal {
+				return "", false
+			}
+			method = true
+		}
+		ident = x.Sel
+	default:
+		return "", false
+	}
+	fn, ok := info.Uses[ident].(*types.Func)
+	if !ok {
+		return "", false
+	}
+	return funcSite(fn.Origin()), method
+}
+
+// funcSite returns the name of fn in the `importpath.Func`, `importpath.T.Method` or
+// `importpath.(*T).Method` format, or an empty string for predeclared methods
+func funcSite(fn *types.Func) string {
+	if fn.Pkg() == nil {
+		return ""
+	}
+	recv := fn.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return fn.Pkg().Path() + "." + fn.Name()
+	}
+	t := recv.Type()
+	pointer := false
+	if ptr, ok := t.(*types.Pointer); ok {
+		pointer = true
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return ""
+	}
+	if pointer {
+		return fmt.Sprintf("%s.(*%s).%s", fn.Pkg().Path(), named.Obj().Name(), fn.Name())
+	}
+	return fmt.Sprintf("%s.%s.%s", fn.Pkg().Path(), named.Obj().Name(), fn.Name())
+}
+
+// wrapFaultCall returns the call to the closure consulting the controller before running call.
+// imports collects the import paths of the packages referenced by the closure signature, which
+// must be keys of importable
+func wrapFaultCall(call *ast.CallExpr, info *types.Info, pkgPath string, method bool, site, controllerName string, importable, imports map[string]string) (*ast.CallExpr, error) {
+	sig, ok := info.Types[call.Fun].Type.(*types.Signature)
+	if !ok {
+		return nil, fmt.Errorf("unknown signature")
+	}
+	if method && len(call.Args) == 1 && sig.Params().Len() > 1 {
+		// m(g()) where g returns several values can't be combined with the method value argument
+		return nil, fmt.Errorf("multi-valued argument")
+	}
+
+	// Spell the types of the closure, importing the packages they reference under __dd_ names
+	// so that they can't be shadowed at the call site
+	var (
+		pending    = make(map[string]string)
+		unimported string
+	)
+	qualifier := func(pkg *types.Package) string {
+		if pkg.Path() == pkgPath {
+			return ""
+		}
+		importPath, ok := importable[pkg.Path()]
+		if pkg.Path() == "unsafe" {
+			importPath, ok = "unsafe", true
+		}
+		if !ok {
+			unimported = pkg.Path()
+			return pkg.Name()
+		}
+		if name, ok := imports[importPath]; ok {
+			return name
+		}
+		if name, ok := pending[importPath]; ok {
+			return name
+		}
+		name := importAlias(pkg.Name(), imports, pending)
+		pending[importPath] = name
+		return name
+	}
+	typeExpr := func(t types.Type) (ast.Expr, error) {
+		src := types.TypeString(t, qualifier)
+		if unimported != "" {
+			return nil, fmt.Errorf("type %s needs package %s, which isn't a dependency of the package", src, unimported)
+		}
+		expr, err := parser.ParseExpr(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type %s: %w", src, err)
+		}
+		ClearPositions(expr)
+		var unexported error
+		ast.Inspect(expr, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok && !sel.Sel.IsExported() {
+				unexported = fmt.Errorf("unexported type %s", src)
+			}
+			return unexported == nil
+		})
+		return expr, unexported
+	}
+
+	closure := &ast.FuncLit{Type: &ast.FuncType{Params: &ast.FieldList{}, Results: &ast.FieldList{}}, Body: &ast.BlockStmt{}}
+	inner := &ast.CallExpr{Fun: call.Fun}
+	outer := &ast.CallExpr{Fun: closure, Args: call.Args, Ellipsis: call.Ellipsis}
+	param := func(name string, t ast.Expr) {
+		closure.Type.Params.List = append(closure.Type.Params.List, &ast.Field{Names: []*ast.Ident{ast.NewIdent(name)}, Type: t})
+	}
+
+	if method {
+		// Evaluate the receiver before consulting the controller, as the call would
+		fnType, err := typeExpr(sig)
+		if err != nil {
+			return nil, err
+		}
+		param("__dd_fn", fnType)
+		inner.Fun = ast.NewIdent("__dd_fn")
+		outer.Args = append([]ast.Expr{call.Fun}, call.Args...)
+	}
+	for i := 0; i < sig.Params().Len(); i++ {
+		t := sig.Params().At(i).Type()
+		name := "__dd_arg" + strconv.Itoa(i)
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			elem, err := typeExpr(t.(*types.Slice).Elem())
+			if err != nil {
+				return nil, err
+			}
+			param(name, &ast.Ellipsis{Elt: elem})
+			inner.Ellipsis = call.Rparen
+		} else {
+			expr, err := typeExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			param(name, expr)
+		}
+		inner.Args = append(inner.Args, ast.NewIdent(name))
+	}
+
+	results := sig.Results()
+	for i := 0; i < results.Len(); i++ {
+		expr, err := typeExpr(results.At(i).Type())
+		if err != nil {
+			return nil, err
+		}
+		closure.Type.Results.List = append(closure.Type.Results.List, &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent("__dd_r" + strconv.Itoa(i))},
+			Type:  expr,
+		})
+	}
+
+	errorType := types.Universe.Lookup("error").Type()
+	if n := results.Len(); n > 0 && types.Identical(results.At(n-1).Type(), errorType) {
+		// if __dd_err := controller.Inject(site); __dd_err != nil { __dd_rN = __dd_err; return }
+		closure.Body.List = append(closure.Body.List, &ast.IfStmt{
+			Init: &ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("__dd_err")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{controllerCall(controllerName, "Inject", site)},
+			},
+			Cond: &ast.BinaryExpr{X: ast.NewIdent("__dd_err"), Op: token.NEQ, Y: ast.NewIdent("nil")},
+			Body: &ast.BlockStmt{List: []ast.Stmt{
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("__dd_r" + strconv.Itoa(n-1))},
+					Tok: token.ASSIGN,
+					Rhs: []ast.Expr{ast.NewIdent("__dd_err")},
+				},
+				&ast.ReturnStmt{},
+			}},
+		})
+	} else {
+		closure.Body.List = append(closure.Body.List, &ast.ExprStmt{X: controllerCall(controllerName, "Delay", site)})
+	}
+	if results.Len() > 0 {
+		closure.Body.List = append(closure.Body.List, &ast.ReturnStmt{Results: []ast.Expr{inner}})
+	} else {
+		closure.Body.List = append(closure.Body.List, &ast.ExprStmt{X: inner})
+	}
+
+	for importPath, name := range pending {
+		imports[importPath] = name
+	}
+	return outer, nil
+}
+
+// importAlias returns a __dd_ import name for a package named name, unused in imports and pending
+func importAlias(name string, imports, pending map[string]string) string {
+	used := make(map[string]bool, len(imports)+len(pending))
+	for _, m := range []map[string]string{imports, pending} {
+		for _, n := range m {
+			used[n] = true
+		}
+	}
+	alias := "__dd_" + name
+	for i := 2; used[alias]; i++ {
+		alias = "__dd_" + name + strconv.Itoa(i)
+	}
+	return alias
+}
+
+// controllerCall returns the `controller.fn("site")` call
+func controllerCall(controllerName, fn, site string) *ast.CallExpr {
+	return &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: ast.NewIdent(controllerName), Sel: ast.NewIdent(fn)},
+		Args: []ast.Expr{&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(site)}},
+	}
+}
@@ -0,0 +1,253 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testController = "example.com/faults"
+
+var testFaultTargets = map[string]bool{
+	"os.ReadFile":                     true,
+	"strings.(*Builder).WriteString":  true,
+	"(*database/sql.DB).QueryContext": true,
+	"database/sql.(*DB).QueryContext": true,
+	"main.work":                       true,
+	"fmt.Println":                     true,
+}
+
+func TestWrapFaultCalls(t *testing.T) {
+	for name, tc := range map[string]struct {
+		source   string
+		expected string
+	}{
+		"unchanged": {
+			source: `package main
+
+import "os"
+
+func main() { os.Exit(0) }
+`,
+		},
+		"unimported": {
+			// Wrapping QueryContext spells context.Context, which the package doesn't depend on
+			source: `package main
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+func main() {
+	var db *sql.DB
+	req, _ := http.NewRequest("GET", "/", nil)
+	db.QueryContext(req.Context(), "SELECT 1")
+}
+`,
+		},
+		"wrapped": {
+			source: `package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+func work() {}
+
+func main() {
+	var db *sql.DB
+	rows, err := db.QueryContext(context.Background(), "SELECT 1", 1, 2)
+	fmt.Println(rows, err)
+	os.ReadFile("f")
+	work()
+}
+`,
+			expected: `package main
+
+import (
+	"context"
+	__dd_context "context"
+	"database/sql"
+	__dd_sql "database/sql"
+	__dd_faults "example.com/faults"
+	"fmt"
+	"os"
+)
+
+func work() {}
+
+func main() {
+	var db *sql.DB
+	rows, err := func(__dd_fn func(ctx __dd_context.Context, query string, args ...any) (*__dd_sql.Rows, error), __dd_arg0 __dd_context.Context, __dd_arg1 string, __dd_arg2 ...any) (__dd_r0 *__dd_sql.Rows, __dd_r1 error) {
+		if __dd_err := __dd_faults.Inject("database/sql.(*DB).QueryContext"); __dd_err != nil {
+			__dd_r1 = __dd_err
+			return
+		}
+		return __dd_fn(__dd_arg0, __dd_arg1, __dd_arg2...)
+	}(db.QueryContext, context.Background(), "SELECT 1", 1, 2)
+	func(__dd_arg0 ...any) (__dd_r0 int, __dd_r1 error) {
+		if __dd_err := __dd_faults.Inject("fmt.Println"); __dd_err != nil {
+			__dd_r1 = __dd_err
+			return
+		}
+		return fmt.Println(__dd_arg0...)
+	}(rows, err)
+	func(__dd_arg0 string) (__dd_r0 []byte, __dd_r1 error) {
+		if __dd_err := __dd_faults.Inject("os.ReadFile"); __dd_err != nil {
+			__dd_r1 = __dd_err
+			return
+		}
+		return os.ReadFile(__dd_arg0)
+	}("f")
+	func() {
+		__dd_faults.Delay("main.work")
+		work()
+	}()
+}
+`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			file, info := typeCheckSource(t, fset, tc.source)
+			modified := wrapFaultCalls(fset, file, info, "main", testController, testFaultTargets, nil)
+			require.Equal(t, tc.expected != "", modified)
+			if !modified {
+				return
+			}
+			var buf bytes.Buffer
+			require.NoError(t, format.Node(&buf, fset, file))
+			require.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
+
+// TestFaultImports builds, through the proxy of the tests, a program calling QueryContext in a
+// file that doesn't import context. The call is only wrapped when another file of the package
+// imports context, making it available to the compiler
+func TestFaultImports(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds programs through the proxy")
+	}
+	dir := t.TempDir()
+	proxyPath := filepath.Join(dir, "proxy")
+	out, err := exec.Command("go", "build", "-o", proxyPath, "../tests/proxy").CombinedOutput()
+	require.NoError(t, err, string(out))
+	faultsDir, err := filepath.Abs(filepath.Join("..", "..", "..", "faults"))
+	require.NoError(t, err)
+	cfg := filepath.Join(dir, "cfg.yaml")
+	require.NoError(t, os.WriteFile(cfg, []byte(`faults:
+  package: "github.com/tonyredondo/rd-toolexec/faults"
+  source: "`+faultsDir+`"
+  calls:
+    - "database/sql.(*DB).QueryContext"
+`), 0644))
+
+	main := `package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+)
+
+func main() {
+	var db *sql.DB
+	req, _ := http.NewRequest("GET", "/", nil)
+	_, err := db.QueryContext(req.Context(), "SELECT 1")
+	fmt.Println(err)
+}
+`
+	for name, tc := range map[string]struct {
+		files   map[string]string
+		wrapped bool
+	}{
+		"unimported": {files: map[string]string{"main.go": main}},
+		"imported": {
+			files:   map[string]string{"main.go": main, "ctx.go": "package main\n\nimport \"context\"\n\nvar _ context.Context\n"},
+			wrapped: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			app := t.TempDir()
+			files := map[string]string{"go.mod": "module example.com/app\n\ngo 1.22\n"}
+			maps.Copy(files, tc.files)
+			writeFiles(t, app, files)
+
+			binary := filepath.Join(app, "app")
+			cmd := exec.Command("go", "build", "-o", binary, "-toolexec", proxyPath+" "+cfg, ".")
+			cmd.Dir = app
+			cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+			out, err := cmd.CombinedOutput()
+			require.NoError(t, err, string(out))
+			if !tc.wrapped {
+				return
+			}
+
+			cmd = exec.Command(binary)
+			cmd.Env = append(os.Environ(), `DD_FAULTS={"database/sql.(*DB).QueryContext": {"error": "connection refused"}}`)
+			out, err = cmd.CombinedOutput()
+			require.NoError(t, err, string(out))
+			require.Equal(t, "connection refused\n", string(out))
+		})
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	controller, err := os.ReadFile(filepath.Join("..", "..", "..", "faults", "faults.go"))
+	require.NoError(t, err)
+	source := `package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func next(calls *int) string {
+	*calls++
+	return "x"
+}
+
+func main() {
+	_, err := os.ReadFile("missing")
+	fmt.Printf("%v %T\n", err, err)
+
+	var b strings.Builder
+	calls := 0
+	n, err := b.WriteString(next(&calls))
+	fmt.Println(n, err, b.Len(), calls)
+}
+`
+	rewrite := func(fset *token.FileSet, file *ast.File, info *types.Info) (bool, error) {
+		return wrapFaultCalls(fset, file, info, "main", testController, testFaultTargets, nil), nil
+	}
+	packages := map[string]string{"faults/faults.go": string(controller)}
+
+	output, err := runRewritten(t, source, rewrite, packages)
+	require.NoError(t, err, string(output))
+	require.Equal(t, "open missing: no such file or directory *fs.PathError\n1 <nil> 1 1\n", string(output))
+
+	output, err = runRewritten(t, source, rewrite, packages,
+		`DD_FAULTS={"os.ReadFile": {"error": "disk on fire"}, "strings.(*Builder).WriteString": {"error": "full", "latency": "1ms"}}`)
+	require.NoError(t, err, string(output))
+	require.Equal(t, "disk on fire *faults.Error\n0 full 0 1\n", string(output))
+}
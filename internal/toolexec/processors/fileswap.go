@@ -6,7 +6,11 @@
 package processors
 
 import (
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
 )
@@ -15,6 +19,22 @@ type GoFileSwapper struct {
 	// Key: file to replace
 	// Value: file to replace with
 	swapMap map[string]string
+	// rules select the files to replace from their package import path
+	rules []SwapRule
+	// Selector selects the packages in which files are swapped
+	Selector PackageSelector
+}
+
+// SwapRule replaces Go files of a package independently of their location on disk
+type SwapRule struct {
+	// ImportPath is the import path pattern of the packages holding the files to replace,
+	// as accepted by MatchImportPath
+	ImportPath string
+	// FileName is the base name of the files to replace. It may hold filepath.Match wildcards
+	FileName string
+	// Replacement is the file to replace with. When it is a directory, each replaced
+	// file is swapped with the file of the same base name in that directory
+	Replacement string
 }
 
 func NewGoFileSwapper(swapMap map[string]string) GoFileSwapper {
@@ -23,14 +43,79 @@ func NewGoFileSwapper(swapMap map[string]string) GoFileSwapper {
 	}
 }
 
+// NewGoFileSwapperWithRules is like NewGoFileSwapper but additionally swaps the files selected by rules
+func NewGoFileSwapperWithRules(swapMap map[string]string, rules []SwapRule) GoFileSwapper {
+	return GoFileSwapper{
+		swapMap: swapMap,
+		rules:   rules,
+	}
+}
+
+// ParseSwapRule parses a rule in the `importpath:filename` format
+func ParseSwapRule(key, replacement string) (SwapRule, error) {
+	importPath, fileName, ok := strings.Cut(key, ":")
+	if !ok || importPath == "" || fileName == "" {
+		return SwapRule{}, fmt.Errorf("invalid replacement rule %q, expected importpath:filename", key)
+	}
+	if _, err := filepath.Match(fileName, ""); err != nil {
+		return SwapRule{}, fmt.Errorf("invalid replacement rule %q: %w", key, err)
+	}
+	return SwapRule{ImportPath: importPath, FileName: fileName, Replacement: replacement}, nil
+}
+
 func (s *GoFileSwapper) ProcessCompile(cmd *proxy.CompileCommand) {
+	if !s.Selector.Match(cmd) {
+		return
+	}
 	log.Printf("[%s] Replacing Go files\n", cmd.Stage())
 
+	swapMap := make(map[string]string, len(s.swapMap))
 	for old, new := range s.swapMap {
+		swapMap[old] = new
+	}
+	for _, rule := range s.rules {
+		for old, new := range rule.resolve(cmd) {
+			swapMap[old] = new
+		}
+	}
+
+	for old, new := range swapMap {
 		if err := cmd.ReplaceParam(old, new); err != nil {
 			log.Printf("couldn't replace param: %v\n", err)
 		} else {
 			log.Printf("====> Replacing %s by %s\n", old, new)
+			mapping := proxy.PathMapping{Original: old}
+			if m, ok := cmd.PathMappings()[old]; ok {
+				// old is itself a substitute, keep reporting diagnostics against its original file
+				mapping = m
+			}
+			cmd.MapPath(new, mapping)
+		}
+	}
+}
+
+// resolve returns the old:new map of the files of cmd swapped by r
+func (r *SwapRule) resolve(cmd *proxy.CompileCommand) map[string]string {
+	if !MatchImportPath(r.ImportPath, cmd.Flags.Package) {
+		return nil
+	}
+
+	replacementIsDir := false
+	if info, err := os.Stat(r.Replacement); err == nil {
+		replacementIsDir = info.IsDir()
+	}
+
+	swapMap := make(map[string]string)
+	for _, file := range cmd.GoFiles() {
+		base := filepath.Base(file)
+		if ok, _ := filepath.Match(r.FileName, base); !ok {
+			continue
+		}
+		if replacementIsDir {
+			swapMap[file] = filepath.Join(r.Replacement, base)
+		} else {
+			swapMap[file] = r.Replacement
 		}
 	}
+	return swapMap
 }
@@ -0,0 +1,57 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSwapRules(t *testing.T) {
+	replacementDir := t.TempDir()
+	args := []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/mod/cache/bar.go", "/mod/cache/bar_linux.go", "/mod/cache/baz.go"}
+
+	for name, tc := range map[string]struct {
+		key         string
+		replacement string
+		expected    []string
+	}{
+		"file": {
+			key:         "github.com/foo/bar:baz.go",
+			replacement: "/patches/baz.go",
+			expected:    []string{"/mod/cache/bar.go", "/mod/cache/bar_linux.go", "/patches/baz.go"},
+		},
+		"glob": {
+			key:         "github.com/foo/...:bar*.go",
+			replacement: replacementDir,
+			expected:    []string{replacementDir + "/bar.go", replacementDir + "/bar_linux.go", "/mod/cache/baz.go"},
+		},
+		"other-package": {
+			key:         "github.com/foo/baz:baz.go",
+			replacement: "/patches/baz.go",
+			expected:    []string{"/mod/cache/bar.go", "/mod/cache/bar_linux.go", "/mod/cache/baz.go"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rule, err := ParseSwapRule(tc.key, tc.replacement)
+			require.NoError(t, err)
+			cmd := proxy.MustParseCommand(append([]string{}, args...)).(*proxy.CompileCommand)
+			swapper := NewGoFileSwapperWithRules(nil, []SwapRule{rule})
+			swapper.ProcessCompile(cmd)
+			require.Equal(t, tc.expected, cmd.GoFiles())
+		})
+	}
+}
+
+func TestParseSwapRule(t *testing.T) {
+	for _, key := range []string{"bar.go", ":bar.go", "github.com/foo/bar:", "github.com/foo/bar:[.go"} {
+		_, err := ParseSwapRule(key, "/patches/bar.go")
+		require.Error(t, err, key)
+	}
+}
@@ -0,0 +1,291 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"text/template"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// FuncSelector selects functions of the compiled packages
+type FuncSelector struct {
+	// Packages selects the packages declaring the functions
+	Packages PackageSelector `yaml:"packages,omitempty"`
+	// Exported restricts the selection to exported functions and to the exported methods
+	// of exported types
+	Exported bool `yaml:"exported,omitempty"`
+	// Receiver restricts the selection to the methods of the named type, with or
+	// without pointer receivers. "*" selects every method
+	Receiver string `yaml:"receiver,omitempty"`
+	// Name is a regular expression matched against function and method names
+	Name string `yaml:"name,omitempty"`
+	// Closures includes the function literals declared in the selected functions
+	Closures bool `yaml:"closures,omitempty"`
+}
+
+// PrologueData is the data available to function prologue templates
+type PrologueData struct {
+	// Package is the name under which the hook package is imported
+	Package string
+	// Name is the quoted qualified name of the function, such as "pkg.(*T).Method" or "pkg.Func.func1"
+	Name string
+	// ImportPath is the import path of the package declaring the function
+	ImportPath string
+}
+
+// FuncHooks inserts a prologue at the start of the selected functions, such as
+// `defer hook.Enter("pkg.Func")()`
+type FuncHooks struct {
+	hookImportPath string
+	prologue       *template.Template
+	functions      FuncSelector
+	name           *regexp.Regexp
+	injector       *PackageInjector
+	// Selector selects the packages whose functions are instrumented
+	Selector PackageSelector
+}
+
+// NewFuncHooks initializes a command processor that inserts the statements rendered from the
+// prologue template at the start of the functions selected by functions. The template is rendered
+// with a PrologueData and may refer to the hook package at hookImportPath, which is injected
+// from hookSource unless empty
+func NewFuncHooks(hookImportPath, hookSource, prologue string, functions FuncSelector) (FuncHooks, error) {
+	h := FuncHooks{
+		hookImportPath: hookImportPath,
+		functions:      functions,
+	}
+	var err error
+	if h.prologue, err = template.New("prologue").Parse(prologue); err != nil {
+		return h, err
+	}
+	if functions.Name != "" {
+		if h.name, err = regexp.Compile(functions.Name); err != nil {
+			return h, err
+		}
+	}
+	if hookSource != "" {
+		injector := NewPackageInjector(hookImportPath, hookSource)
+		h.injector = &injector
+	}
+	return h, nil
+}
+
+// ProcessCompile visits a compile command and inserts the prologue in the selected functions
+func (h *FuncHooks) ProcessCompile(cmd *proxy.CompileCommand) {
+	if cmd.Flags.Std || cmd.Flags.Package == h.hookImportPath || !h.Selector.Match(cmd) || !h.functions.Packages.Match(cmd) {
+		return
+	}
+
+	modified, err := RewriteGoFiles(cmd, func(fset *token.FileSet, file *ast.File) (bool, error) {
+		return h.instrument(fset, file, cmd.Flags.Package)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.Flags.Package, err)
+		os.Exit(1)
+	}
+	if modified && h.injector != nil {
+		proxy.ProcessCommand(cmd, h.injector.ProcessCompile)
+	}
+}
+
+// ProcessLink visits a link command and includes the hook package and its dependencies
+func (h *FuncHooks) ProcessLink(cmd *proxy.LinkCommand) {
+	if h.injector != nil {
+		proxy.ProcessCommand(cmd, h.injector.ProcessLink)
+	}
+}
+
+// instrument inserts the prologue in the selected functions of file and reports whether it was modified
+func (h *FuncHooks) instrument(fset *token.FileSet, file *ast.File, importPath string) (bool, error) {
+	var funcDecls []*ast.FuncDecl
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Body != nil && h.selects(funcDecl) {
+			funcDecls = append(funcDecls, funcDecl)
+		}
+	}
+	if len(funcDecls) == 0 {
+		return false, nil
+	}
+
+	pkgName := AddImport(fset, file, h.hookImportPath)
+	for _, funcDecl := range funcDecls {
+		name := file.Name.Name + "." + FuncDeclName(funcDecl)
+		// Closures are instrumented first, so that function literals of the prologue are left untouched
+		if h.functions.Closures {
+			var err error
+			forEachFuncLit(funcDecl.Body, name, func(lit *ast.FuncLit, litName string) {
+				if err == nil {
+					err = h.insertPrologue(lit.Body, pkgName, litName, importPath)
+				}
+			})
+			if err != nil {
+				return false, err
+			}
+		}
+		if err := h.insertPrologue(funcDecl.Body, pkgName, name, importPath); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (h *FuncHooks) selects(funcDecl *ast.FuncDecl) bool {
+	if h.functions.Exported {
+		if !funcDecl.Name.IsExported() {
+			return false
+		}
+		if _, recvType := receiverType(funcDecl); funcDecl.Recv != nil && !token.IsExported(recvType) {
+			return false
+		}
+	}
+	if h.name != nil && !h.name.MatchString(funcDecl.Name.Name) {
+		return false
+	}
+	if h.functions.Receiver != "" {
+		if funcDecl.Recv == nil {
+			return false
+		}
+		_, recvType := receiverType(funcDecl)
+		if h.functions.Receiver != "*" && h.functions.Receiver != recvType {
+			return false
+		}
+	}
+	return true
+}
+
+// insertPrologue inserts the rendered prologue at the start of body
+func (h *FuncHooks) insertPrologue(body *ast.BlockStmt, pkgName, funcName, importPath string) error {
+	var buf bytes.Buffer
+	err := h.prologue.Execute(&buf, PrologueData{
+		Package:    pkgName,
+		Name:       strconv.Quote(funcName),
+		ImportPath: importPath,
+	})
+	if err != nil {
+		return err
+	}
+	stmts, err := parseStatements(buf.String())
+	if err != nil {
+		return fmt.Errorf("invalid prologue for %s: %w", funcName, err)
+	}
+	body.List = append(stmts, body.List...)
+	return nil
+}
+
+// parseStatements parses the Go statements in src
+func parseStatements(src string) ([]ast.Stmt, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p; func _() {\n"+src+"\n}", parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	stmts := file.Decls[0].(*ast.FuncDecl).Body.List
+	// Positions refer to the parsed snippet, clear them so that the statements are printed in place
+	for _, stmt := range stmts {
+		ClearPositions(stmt)
+	}
+	return stmts, nil
+}
+
+// FuncDeclName returns the name of a function declaration as reported by the runtime,
+// such as "Func", "T.Method" or "(*T).Method"
+func FuncDeclName(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil {
+		return funcDecl.Name.Name
+	}
+	pointer, recvType := receiverType(funcDecl)
+	if pointer {
+		return fmt.Sprintf("(*%s).%s", recvType, funcDecl.Name.Name)
+	}
+	return recvType + "." + funcDecl.Name.Name
+}
+
+// receiverType returns the name of the receiver type of a method, and whether it's a pointer receiver
+func receiverType(funcDecl *ast.FuncDecl) (bool, string) {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return false, ""
+	}
+	expr := funcDecl.Recv.List[0].Type
+	pointer := false
+	if star, ok := expr.(*ast.StarExpr); ok {
+		pointer = true
+		expr = star.X
+	}
+	// Generic receivers, such as List[T]
+	switch x := expr.(type) {
+	case *ast.IndexExpr:
+		expr = x.X
+	case *ast.IndexListExpr:
+		expr = x.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return pointer, ident.Name
+	}
+	return pointer, ""
+}
+
+// forEachFuncLit calls fn with the function literals declared in body, named after the runtime
+// naming scheme: the literals of Func are named Func.func1, Func.func2, and so on, and the
+// literals nested in Func.func1 are named Func.func1.1, Func.func1.2, and so on
+func forEachFuncLit(body *ast.BlockStmt, parentName string, fn func(*ast.FuncLit, string)) {
+	forEachFuncLitPrefix(body, parentName+".func", fn)
+}
+
+func forEachFuncLitPrefix(body *ast.BlockStmt, prefix string, fn func(*ast.FuncLit, string)) {
+	count := 0
+	ast.Inspect(body, func(n ast.Node) bool {
+		lit, ok := n.(*ast.FuncLit)
+		if !ok {
+			return true
+		}
+		count++
+		name := fmt.Sprintf("%s%d", prefix, count)
+		// Nested literals are visited before the prologue is inserted in lit
+		forEachFuncLitPrefix(lit.Body, name+".", fn)
+		fn(lit, name)
+		return false
+	})
+}
+
+// ClearPositions clears the positions of node and its children so that the printer
+// places them relatively to their surrounding nodes
+func ClearPositions(node ast.Node) {
+	clearValuePositions(reflect.ValueOf(node))
+}
+
+var posType = reflect.TypeOf(token.NoPos)
+
+func clearValuePositions(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if !v.IsNil() {
+			clearValuePositions(v.Elem())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			clearValuePositions(v.Index(i))
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Field(i)
+			if f.Type() == posType && f.CanSet() {
+				f.SetInt(int64(token.NoPos))
+			} else if v.Type().Field(i).Name != "Obj" {
+				// Objects link identifiers to their declarations, which may create cycles
+				clearValuePositions(f)
+			}
+		}
+	}
+}
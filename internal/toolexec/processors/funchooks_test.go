@@ -0,0 +1,110 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const hookedSource = `package lib
+
+type List[T any] struct{ items []T }
+
+func (l *List[T]) Push(v T) {
+	l.items = append(l.items, v)
+}
+
+func (l List[T]) Len() (n int) {
+	n = len(l.items)
+	return
+}
+
+func Map[T, U any](in []T, f func(T) U) []U {
+	out := make([]U, 0, len(in))
+	for _, v := range in {
+		out = append(out, f(v))
+	}
+	return out
+}
+
+type state struct{}
+
+func (s *state) Reset() {}
+
+func helper() {
+	go func() {
+		func() {}()
+	}()
+}
+`
+
+func TestFuncHooks(t *testing.T) {
+	for name, tc := range map[string]struct {
+		functions FuncSelector
+		expected  []string
+	}{
+		"all": {
+			expected: []string{`"lib.(*List).Push"`, `"lib.List.Len"`, `"lib.Map"`, `"lib.(*state).Reset"`, `"lib.helper"`},
+		},
+		// The exported methods of unexported types are skipped
+		"exported": {
+			functions: FuncSelector{Exported: true},
+			expected:  []string{`"lib.(*List).Push"`, `"lib.List.Len"`, `"lib.Map"`},
+		},
+		"methods": {
+			functions: FuncSelector{Receiver: "List", Name: "^P"},
+			expected:  []string{`"lib.(*List).Push"`},
+		},
+		"all-methods": {
+			functions: FuncSelector{Receiver: "*"},
+			expected:  []string{`"lib.(*List).Push"`, `"lib.List.Len"`, `"lib.(*state).Reset"`},
+		},
+		"closures": {
+			functions: FuncSelector{Name: "helper", Closures: true},
+			expected:  []string{`"lib.helper.func1.1"`, `"lib.helper.func1"`, `"lib.helper"`},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			hooks, err := NewFuncHooks("github.com/acme/hook", "", "defer {{.Package}}.Enter({{.Name}})()", tc.functions)
+			require.NoError(t, err)
+
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, "lib.go", hookedSource, parser.ParseComments)
+			require.NoError(t, err)
+			modified, err := hooks.instrument(fset, file, "github.com/acme/lib")
+			require.NoError(t, err)
+			require.True(t, modified)
+
+			var buf bytes.Buffer
+			require.NoError(t, format.Node(&buf, fset, file))
+			out := buf.String()
+			require.Contains(t, out, `__dd_hook "github.com/acme/hook"`)
+			for _, funcName := range tc.expected {
+				require.Contains(t, out, "defer __dd_hook.Enter("+funcName+")()")
+			}
+			require.Equal(t, len(tc.expected), bytes.Count(buf.Bytes(), []byte("__dd_hook.Enter(")))
+			// The output must still be valid Go
+			_, err = parser.ParseFile(token.NewFileSet(), "lib.go", out, 0)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestFuncHooksInvalidPrologue(t *testing.T) {
+	hooks, err := NewFuncHooks("github.com/acme/hook", "", "defer {{.Package}}.Enter(", FuncSelector{})
+	require.NoError(t, err)
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "lib.go", hookedSource, parser.ParseComments)
+	require.NoError(t, err)
+	_, err = hooks.instrument(fset, file, "github.com/acme/lib")
+	require.ErrorContains(t, err, "invalid prologue")
+}
@@ -0,0 +1,194 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// GeneratedFile describes a Go file rendered from a template and added to the selected packages
+type GeneratedFile struct {
+	// Name is the base name of the generated file
+	Name string `yaml:"name"`
+	// Template is the path of the text/template file rendered with a TemplateData
+	Template string `yaml:"template"`
+	// Packages selects the packages the file is added to
+	Packages PackageSelector `yaml:"packages,omitempty"`
+}
+
+// TemplateData is the package metadata available to the templates of generated files
+type TemplateData struct {
+	// ImportPath is the import path of the compiled package
+	ImportPath string
+	// PackageName is the name of the compiled package
+	PackageName string
+	// GoVersion is the version of the Go toolchain, such as go1.22.1
+	GoVersion string
+	// LangVersion is the Go language version the package is compiled with, such as go1.22
+	LangVersion string
+	// BuildID is the build ID of the compiled package
+	BuildID string
+	// Stage is the build stage of the compile command
+	Stage string
+}
+
+// FileGenerator adds Go files rendered from templates to the compiled packages
+type FileGenerator struct {
+	files []GeneratedFile
+	// Selector selects the packages files can be added to
+	Selector PackageSelector
+}
+
+// NewFileGenerator initializes a command processor that renders and adds files
+// to the packages they select
+func NewFileGenerator(files []GeneratedFile) FileGenerator {
+	return FileGenerator{
+		files: files,
+	}
+}
+
+// ProcessCompile visits a compile command and adds the generated files to its Go files
+func (g *FileGenerator) ProcessCompile(cmd *proxy.CompileCommand) {
+	if len(cmd.GoFiles()) == 0 || !g.Selector.Match(cmd) {
+		return
+	}
+
+	paths, err := g.generate(cmd, filepath.Dir(cmd.Flags.Output))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.Flags.Package, err)
+		os.Exit(1)
+	}
+	cmd.AddFiles(paths)
+}
+
+// generate renders the files selecting the package compiled by cmd into the directory dir, and
+// returns their paths. The names of the generated files must differ from the names of the files
+// of the package and from each other, as the compiler rejects duplicate declarations
+func (g *FileGenerator) generate(cmd *proxy.CompileCommand, dir string) ([]string, error) {
+	names := make(map[string]string)
+	for _, file := range cmd.GoFiles() {
+		names[filepath.Base(file)] = file
+	}
+
+	var data *TemplateData
+	var paths []string
+	for _, file := range g.files {
+		if !file.Packages.Match(cmd) {
+			continue
+		}
+		if other, ok := names[file.Name]; ok {
+			return nil, fmt.Errorf("generated file %s conflicts with %s", file.Name, other)
+		}
+		names[file.Name] = file.Template
+		if data == nil {
+			d, err := newTemplateData(cmd)
+			if err != nil {
+				return nil, err
+			}
+			data = &d
+		}
+
+		log.Printf("[%s] Generating %s from %s\n", cmd.Stage(), file.Name, file.Template)
+		path, err := file.render(dir, data)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// render renders f with data into the directory dir and returns the path of the generated file
+func (f *GeneratedFile) render(dir string, data *TemplateData) (string, error) {
+	tmpl, err := template.ParseFiles(f.Template)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return "", fmt.Errorf("%s doesn't render valid Go code: %w", f.Template, err)
+	}
+
+	path := filepath.Join(dir, f.Name)
+	return path, os.WriteFile(path, src, 0o644)
+}
+
+func newTemplateData(cmd *proxy.CompileCommand) (TemplateData, error) {
+	name, err := PackageName(cmd)
+	if err != nil {
+		return TemplateData{}, err
+	}
+	return TemplateData{
+		ImportPath:  cmd.Flags.Package,
+		PackageName: name,
+		GoVersion:   toolchainVersion(cmd),
+		LangVersion: cmd.Flags.Lang,
+		BuildID:     buildID(cmd),
+		Stage:       cmd.Stage(),
+	}, nil
+}
+
+// PackageName returns the name of the package compiled by cmd, as declared in its Go files.
+// Non-test files are preferred, as internal test files may declare an external test package
+func PackageName(cmd *proxy.CompileCommand) (string, error) {
+	files := cmd.GoFiles()
+	if len(files) == 0 {
+		return "", fmt.Errorf("no Go files to read the package name from")
+	}
+	file := files[0]
+	for _, f := range files {
+		if !strings.HasSuffix(f, "_test.go") {
+			file = f
+			break
+		}
+	}
+
+	astFile, err := parser.ParseFile(token.NewFileSet(), file, nil, parser.PackageClauseOnly)
+	if err != nil {
+		return "", err
+	}
+	return astFile.Name.Name, nil
+}
+
+// buildID returns the value of the -buildid flag of cmd. Build IDs may start with
+// a dash, so they can't be parsed as regular flag values
+func buildID(cmd *proxy.CompileCommand) string {
+	args := cmd.Args()
+	for i, arg := range args[:len(args)-1] {
+		if arg == "-buildid" {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// toolchainVersion returns the version of the compiler run by cmd, or the version
+// the proxy was built with if the compiler can't tell
+func toolchainVersion(cmd *proxy.CompileCommand) string {
+	// Prints `compile version go1.22.1`
+	out, err := exec.Command(cmd.Args()[0], "-V").Output()
+	if fields := strings.Fields(string(out)); err == nil && len(fields) >= 3 {
+		return fields[2]
+	}
+	return runtime.Version()
+}
@@ -0,0 +1,162 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+// writeFiles writes the files keyed by their path in dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+func TestPackageName(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"bar.go":           "// Package bar\npackage bar\n",
+		"bar_test.go":      "package bar_test\n",
+		"internal_test.go": "package bar\n",
+		"invalid.go":       "func main() {}\n",
+	})
+
+	for name, tc := range map[string]struct {
+		files    []string
+		expected string
+		error    bool
+	}{
+		"package":        {files: []string{"bar.go"}, expected: "bar"},
+		"non-test-first": {files: []string{"bar_test.go", "bar.go"}, expected: "bar"},
+		"external-test":  {files: []string{"bar_test.go"}, expected: "bar_test"},
+		"internal-test":  {files: []string{"internal_test.go", "bar_test.go"}, expected: "bar"},
+		"no-files":       {error: true},
+		"invalid":        {files: []string{"invalid.go"}, error: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args := []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "example.com/bar"}
+			for _, file := range tc.files {
+				args = append(args, filepath.Join(dir, file))
+			}
+			cmd := proxy.MustParseCommand(args).(*proxy.CompileCommand)
+			name, err := PackageName(cmd)
+			if tc.error {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, name)
+		})
+	}
+}
+
+func TestNewTemplateData(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"bar.go": "package bar\n"})
+	cmd := proxy.MustParseCommand([]string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "example.com/bar", "-lang=go1.21", "-buildid", "-abc/def", filepath.Join(dir, "bar.go")}).(*proxy.CompileCommand)
+
+	data, err := newTemplateData(cmd)
+	require.NoError(t, err)
+	require.Equal(t, TemplateData{
+		ImportPath:  "example.com/bar",
+		PackageName: "bar",
+		// The compiler of the command can't be run, the version of the proxy is used
+		GoVersion:   runtime.Version(),
+		LangVersion: "go1.21",
+		BuildID:     "-abc/def",
+		Stage:       "b002",
+	}, data)
+}
+
+func TestFileGenerator(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"src/bar.go":      "package bar\n",
+		"name.go.tmpl":    "// Code generated for {{.ImportPath}}. DO NOT EDIT.\n\npackage {{.PackageName}}\n\nconst name   =   \"{{.Stage}}\"\n",
+		"other.go.tmpl":   "package {{.PackageName}}\n\nconst other = 1\n",
+		"parse.go.tmpl":   "package {{.PackageName\n",
+		"execute.go.tmpl": "package {{.Unknown}}\n",
+		"invalid.go.tmpl": "package {{.PackageName}}\n\nfunc {\n",
+	})
+	template := func(name string) string { return filepath.Join(dir, name) }
+	source := filepath.Join(dir, "src", "bar.go")
+
+	for name, tc := range map[string]struct {
+		files    []GeneratedFile
+		expected map[string]string
+		error    string
+	}{
+		"render": {
+			files: []GeneratedFile{{Name: "zz_name.go", Template: template("name.go.tmpl")}},
+			expected: map[string]string{
+				"zz_name.go": "// Code generated for example.com/bar. DO NOT EDIT.\n\npackage bar\n\nconst name = \"b002\"\n",
+			},
+		},
+		"selected": {
+			files: []GeneratedFile{
+				{Name: "zz_name.go", Template: template("name.go.tmpl"), Packages: PackageSelector{Include: []string{"example.com/baz"}}},
+				{Name: "zz_other.go", Template: template("other.go.tmpl"), Packages: PackageSelector{Include: []string{"example.com/..."}}},
+			},
+			expected: map[string]string{"zz_other.go": "package bar\n\nconst other = 1\n"},
+		},
+		"duplicate-package-file": {
+			files: []GeneratedFile{{Name: "bar.go", Template: template("other.go.tmpl")}},
+			error: "generated file bar.go conflicts with " + source,
+		},
+		"duplicate-generated-file": {
+			files: []GeneratedFile{
+				{Name: "zz_name.go", Template: template("name.go.tmpl")},
+				{Name: "zz_name.go", Template: template("other.go.tmpl")},
+			},
+			error: "generated file zz_name.go conflicts with " + template("name.go.tmpl"),
+		},
+		"parse-error": {
+			files: []GeneratedFile{{Name: "zz_parse.go", Template: template("parse.go.tmpl")}},
+			error: "unclosed action",
+		},
+		"execute-error": {
+			files: []GeneratedFile{{Name: "zz_execute.go", Template: template("execute.go.tmpl")}},
+			error: "can't evaluate field Unknown",
+		},
+		"invalid-go": {
+			files: []GeneratedFile{{Name: "zz_invalid.go", Template: template("invalid.go.tmpl")}},
+			error: template("invalid.go.tmpl") + " doesn't render valid Go code",
+		},
+		"missing-template": {
+			files: []GeneratedFile{{Name: "zz_missing.go", Template: template("missing.go.tmpl")}},
+			error: "no such file or directory",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			output := t.TempDir()
+			cmd := proxy.MustParseCommand([]string{"compile", "-o", filepath.Join(output, "b002", "_pkg_.a"), "-p", "example.com/bar", source}).(*proxy.CompileCommand)
+			generator := NewFileGenerator(tc.files)
+			paths, err := generator.generate(cmd, output)
+			if tc.error != "" {
+				require.ErrorContains(t, err, tc.error)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, paths, len(tc.expected))
+			for _, path := range paths {
+				require.Equal(t, output, filepath.Dir(path))
+				content, err := os.ReadFile(path)
+				require.NoError(t, err)
+				require.Equal(t, tc.expected[filepath.Base(path)], string(content))
+			}
+		})
+	}
+}
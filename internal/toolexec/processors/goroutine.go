@@ -0,0 +1,228 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+
+	"golang.org/x/tools/go/ast/astutil"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// GoLauncher rewrites `go` statements into calls to a launcher function taking the goroutine
+// body as a func(), such as `launcher.Go(func() { f(x) })`. As with `go` statements, the function
+// value and its arguments are evaluated before calling the launcher
+type GoLauncher struct {
+	launcher FuncRef
+	injector *PackageInjector
+	// Selector selects the packages the processor applies to
+	Selector PackageSelector
+}
+
+// NewGoLauncher initializes a command processor that starts goroutines through launcher,
+// a `func(func())` function. The launcher package is injected from source unless empty
+func NewGoLauncher(launcher FuncRef, source string) GoLauncher {
+	l := GoLauncher{
+		launcher: launcher,
+	}
+	if source != "" {
+		injector := NewPackageInjector(launcher.ImportPath, source)
+		l.injector = &injector
+	}
+	return l
+}
+
+// ProcessCompile visits a compile command and rewrites the `go` statements of its Go files
+func (l *GoLauncher) ProcessCompile(cmd *proxy.CompileCommand) {
+	if cmd.Flags.Std || cmd.Flags.Package == l.launcher.ImportPath || !l.Selector.Match(cmd) {
+		return
+	}
+
+	modified, err := RewriteTypedGoFiles(cmd, func(fset *token.FileSet, file *ast.File, info *types.Info) (bool, error) {
+		return rewriteGoStmts(fset, file, info, l.launcher), nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.Flags.Package, err)
+		os.Exit(1)
+	}
+	if modified && l.injector != nil {
+		proxy.ProcessCommand(cmd, l.injector.ProcessCompile)
+	}
+}
+
+// ProcessLink visits a link command and includes the launcher package and its dependencies
+func (l *GoLauncher) ProcessLink(cmd *proxy.LinkCommand) {
+	if l.injector != nil {
+		proxy.ProcessCommand(cmd, l.injector.ProcessLink)
+	}
+}
+
+// rewriteGoStmts replaces the `go` statements of file with calls to launcher and reports whether
+// file was modified. info may be nil, in which case the evaluation of function values and
+// arguments is decided from their syntax only
+func rewriteGoStmts(fset *token.FileSet, file *ast.File, info *types.Info, launcher FuncRef) bool {
+	found := false
+	ast.Inspect(file, func(n ast.Node) bool {
+		_, ok := n.(*ast.GoStmt)
+		found = found || ok
+		return !found
+	})
+	if !found {
+		return false
+	}
+
+	launcherName := AddImport(fset, file, launcher.ImportPath)
+	astutil.Apply(file, nil, func(c *astutil.Cursor) bool {
+		if goStmt, ok := c.Node().(*ast.GoStmt); ok {
+			log.Printf("====> Rewriting go statement at %s\n", fset.Position(goStmt.Pos()))
+			c.Replace(launchStmt(goStmt, info, launcherName, launcher.Name))
+		}
+		return true
+	})
+	return true
+}
+
+// launchStmt returns the block replacing goStmt, which evaluates the function value and arguments
+// of the goroutine call in order and passes a closure calling it to the launcher:
+//
+//	{
+//		__dd_fn := f
+//		__dd_arg0 := x
+//		launcher.Go(func() { __dd_fn(__dd_arg0) })
+//	}
+func launchStmt(goStmt *ast.GoStmt, info *types.Info, launcherName, launcherFunc string) *ast.BlockStmt {
+	call := goStmt.Call
+	block := &ast.BlockStmt{}
+	hoist := func(names []string, expr ast.Expr) {
+		lhs := make([]ast.Expr, 0, len(names))
+		for _, name := range names {
+			lhs = append(lhs, ast.NewIdent(name))
+		}
+		block.List = append(block.List, &ast.AssignStmt{Lhs: lhs, Tok: token.DEFINE, Rhs: []ast.Expr{expr}})
+	}
+
+	if needsEvaluation(call.Fun, info) {
+		hoist([]string{"__dd_fn"}, call.Fun)
+		call.Fun = ast.NewIdent("__dd_fn")
+	}
+
+	var args []ast.Expr
+	for i, arg := range call.Args {
+		tuple := tupleLen(arg, info)
+		switch {
+		case tuple > 1:
+			// f(g()) where g returns several values
+			names := make([]string, 0, tuple)
+			for j := 0; j < tuple; j++ {
+				names = append(names, fmt.Sprintf("__dd_arg%d_%d", i, j))
+				args = append(args, ast.NewIdent(names[j]))
+			}
+			hoist(names, arg)
+		case needsEvaluation(arg, info):
+			name := fmt.Sprintf("__dd_arg%d", i)
+			hoist([]string{name}, arg)
+			args = append(args, ast.NewIdent(name))
+		default:
+			args = append(args, arg)
+		}
+	}
+	call.Args = args
+
+	block.List = append(block.List, &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{X: ast.NewIdent(launcherName), Sel: ast.NewIdent(launcherFunc)},
+			Args: []ast.Expr{&ast.FuncLit{
+				Type: &ast.FuncType{Params: &ast.FieldList{}},
+				Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: call}}},
+			}},
+		},
+	})
+	return block
+}
+
+// needsEvaluation reports whether expr must be evaluated before starting the goroutine. Constants,
+// nil, builtins, package level functions and untyped values are left in place, as evaluating them
+// early has no visible effect and assigning them to variables could change their type
+func needsEvaluation(expr ast.Expr, info *types.Info) bool {
+	expr = ast.Unparen(expr)
+	if info != nil {
+		if tv, ok := info.Types[expr]; ok {
+			if tv.Value != nil || tv.IsNil() || tv.IsBuiltin() || tv.IsType() {
+				return false
+			}
+			if basic, ok := tv.Type.(*types.Basic); ok && basic.Info()&types.IsUntyped != 0 {
+				return false
+			}
+			if isPackageFunc(expr, info) {
+				return false
+			}
+			return true
+		}
+	}
+
+	// No type information, decide from the syntax
+	switch x := expr.(type) {
+	case *ast.BasicLit:
+		return false
+	case *ast.Ident:
+		switch x.Name {
+		case "nil", "true", "false", "iota":
+			return x.Obj != nil
+		}
+		// Identifiers declared in the file may be variables, others are likely functions
+		return x.Obj != nil && x.Obj.Kind == ast.Var
+	case *ast.SelectorExpr:
+		// Qualified identifiers, such as pkg.Func
+		ident, ok := x.X.(*ast.Ident)
+		return !ok || ident.Obj != nil
+	case *ast.IndexExpr, *ast.IndexListExpr:
+		// Likely a generic function instantiation
+		return false
+	}
+	return true
+}
+
+// isPackageFunc reports whether expr denotes a package level function, possibly instantiated
+func isPackageFunc(expr ast.Expr, info *types.Info) bool {
+	switch x := expr.(type) {
+	case *ast.IndexExpr:
+		expr = x.X
+	case *ast.IndexListExpr:
+		expr = x.X
+	}
+	var ident *ast.Ident
+	switch x := expr.(type) {
+	case *ast.Ident:
+		ident = x
+	case *ast.SelectorExpr:
+		if info.Selections[x] != nil {
+			// Method value or field
+			return false
+		}
+		ident = x.Sel
+	default:
+		return false
+	}
+	fn, ok := info.Uses[ident].(*types.Func)
+	return ok && fn.Type().(*types.Signature).Recv() == nil
+}
+
+// tupleLen returns the number of values of expr when it is a call returning several values
+func tupleLen(expr ast.Expr, info *types.Info) int {
+	if info == nil {
+		return 0
+	}
+	if tuple, ok := info.Types[expr].Type.(*types.Tuple); ok {
+		return tuple.Len()
+	}
+	return 0
+}
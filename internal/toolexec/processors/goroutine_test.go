@@ -0,0 +1,201 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bytes"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testLauncher = FuncRef{ImportPath: "example.com/launcher", Name: "Go"}
+
+func TestRewriteGoStmts(t *testing.T) {
+	for name, tc := range map[string]struct {
+		source   string
+		expected string
+	}{
+		"unchanged": {
+			source: `package main
+
+func main() {}
+`,
+		},
+		"rewritten": {
+			source: `package main
+
+type T struct{}
+
+func (T) M(int) {}
+
+func pair() (int, string) { return 0, "" }
+
+func work(n int, s string, xs ...int) {}
+
+func generic[E any](e E) {}
+
+func main() {
+	var t T
+	n := 1
+	go work(n, "s")
+	go work(pair())
+	go t.M(n)
+	go func() {}()
+	go generic(n)
+	go println(n)
+	go work(1, "", []int{n}...)
+}
+`,
+			expected: `package main
+
+import __dd_launcher "example.com/launcher"
+
+type T struct{}
+
+func (T) M(int) {}
+
+func pair() (int, string) { return 0, "" }
+
+func work(n int, s string, xs ...int) {}
+
+func generic[E any](e E) {}
+
+func main() {
+	var t T
+	n := 1
+	{
+		__dd_arg0 := n
+		__dd_launcher.Go(func() {
+			work(__dd_arg0, "s")
+		})
+	}
+	{
+		__dd_arg0_0, __dd_arg0_1 := pair()
+		__dd_launcher.Go(func() {
+			work(__dd_arg0_0, __dd_arg0_1)
+		})
+	}
+	{
+		__dd_fn := t.M
+		__dd_arg0 := n
+		__dd_launcher.Go(func() {
+			__dd_fn(__dd_arg0)
+		})
+	}
+	{
+		__dd_fn := func() {}
+		__dd_launcher.Go(func() {
+			__dd_fn()
+		})
+	}
+	{
+		__dd_arg0 := n
+		__dd_launcher.Go(func() {
+			generic(__dd_arg0)
+		})
+	}
+	{
+		__dd_arg0 := n
+		__dd_launcher.Go(func() {
+			println(__dd_arg0)
+		})
+	}
+	{
+		__dd_arg2 := []int{n}
+		__dd_launcher.Go(func() {
+			work(1, "", __dd_arg2...)
+		})
+	}
+}
+`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			fset := token.NewFileSet()
+			file, info := typeCheckSource(t, fset, tc.source)
+			modified := rewriteGoStmts(fset, file, info, testLauncher)
+			require.Equal(t, tc.expected != "", modified)
+			if !modified {
+				return
+			}
+			var buf bytes.Buffer
+			require.NoError(t, format.Node(&buf, fset, file))
+			require.Equal(t, tc.expected, buf.String())
+		})
+	}
+}
+
+// runWithLauncher rewrites the go statements of a main package source and runs it with a
+// launcher printing "launch" before starting goroutines
+func runWithLauncher(t *testing.T, source string) ([]byte, error) {
+	return runRewritten(t, source, func(fset *token.FileSet, file *ast.File, info *types.Info) (bool, error) {
+		return rewriteGoStmts(fset, file, info, testLauncher), nil
+	}, map[string]string{"launcher/launcher.go": `package launcher
+
+import "fmt"
+
+func Go(f func()) {
+	fmt.Println("launch")
+	go f()
+}
+`})
+}
+
+func TestGoLauncherEvaluationOrder(t *testing.T) {
+	output, err := runWithLauncher(t, `package main
+
+import "fmt"
+
+func trace(s string) int {
+	fmt.Println(s)
+	return 0
+}
+
+func fn() func(int, int) {
+	trace("fn")
+	return func(int, int) { fmt.Println("run") }
+}
+
+func main() {
+	done := make(chan struct{})
+	n := 1
+	go func(i int) {
+		fmt.Println("run", i)
+		close(done)
+	}(n)
+	n = 2
+	<-done
+
+	go fn()(trace("a"), trace("b"))
+	trace("after")
+	select {}
+}
+`)
+	// The program ends in a deadlock once all goroutines returned
+	require.Error(t, err)
+	require.Contains(t, string(output), "launch\nrun 1\nfn\na\nb\nlaunch\n")
+	require.Contains(t, string(output), "\nafter\n")
+	require.Contains(t, string(output), "\nrun\n")
+}
+
+func TestGoLauncherPanic(t *testing.T) {
+	output, err := runWithLauncher(t, `package main
+
+func main() {
+	go panic("boom")
+	select {}
+}
+`)
+	// go run reports the exit status of the crashed program
+	require.Error(t, err)
+	require.Contains(t, string(output), "panic: boom")
+	require.Contains(t, string(output), "exit status 2")
+}
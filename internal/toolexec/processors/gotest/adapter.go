@@ -0,0 +1,133 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package gotest
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/processors"
+)
+
+// Adapter instruments a third-party test framework whose packages start tests on behalf of the
+// test files, such as suites dispatching to their methods through reflection. The packages of
+// the framework are rewritten when they are compiled, so that the tests they start are run
+// through the runner like the ones of the test files
+type Adapter interface {
+	// Name identifies the framework in logs
+	Name() string
+	// Packages returns the import paths of the framework packages instrumented by the adapter
+	Packages() []string
+	// Rewrite instruments file, a type-checked file of one of the Packages, to run its tests
+	// through runner. It reports whether file was modified
+	Rewrite(fset *token.FileSet, file *ast.File, info *types.Info, runner Runner) (bool, error)
+}
+
+// DefaultAdapters are the adapters of the processors created by NewGoTestProcessor
+var DefaultAdapters = []Adapter{TestifyAdapter{}}
+
+// TestifyAdapter instruments the suites of github.com/stretchr/testify/suite. The suite methods
+// and the subtests started with Suite.Run are run through the runner, and suite.Run tags its
+// test with the suite through testrun.Suite
+type TestifyAdapter struct{}
+
+func (TestifyAdapter) Name() string {
+	return "testify"
+}
+
+func (TestifyAdapter) Packages() []string {
+	return []string{"github.com/stretchr/testify/suite"}
+}
+
+func (TestifyAdapter) Rewrite(fset *token.FileSet, file *ast.File, info *types.Info, runner Runner) (bool, error) {
+	if info == nil {
+		return false, fmt.Errorf("testify suites are only instrumented with type information")
+	}
+	data := &astTestFileData{AstFile: file, FileSet: fset}
+	collector := subTestCollector{file: file, info: info, fileData: data}
+	var subTests []*astSubTestData
+	modified := false
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil {
+			continue
+		}
+		subTests = append(subTests, collector.collectFunc(funcDecl.Type, funcDecl.Body)...)
+
+		// func Run(t *testing.T, suite TestingSuite)
+		if funcDecl.Recv == nil && funcDecl.Name.Name == "Run" && len(funcDecl.Type.Params.List) == 2 {
+			tParam, suiteParam := funcDecl.Type.Params.List[0], funcDecl.Type.Params.List[1]
+			if len(tParam.Names) == 1 && len(suiteParam.Names) == 1 && testingType(file, info, tParam.Type) == "T" {
+				funcDecl.Body.List = append([]ast.Stmt{&ast.ExprStmt{X: &ast.CallExpr{
+					Fun:  &ast.SelectorExpr{X: &ast.Ident{Name: RuntimeName}, Sel: &ast.Ident{Name: "Suite"}},
+					Args: []ast.Expr{&ast.Ident{Name: tParam.Names[0].Name}, &ast.Ident{Name: suiteParam.Names[0].Name}},
+				}}}, funcDecl.Body.List...)
+				modified = true
+			}
+		}
+	}
+	modified = rewriteSubTests(data, subTests, runner) || modified
+
+	// The suite methods are started through an interface: `r.Run(test.Name, test.F)`
+	var testingName string
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || !isTestRunnerInterfaceMethod(info, sel) {
+			return true
+		}
+		if testingName == "" {
+			testingName = processors.AddImport(fset, file, "testing")
+		}
+		fun, err := parser.ParseExpr(fmt.Sprintf(`func(__dd_r interface{ Run(string, func(*%[1]s.T)) bool }, name string, f func(*%[1]s.T)) bool {
+			if __dd_t, ok := __dd_r.(*%[1]s.T); ok {
+				return %[3]s.Run(__dd_t, name, f, %[2]s.Run)
+			}
+			return __dd_r.Run(name, f)
+		}`, testingName, runner.Name, RuntimeName))
+		if err != nil {
+			panic(err)
+		}
+		processors.ClearPositions(fun)
+		call.Args = append([]ast.Expr{sel.X}, call.Args...)
+		call.Fun = fun
+		modified = true
+		return true
+	})
+	return modified, nil
+}
+
+// isTestRunnerInterfaceMethod reports whether sel selects an interface method declared as
+// `Run(string, func(*testing.T)) bool`, which is implemented by *testing.T
+func isTestRunnerInterfaceMethod(info *types.Info, sel *ast.SelectorExpr) bool {
+	selection, ok := info.Selections[sel]
+	if !ok || selection.Kind() != types.MethodVal || sel.Sel.Name != "Run" || !types.IsInterface(selection.Recv()) {
+		return false
+	}
+	sig := selection.Obj().Type().(*types.Signature)
+	if sig.Params().Len() != 2 || sig.Results().Len() != 1 || sig.Variadic() {
+		return false
+	}
+	if !types.Identical(sig.Params().At(0).Type(), types.Typ[types.String]) || !types.Identical(sig.Results().At(0).Type(), types.Typ[types.Bool]) {
+		return false
+	}
+	f, ok := sig.Params().At(1).Type().Underlying().(*types.Signature)
+	if !ok || f.Params().Len() != 1 || f.Results().Len() != 0 {
+		return false
+	}
+	ptr, ok := f.Params().At(0).Type().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "testing" && named.Obj().Name() == "T"
+}
@@ -0,0 +1,226 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package gotest
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/processors"
+	"github.com/tonyredondo/rd-toolexec/testrun"
+)
+
+func TestTestifyAdapter(t *testing.T) {
+	// Trimmed down version of github.com/stretchr/testify/suite
+	src := `package suite
+
+import "testing"
+
+type TestingSuite interface {
+	T() *testing.T
+}
+
+type Suite struct {
+	t *testing.T
+}
+
+func (suite *Suite) T() *testing.T {
+	return suite.t
+}
+
+func (suite *Suite) Run(name string, subtest func()) bool {
+	return suite.T().Run(name, func(t *testing.T) {
+		subtest()
+	})
+}
+
+func Run(t *testing.T, suite TestingSuite) {
+	runTests(t, []testing.InternalTest{{Name: "TestFoo", F: func(t *testing.T) {}}})
+}
+
+func runTests(t testing.TB, tests []testing.InternalTest) {
+	r, ok := t.(runner)
+	if !ok {
+		return
+	}
+	for _, test := range tests {
+		r.Run(test.Name, test.F)
+	}
+}
+
+type runner interface {
+	Run(name string, f func(t *testing.T)) bool
+}
+`
+	path := filepath.Join(t.TempDir(), "suite.go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fset, file, info := parseTestFile(t, path, true)
+	modified, err := TestifyAdapter{}.Rewrite(fset, file, info, SDKRunner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !modified {
+		t.Fatal("file not modified")
+	}
+
+	out := filepath.Join(t.TempDir(), "suite.go")
+	if err := processors.PrintGoFile(out, fset, file); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`ddtestrun.Suite(t, suite)`,
+		`return ddtestrun.Run(suite.T(), name, func(t *testing.T) {`,
+		`}, ddtesting.Run)`,
+		`return ddtestrun.Run(__dd_t, name, f, ddtesting.Run)`,
+		`}(r, test.Name, test.F)`,
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Errorf("expected %q in:\n%s", expected, data)
+		}
+	}
+}
+
+func TestInstrumentedTestifySuite(t *testing.T) {
+	// Trimmed down version of github.com/stretchr/testify/suite, dispatching to the suite methods
+	// through reflection
+	suiteSrc := `package suite
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type TestingSuite interface {
+	T() *testing.T
+	SetT(*testing.T)
+}
+
+type Suite struct {
+	t *testing.T
+}
+
+func (suite *Suite) T() *testing.T {
+	return suite.t
+}
+
+func (suite *Suite) SetT(t *testing.T) {
+	suite.t = t
+}
+
+func (suite *Suite) Run(name string, subtest func()) bool {
+	old := suite.T()
+	defer suite.SetT(old)
+	return old.Run(name, func(t *testing.T) {
+		suite.SetT(t)
+		subtest()
+	})
+}
+
+func Run(t *testing.T, suite TestingSuite) {
+	var tests []testing.InternalTest
+	v := reflect.ValueOf(suite)
+	for i := 0; i < v.NumMethod(); i++ {
+		if name := v.Type().Method(i).Name; strings.HasPrefix(name, "Test") {
+			method := v.Method(i)
+			tests = append(tests, testing.InternalTest{Name: name, F: func(t *testing.T) {
+				suite.SetT(t)
+				method.Call(nil)
+			}})
+		}
+	}
+	runTests(t, tests)
+}
+
+func runTests(t testing.TB, tests []testing.InternalTest) {
+	r, ok := t.(runner)
+	if !ok {
+		return
+	}
+	for _, test := range tests {
+		r.Run(test.Name, test.F)
+	}
+}
+
+type runner interface {
+	Run(name string, f func(t *testing.T)) bool
+}
+`
+	testSrc := `package foo
+
+import (
+	"testing"
+
+	"example.com/foo/suite"
+)
+
+type FooSuite struct {
+	suite.Suite
+}
+
+func (s *FooSuite) TestBar() {
+	s.Run("sub", func() {})
+}
+
+func (s *FooSuite) TestBaz() {}
+
+func TestFoo(t *testing.T) {
+	suite.Run(t, new(FooSuite))
+}
+`
+	dir := writeRunnerModule(t)
+	suitePath := filepath.Join(dir, "suite", "suite.go")
+	if err := os.MkdirAll(filepath.Dir(suitePath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(suitePath, []byte(suiteSrc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fset, file, info := parseTestFile(t, suitePath, true)
+	if modified, err := (TestifyAdapter{}).Rewrite(fset, file, info, moduleRunner); err != nil || !modified {
+		t.Fatalf("file not modified: %v", err)
+	}
+	addRunnerImports(fset, file, moduleRunner)
+	if err := processors.PrintGoFile(suitePath, fset, file); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "foo_test.go"), []byte(testSrc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reportDir := t.TempDir()
+	output := goTest(t, dir, []string{testrun.ReportDirEnvVar + "=" + reportDir})
+	recs, err := testrun.ReadReports(reportDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		names = append(names, rec.Name)
+		if rec.Kind != testrun.KindTest || rec.Package != "example.com/foo" || rec.Test == nil || rec.Test.Suite != "FooSuite" || rec.Test.Failed {
+			t.Errorf("unexpected record %+v", rec)
+		}
+	}
+	slices.Sort(names)
+	if expected := []string{"TestFoo/TestBar", "TestFoo/TestBar/sub", "TestFoo/TestBaz"}; !slices.Equal(names, expected) {
+		t.Errorf("unexpected tests %v, expected %v in:\n%s", names, expected, output)
+	}
+	// The suite methods and their subtests are run through the runner
+	for _, expected := range []string{"run TestFoo/TestBar\n", "run TestFoo/TestBar/sub\n", "run TestFoo/TestBaz\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in:\n%s", expected, output)
+		}
+	}
+}
@@ -6,36 +6,106 @@ import (
 	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
 	"go/ast"
 	"go/parser"
-	"go/printer"
 	"go/token"
+	"go/types"
 	"golang.org/x/tools/go/ast/astutil"
 	"log"
 	"os"
 	"path"
-	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
-	ImportName    string = "ddtesting"
-	ImportPath    string = "github.com/DataDog/dd-sdk-go-testing/autoinstrument"
-	importPathAst        = `"` + ImportPath + `"`
+	ImportName string = "ddtesting"
+	ImportPath string = "github.com/DataDog/dd-sdk-go-testing/autoinstrument"
+
+	// RuntimeName is the name the testrun package is imported with
+	RuntimeName string = "ddtestrun"
+	// RuntimeImportPath is the import path of the testrun package, which the rewritten test
+	// files call along with the runner, whichever it is
+	RuntimeImportPath string = "github.com/tonyredondo/rd-toolexec/testrun"
 )
 
+// Runner is the package the instrumented tests are run through. It declares the functions the
+// testrun package runs the tests with:
+//
+//	func RunM(m *testing.M) int
+//	func Run(t *testing.T, name string, f func(*testing.T)) bool
+//
+// RunM runs the top level tests of m, which the testmain package gives access to, and Run runs
+// the subtests.
+//
+// The runner is called through the testrun package, injected along with it, which measures the
+// benchmarks and reports the fuzz inputs, the examples and the tests of suites: testrun.RunM and
+// testrun.Run run the tests with the RunM and Run functions of the runner, the benchmarks and
+// sub-benchmarks are run by testrun.RunBenchmark and testrun.RunB, testrun.RunFuzz replaces
+// testing.F.Fuzz, testrun.Example is applied to the entries of the examples table of the
+// generated _testmain.go, and testrun.Suite is called by the test framework packages
+// instrumented by the adapters to tag a test and its subtests with a suite. testrun.RunM and
+// testrun.Run skip the tests listed in the local skip-list file read by the skiplist package, and
+// retry the failing tests with the retry package, reporting the flaky and the failed ones.
+// testrun.RunM only runs the tests owned by the shard of the process with shard.RunWith, which
+// writes the summary the coordinator of the shards verifies
+type Runner struct {
+	// Name is the name the runner package is imported with
+	Name string
+	// ImportPath is the import path of the runner package
+	ImportPath string
+}
+
+// SDKRunner is the runner of the DataDog testing SDK
+var SDKRunner = Runner{Name: ImportName, ImportPath: ImportPath}
+
 type GoTestProcessor struct {
 	testingSdkSourcePath string
+	runner               Runner
 	packageInjector      processors.PackageInjector
+	runtimeInjector      processors.PackageInjector
+	linkVerifier         processors.LinkVerifier
+	// Selector selects the packages whose tests are instrumented
+	Selector processors.PackageSelector
+	// Adapters instrument the third-party test frameworks
+	Adapters []Adapter
+	// ManifestDir is the directory the test manifests are written to, none are written when
+	// empty. Packages are only listed when they are compiled, not when go reuses a cached build
+	ManifestDir string
 }
 
 type astSubTestData struct {
-	TestName              string
-	TestingTAttributeName string
-	Call                  *ast.CallExpr
+	// TestName is the name of the subtest when it is a string literal
+	TestName string
+	// TestingType is the testing type of the receiver of the call: "T", "B", "F" or "M"
+	TestingType string
+	// Receiver is the expression of the receiver of the call
+	Receiver ast.Expr
+	Call     *ast.CallExpr
+	// Parent is the subtest whose function starts this one, nil when started by the test itself
+	Parent *astSubTestData
 }
 
+// astMethodValueData is a method value of an instrumented testing method, as in `run := t.Run`
+type astMethodValueData struct {
+	TestingType string
+	Receiver    ast.Expr
+}
+
+// testKind is the kind of a test function, told by its name prefix and parameter type
+type testKind int
+
+const (
+	testKindTest testKind = iota
+	testKindBenchmark
+	testKindFuzz
+	testKindExample
+)
+
 type astTestData struct {
 	TestName                 string
+	Kind                     testKind
 	TestingTAttributeName    string
 	AstDeclaration           *ast.FuncDecl
 	SubTests                 []*astSubTestData
@@ -43,10 +113,15 @@ type astTestData struct {
 	IsMain                   bool
 	IsTestMainGoFile         bool
 	MRunCallInTestMainGoFile *ast.CallExpr
+	// ExampleTable is the examples table of _testmain.go holding the example at ExampleIndex
+	ExampleTable *ast.CompositeLit
+	ExampleIndex int
 }
 
 type astTestFileData struct {
 	Tests                   []*astTestData
+	HelperSubTests          []*astSubTestData
+	MethodValues            map[*ast.SelectorExpr]*astMethodValueData
 	FilePath                string
 	FileSet                 *token.FileSet
 	AstFile                 *ast.File
@@ -69,14 +144,31 @@ var (
 	fileContent []string
 )
 
-func NewGoTestProcessor(sdkSourcePath string) GoTestProcessor {
+// NewGoTestProcessor initializes a command processor running the tests through the testing SDK,
+// injected from sdkSourcePath, and the testrun package, injected from runtimeSourcePath
+func NewGoTestProcessor(sdkSourcePath string, runtimeSourcePath string, verifyPolicy processors.VerifyPolicy) GoTestProcessor {
+	return NewGoTestProcessorWithRunner(SDKRunner, sdkSourcePath, runtimeSourcePath, verifyPolicy)
+}
+
+// NewGoTestProcessorWithRunner is like NewGoTestProcessor but runs the tests through runner,
+// whose package is injected from sourcePath
+func NewGoTestProcessorWithRunner(runner Runner, sourcePath string, runtimeSourcePath string, verifyPolicy processors.VerifyPolicy) GoTestProcessor {
 	return GoTestProcessor{
-		testingSdkSourcePath: sdkSourcePath,
-		packageInjector:      processors.NewPackageInjectorWithRequired(ImportPath, sdkSourcePath, "testing"),
+		testingSdkSourcePath: sourcePath,
+		runner:               runner,
+		packageInjector:      processors.NewPackageInjectorWithRequired(runner.ImportPath, sourcePath, "testing"),
+		runtimeInjector:      processors.NewPackageInjectorWithRequired(RuntimeImportPath, runtimeSourcePath, "testing"),
+		linkVerifier:         processors.NewLinkVerifierWithRequired(verifyPolicy, "testing", runner.ImportPath, RuntimeImportPath),
+		Adapters:             DefaultAdapters,
 	}
 }
 
 func (p *GoTestProcessor) ProcessCompile(cmd *proxy.CompileCommand) {
+	if !p.Selector.Match(cmd) {
+		log.Printf("[%s] Skipping %s, not selected\n", cmd.Stage(), cmd.Flags.Package)
+		return
+	}
+
 	// Extract BuildId
 	cmdArgs := cmd.Args()
 	for idx, val := range cmdArgs {
@@ -87,35 +179,83 @@ func (p *GoTestProcessor) ProcessCompile(cmd *proxy.CompileCommand) {
 		}
 	}
 
+	// Instrument the test framework packages
+	for _, adapter := range p.Adapters {
+		if !slices.Contains(adapter.Packages(), cmd.Flags.Package) {
+			continue
+		}
+		log.Printf("[%s] Instrumenting %s tests in %s\n", cmd.Stage(), adapter.Name(), cmd.Flags.Package)
+		_, err := processors.RewriteTypedGoFiles(cmd, func(fset *token.FileSet, file *ast.File, info *types.Info) (bool, error) {
+			modified, err := adapter.Rewrite(fset, file, info, p.runner)
+			if modified {
+				addRunnerImports(fset, file, p.runner)
+			}
+			return modified, err
+		})
+		if err != nil {
+			log.Printf("Error instrumenting %s: %v\n", cmd.Flags.Package, err)
+		}
+	}
+
+	// Parse and type-check the files from compile command
+	fileSet := token.NewFileSet()
+	var paths []string
+	var astFiles []*ast.File
+	goFiles := cmd.GoFiles()
+	if !slices.ContainsFunc(goFiles, isTestFile) {
+		goFiles = nil
+	}
+	for _, file := range goFiles {
+		astFile, err := parser.ParseFile(fileSet, file, nil, parser.SkipObjectResolution)
+		if err != nil {
+			log.Printf("Error parsing %s: %v\n", file, err)
+			continue
+		}
+		paths = append(paths, file)
+		astFiles = append(astFiles, astFile)
+	}
+	var info *types.Info
+	if len(astFiles) > 0 {
+		var err error
+		if info, err = processors.TypeCheck(cmd, fileSet, astFiles); err != nil {
+			log.Printf("Type-checking %s: %v\n", cmd.Flags.Package, err)
+		}
+	}
+
 	// Process files from compile command
-	for _, file := range cmd.GoFiles() {
-		if strings.HasSuffix(file, "_test.go") ||
-			strings.Contains(file, "_testmain.go") {
+	var testFiles []*astTestFileData
+	for i, file := range paths {
+		if isTestFile(file) {
 			// Let's process all _test.go files or the test binary main file
 			log.Printf("Adding %s\n", file)
-			testData, err := createTestData(file)
-			if err == nil {
-				var selectedContainer *astTestContainer
-				for _, container := range containers {
-					if container.Package == testData.Package {
-						selectedContainer = container
-						break
-					}
-				}
-				if selectedContainer == nil {
-					selectedContainer = new(astTestContainer)
-					selectedContainer.Package = testData.Package
-					containers = append(containers, selectedContainer)
+			testData := createTestData(fileSet, file, astFiles[i], info, p.runner)
+			var selectedContainer *astTestContainer
+			for _, container := range containers {
+				if container.Package == testData.Package {
+					selectedContainer = container
+					break
 				}
-				testData.Parent = selectedContainer
-				selectedContainer.Files = append(selectedContainer.Files, testData)
 			}
+			if selectedContainer == nil {
+				selectedContainer = new(astTestContainer)
+				selectedContainer.Package = testData.Package
+				containers = append(containers, selectedContainer)
+			}
+			testData.Parent = selectedContainer
+			selectedContainer.Files = append(selectedContainer.Files, testData)
+			testFiles = append(testFiles, testData)
+		}
+	}
+
+	if p.ManifestDir != "" && slices.ContainsFunc(testFiles, func(file *astTestFileData) bool { return !file.IsTestMainGoFile }) {
+		if err := WriteManifest(p.ManifestDir, createManifest(cmd.Flags.Package, testFiles)); err != nil {
+			log.Printf("Error writing the test manifest of %s: %v\n", cmd.Flags.Package, err)
 		}
 	}
 
 	if len(containers) > 0 {
 		// We have data to process.
-		processContainer()
+		processContainer(p.runner)
 	}
 
 	// Create replacement map from processed files
@@ -135,130 +275,402 @@ func (p *GoTestProcessor) ProcessCompile(cmd *proxy.CompileCommand) {
 		swapper := processors.NewGoFileSwapper(replacementMap)
 		proxy.ProcessCommand(cmd, swapper.ProcessCompile)
 		log.Println(cmd.Args())
+
+		// The rewritten files are only needed by this compile command
+		proxy.RegisterPostProcessor(cmd, func(_ *proxy.CompileCommand, _ proxy.CommandResult) error {
+			for _, tmpFile := range replacementMap {
+				if err := os.Remove(tmpFile); err != nil {
+					log.Printf("couldn't remove %s: %v\n", tmpFile, err)
+				}
+			}
+			return nil
+		})
 	}
 
 	// Add library injection processor
 	proxy.ProcessCommand(cmd, p.packageInjector.ProcessCompile)
+	proxy.ProcessCommand(cmd, p.runtimeInjector.ProcessCompile)
 }
 
 func (p *GoTestProcessor) ProcessLink(cmd *proxy.LinkCommand) {
 	// Add library injection processor
 	proxy.ProcessCommand(cmd, p.packageInjector.ProcessLink)
+	proxy.ProcessCommand(cmd, p.runtimeInjector.ProcessLink)
+	// Make sure the library was linked in the test binary
+	proxy.RegisterPostProcessor(cmd, p.linkVerifier.PostProcessLink)
 }
 
-func createTestData(file string) (*astTestFileData, error) {
-	fileSet := token.NewFileSet()
+// isTestFile reports whether file is a _test.go file or the test binary main file
+func isTestFile(file string) bool {
+	return strings.HasSuffix(file, "_test.go") || strings.Contains(file, "_testmain.go")
+}
+
+// createTestData extracts the tests of astFile, parsed from file. The types of the parameters
+// of test functions are resolved with info, which may be nil or partial when type-checking
+// failed, and with the imports of astFile otherwise
+func createTestData(fileSet *token.FileSet, file string, astFile *ast.File, info *types.Info, runner Runner) *astTestFileData {
 	testFileData := new(astTestFileData)
 	testFileData.FilePath = file
 	testFileData.FileSet = fileSet
 
 	var testDataArray []*astTestData
-	astFile, err := parser.ParseFile(fileSet, file, nil, parser.SkipObjectResolution)
-	if err != nil {
-		return testFileData, err
-	} else {
-		testFileData.AstFile = astFile
-		testFileData.Package = astFile.Name.String()
-		testFileData.ContainsDDTestingImport = false
-		for _, v2 := range astFile.Imports {
-			if v2.Name.String() == ImportName && v2.Path.Value == importPathAst {
-				testFileData.ContainsDDTestingImport = true
-				break
-			}
+	testFileData.AstFile = astFile
+	testFileData.Package = astFile.Name.String()
+	testFileData.ContainsDDTestingImport = false
+	for _, v2 := range astFile.Imports {
+		if v2.Name.String() == runner.Name && v2.Path.Value == strconv.Quote(runner.ImportPath) {
+			testFileData.ContainsDDTestingImport = true
+			break
 		}
+	}
 
-		ast.Inspect(astFile, func(n ast.Node) bool {
-			if funcDecl, ok := n.(*ast.FuncDecl); ok {
-				if strings.HasPrefix(funcDecl.Name.String(), "Test") {
-
-					// Let's extract the parameter name for `testing.T`
-					var tParam string
-					isMain := false
-					for _, pM := range funcDecl.Type.Params.List {
-						for _, pMName := range pM.Names {
-							switch pMType := pM.Type.(type) {
-							case *ast.StarExpr:
-								switch xType := pMType.X.(type) {
-								case *ast.SelectorExpr:
-									if xType.X.(*ast.Ident).String() == "testing" {
-										xTypeSel := xType.Sel.String()
-										if xTypeSel == "T" {
-											tParam = pMName.Name
-											break
-										}
-										if xTypeSel == "M" {
-											tParam = pMName.Name
-											isMain = true
-											break
-										}
-									}
-								}
-							}
+	collector := subTestCollector{file: astFile, info: info, fileData: testFileData}
+	ast.Inspect(astFile, func(n ast.Node) bool {
+		if funcDecl, ok := n.(*ast.FuncDecl); ok {
+			isBenchmark := strings.HasPrefix(funcDecl.Name.String(), "Benchmark")
+			isFuzz := strings.HasPrefix(funcDecl.Name.String(), "Fuzz")
+			isMethod := funcDecl.Recv != nil
+			if !isMethod && (strings.HasPrefix(funcDecl.Name.String(), "Test") || isBenchmark || isFuzz) {
+
+				// Let's extract the parameter name for `testing.T`, `testing.B`, `testing.F` or `testing.M`
+				var tParam string
+				isMain := false
+				kind := testKindTest
+				for _, pM := range funcDecl.Type.Params.List {
+					xTypeSel := testingType(astFile, info, pM.Type)
+					for _, pMName := range pM.Names {
+						if xTypeSel == "T" && !isBenchmark && !isFuzz {
+							tParam = pMName.Name
+							break
+						}
+						if xTypeSel == "B" && isBenchmark {
+							tParam = pMName.Name
+							kind = testKindBenchmark
+							break
+						}
+						if xTypeSel == "F" && isFuzz {
+							tParam = pMName.Name
+							kind = testKindFuzz
+							break
+						}
+						if xTypeSel == "M" && !isBenchmark && !isFuzz {
+							tParam = pMName.Name
+							isMain = true
+							break
 						}
 					}
+				}
+				if (isBenchmark && kind != testKindBenchmark) || (isFuzz && kind != testKindFuzz) {
+					// Not a benchmark or fuzz function, e.g. a helper named BenchmarkXxx
+					testFileData.HelperSubTests = append(testFileData.HelperSubTests, collector.collectFunc(funcDecl.Type, funcDecl.Body)...)
+					return false
+				}
 
-					testData := new(astTestData)
-					testData.TestName = funcDecl.Name.Name
-					testData.TestingTAttributeName = tParam
-					testData.AstDeclaration = funcDecl
-					testData.Parent = testFileData
-					testData.IsMain = isMain
+				testData := new(astTestData)
+				testData.TestName = funcDecl.Name.Name
+				testData.Kind = kind
+				testData.TestingTAttributeName = tParam
+				testData.AstDeclaration = funcDecl
+				testData.Parent = testFileData
+				testData.IsMain = isMain
 
-					if testData.TestName == "TestMain" {
-						testFileData.TestMain = testData
-					}
+				if testData.TestName == "TestMain" {
+					testFileData.TestMain = testData
+				}
 
-					ast.Inspect(funcDecl.Body, func(node ast.Node) bool {
-						switch t := node.(type) {
-						case *ast.CallExpr:
-							if fun, ok := t.Fun.(*ast.SelectorExpr); ok {
-								if fun.Sel.Name == "Run" {
-									if testData.TestingTAttributeName == fmt.Sprintf("%v", fun.X) {
-										innerTest := new(astSubTestData)
-										innerTest.TestName = fmt.Sprintf("%v.%v", fun.X, fun.Sel.String())
-										innerTest.TestingTAttributeName = ""
-										innerTest.Call = t
-										testData.SubTests = append(testData.SubTests, innerTest)
-									}
-								}
-							}
-						}
-						return true
-					})
-
-					testDataArray = append(testDataArray, testData)
-				} else if strings.HasPrefix(funcDecl.Name.String(), "main") {
-					ast.Inspect(funcDecl.Body, func(bNode ast.Node) bool {
-						if callExpr, ok := bNode.(*ast.CallExpr); ok {
-							if fun, ok := callExpr.Fun.(*ast.SelectorExpr); ok {
-								if fun.Sel.Name == "Run" && fmt.Sprintf("%v", fun.X) == "m" {
-									testData := new(astTestData)
-									testData.TestName = "_testmain.go"
-									testData.TestingTAttributeName = "m"
-									testData.AstDeclaration = funcDecl
-									testData.Parent = testFileData
-									testData.IsTestMainGoFile = true
-									testData.MRunCallInTestMainGoFile = callExpr
-									testFileData.IsTestMainGoFile = true
-									testDataArray = append(testDataArray, testData)
-									return false
-								}
+				testData.SubTests = collector.collectFunc(funcDecl.Type, funcDecl.Body)
+
+				testDataArray = append(testDataArray, testData)
+			} else if !isMethod && isExample(funcDecl) {
+				// Examples are run by the testing package from the examples table of _testmain.go
+				testDataArray = append(testDataArray, &astTestData{
+					TestName:       funcDecl.Name.Name,
+					Kind:           testKindExample,
+					AstDeclaration: funcDecl,
+					Parent:         testFileData,
+				})
+				return false
+			} else if isMethod || !strings.HasPrefix(funcDecl.Name.String(), "main") {
+				// Helpers may start subtests with the testing values they are given
+				testFileData.HelperSubTests = append(testFileData.HelperSubTests, collector.collectFunc(funcDecl.Type, funcDecl.Body)...)
+				return false
+			} else {
+				ast.Inspect(funcDecl.Body, func(bNode ast.Node) bool {
+					if callExpr, ok := bNode.(*ast.CallExpr); ok {
+						if fun, ok := callExpr.Fun.(*ast.SelectorExpr); ok {
+							if fun.Sel.Name == "Run" && fmt.Sprintf("%v", fun.X) == "m" {
+								testData := new(astTestData)
+								testData.TestName = "_testmain.go"
+								testData.TestingTAttributeName = "m"
+								testData.AstDeclaration = funcDecl
+								testData.Parent = testFileData
+								testData.IsTestMainGoFile = true
+								testData.MRunCallInTestMainGoFile = callExpr
+								testFileData.IsTestMainGoFile = true
+								testDataArray = append(testDataArray, testData)
+								return false
 							}
 						}
-						return true
-					})
-					return false
+					}
+					return true
+				})
+				return false
+			}
+		}
+		if compositeLit, ok := n.(*ast.CompositeLit); ok && strings.Contains(file, "_testmain.go") && isExampleTable(compositeLit) {
+			for i, elt := range compositeLit.Elts {
+				example, ok := elt.(*ast.CompositeLit)
+				if !ok || len(example.Elts) == 0 {
+					continue
 				}
+				name, ok := example.Elts[0].(*ast.BasicLit)
+				if !ok || name.Kind != token.STRING {
+					continue
+				}
+				testName, err := strconv.Unquote(name.Value)
+				if err != nil {
+					continue
+				}
+				testDataArray = append(testDataArray, &astTestData{
+					TestName:         testName,
+					Kind:             testKindExample,
+					Parent:           testFileData,
+					IsTestMainGoFile: true,
+					ExampleTable:     compositeLit,
+					ExampleIndex:     i,
+				})
 			}
-			return true
-		})
-	}
+			return false
+		}
+		return true
+	})
 
 	testFileData.Tests = testDataArray
-	return testFileData, nil
+	return testFileData
+}
+
+// isExample reports whether funcDecl is declared as an example, `func ExampleXxx()`. Only
+// the examples with an output comment are run, they are the ones in the examples table
+func isExample(funcDecl *ast.FuncDecl) bool {
+	name := funcDecl.Name.Name
+	if !strings.HasPrefix(name, "Example") || funcDecl.Type.TypeParams != nil {
+		return false
+	}
+	if r, _ := utf8.DecodeRuneInString(name[len("Example"):]); unicode.IsLower(r) {
+		return false
+	}
+	return funcDecl.Type.Params.NumFields() == 0 && funcDecl.Type.Results.NumFields() == 0
+}
+
+// isExampleTable reports whether compositeLit is the examples table of _testmain.go, as in
+// `[]testing.InternalExample{{"ExampleFoo", _test.ExampleFoo, "foo\n", false}}`
+func isExampleTable(compositeLit *ast.CompositeLit) bool {
+	arrayType, ok := compositeLit.Type.(*ast.ArrayType)
+	if !ok {
+		return false
+	}
+	sel, ok := arrayType.Elt.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == "InternalExample"
+}
+
+// testingType returns the name of the type of the testing package that the type expression
+// expr denotes: "T", "B", "F" or "M" for pointers to them, and "TB" for the interface, or ""
+func testingType(file *ast.File, info *types.Info, expr ast.Expr) string {
+	if info != nil {
+		if typ := info.TypeOf(expr); typ != nil && typ != types.Typ[types.Invalid] {
+			isPointer := false
+			if ptr, ok := typ.(*types.Pointer); ok {
+				isPointer = true
+				typ = ptr.Elem()
+			}
+			named, ok := types.Unalias(typ).(*types.Named)
+			if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != "testing" {
+				return ""
+			}
+			return testingTypeName(named.Obj().Name(), isPointer)
+		}
+	}
+
+	// Without type information, the testing package is looked up in the imports of the file
+	isPointer := false
+	if star, ok := expr.(*ast.StarExpr); ok {
+		isPointer = true
+		expr = star.X
+	}
+	importName := processors.ImportName(file, "testing")
+	switch x := expr.(type) {
+	case *ast.SelectorExpr:
+		if pkg, ok := x.X.(*ast.Ident); ok && pkg.Name == importName {
+			return testingTypeName(x.Sel.Name, isPointer)
+		}
+	case *ast.Ident:
+		if importName == "." {
+			return testingTypeName(x.Name, isPointer)
+		}
+	}
+	return ""
+}
+
+// testingMethods maps the testing types to their method run through the runner
+var testingMethods = map[string]string{"T": "Run", "B": "Run", "F": "Fuzz", "M": "Run"}
+
+// subTestCollector collects the calls of the testing methods run through the runner:
+// (*testing.T).Run, (*testing.B).Run, (*testing.F).Fuzz and (*testing.M).Run
+type subTestCollector struct {
+	file     *ast.File
+	info     *types.Info
+	fileData *astTestFileData
+}
+
+// collectFunc collects the calls in the function of type funcType and body body
+func (c *subTestCollector) collectFunc(funcType *ast.FuncType, body *ast.BlockStmt) []*astSubTestData {
+	if body == nil {
+		return nil
+	}
+	return c.collect(body, c.scope(nil, funcType), nil)
+}
+
+// scope returns the testing values in scope in the function of type funcType, nested in a
+// function with the testing values params in scope. It is only used without type information
+func (c *subTestCollector) scope(params map[string]string, funcType *ast.FuncType) map[string]string {
+	scope := make(map[string]string, len(params))
+	for name, testingType := range params {
+		scope[name] = testingType
+	}
+	for _, field := range funcType.Params.List {
+		testingType := testingType(c.file, c.info, field.Type)
+		for _, name := range field.Names {
+			if testingType != "" {
+				scope[name.Name] = testingType
+			} else {
+				delete(scope, name.Name)
+			}
+		}
+	}
+	return scope
+}
+
+// collect collects the calls in node, which are started by the subtest parent
+func (c *subTestCollector) collect(node ast.Node, params map[string]string, parent *astSubTestData) []*astSubTestData {
+	var subTests []*astSubTestData
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			subTests = append(subTests, c.collect(n.Body, c.scope(params, n.Type), parent)...)
+			return false
+		case *ast.CallExpr:
+			sel, ok := ast.Unparen(n.Fun).(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			testingType, receiver := c.testingMethod(sel, params)
+			if testingType == "" {
+				return true
+			}
+			subTest := &astSubTestData{TestingType: testingType, Receiver: receiver, Call: n, Parent: parent}
+			if len(n.Args) > 0 {
+				if lit, ok := n.Args[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+					subTest.TestName, _ = strconv.Unquote(lit.Value)
+				}
+			}
+			subTests = append(subTests, subTest)
+			subTests = append(subTests, c.collect(sel.X, params, parent)...)
+			for _, arg := range n.Args {
+				subTests = append(subTests, c.collect(arg, params, subTest)...)
+			}
+			return false
+		case *ast.SelectorExpr:
+			// Selectors that are not called are method values
+			if testingType, receiver := c.testingMethod(n, params); testingType != "" {
+				if c.fileData.MethodValues == nil {
+					c.fileData.MethodValues = make(map[*ast.SelectorExpr]*astMethodValueData)
+				}
+				c.fileData.MethodValues[n] = &astMethodValueData{TestingType: testingType, Receiver: receiver}
+				subTests = append(subTests, c.collect(n.X, params, parent)...)
+				return false
+			}
+		}
+		return true
+	})
+	return subTests
+}
+
+// testingMethod returns the testing type of the receiver of the method selected by sel, and the
+// expression of the receiver, when sel selects a method run through the runner
+func (c *subTestCollector) testingMethod(sel *ast.SelectorExpr, params map[string]string) (string, ast.Expr) {
+	if c.info != nil {
+		if selection, ok := c.info.Selections[sel]; ok {
+			if selection.Kind() != types.MethodVal {
+				return "", nil
+			}
+			fn := selection.Obj()
+			if fn.Pkg() == nil || fn.Pkg().Path() != "testing" {
+				return "", nil
+			}
+			recv := fn.Type().(*types.Signature).Recv()
+			ptr, ok := recv.Type().(*types.Pointer)
+			if !ok {
+				return "", nil
+			}
+			named, ok := ptr.Elem().(*types.Named)
+			if !ok || testingMethods[named.Obj().Name()] != fn.Name() {
+				return "", nil
+			}
+			receiver := embeddedReceiver(sel.X, selection)
+			if receiver == nil {
+				return "", nil
+			}
+			return named.Obj().Name(), receiver
+		}
+	}
+
+	// Without type information, only the testing parameters in scope are recognized
+	if x, ok := sel.X.(*ast.Ident); ok {
+		if testingType := params[x.Name]; testingType != "" && testingMethods[testingType] == sel.Sel.Name {
+			return testingType, x
+		}
+	}
+	return "", nil
+}
+
+// embeddedReceiver returns the expression of the receiver of the method of selection, selected
+// from x through the embedded fields leading to the method, or nil if one of them is unexported
+func embeddedReceiver(x ast.Expr, selection *types.Selection) ast.Expr {
+	typ := selection.Recv()
+	index := selection.Index()
+	for _, i := range index[:len(index)-1] {
+		if ptr, ok := typ.Underlying().(*types.Pointer); ok {
+			typ = ptr.Elem()
+		}
+		st, ok := typ.Underlying().(*types.Struct)
+		if !ok {
+			return nil
+		}
+		field := st.Field(i)
+		if !field.Exported() {
+			return nil
+		}
+		x = &ast.SelectorExpr{X: x, Sel: &ast.Ident{Name: field.Name()}}
+		typ = field.Type()
+	}
+	return x
+}
+
+func testingTypeName(name string, isPointer bool) string {
+	switch name {
+	case "T", "B", "F", "M":
+		if isPointer {
+			return name
+		}
+	case "TB":
+		if !isPointer {
+			return name
+		}
+	}
+	return ""
 }
 
-func processContainer() {
+func processContainer(runner Runner) {
 
 	filePath := path.Join(os.TempDir(), fmt.Sprintf(".test_main_packages_%s", buildId))
 	if bytes, err := os.ReadFile(filePath); err == nil {
@@ -271,7 +683,7 @@ func processContainer() {
 			hasTestMainGoFile := false
 			var testMainTestData *astTestData
 			for _, file := range container.Files {
-				isDirty = processFile(file) || isDirty
+				isDirty = processFile(file, runner) || isDirty
 				hasTestMainGoFile = file.IsTestMainGoFile || hasTestMainGoFile
 				if file.TestMain != nil {
 					testMainTestData = file.TestMain
@@ -306,121 +718,244 @@ func processContainer() {
 						continue
 					}
 
-					if !astutil.UsesImport(packageFile.AstFile, ImportPath) {
-						astutil.AddNamedImport(packageFile.FileSet, packageFile.AstFile, ImportName, ImportPath)
-					}
-					packageFile.AstFile.Decls = append(packageFile.AstFile.Decls, getTestMainDeclarationSentence(ImportName, "m"))
+					testingName := processors.ImportName(packageFile.AstFile, "testing")
+					packageFile.AstFile.Decls = append(packageFile.AstFile.Decls, getTestMainDeclarationSentence(runner.Name, testingName, "m"))
+					addRunnerImports(packageFile.FileSet, packageFile.AstFile, runner)
 
 					if packageFile.DestinationFilePath == "" {
-						fileName := filepath.Base(packageFile.FilePath)
-						fileNameExt := path.Ext(fileName)
-						fileName = fmt.Sprintf("%v_*_%v", strings.TrimRight(fileName, fileNameExt), fileNameExt)
-						if tmpFile, err := os.CreateTemp("", fileName); err == nil {
-							packageFile.DestinationFilePath = tmpFile.Name()
-							tmpFile.Close()
+						if tmpFile, err := processors.NewTempGoFile(packageFile.FilePath); err == nil {
+							packageFile.DestinationFilePath = tmpFile
 						}
 					}
 
-					f, err := os.Create(packageFile.DestinationFilePath)
-					if err == nil {
-						defer f.Close()
-						err = printer.Fprint(f, packageFile.FileSet, packageFile.AstFile)
-						if err != nil {
-							fmt.Println(err)
-							continue
-						}
-
-						fileContent = append(fileContent, fmt.Sprintf("%s\n", packageFile.Package))
-						os.WriteFile(filePath, []byte(strings.Join(fileContent, "\n")), 0666)
-						break
+					err := processors.PrintGoFile(packageFile.DestinationFilePath, packageFile.FileSet, packageFile.AstFile)
+					if err != nil {
+						fmt.Println(err)
+						continue
 					}
+
+					fileContent = append(fileContent, fmt.Sprintf("%s\n", packageFile.Package))
+					os.WriteFile(filePath, []byte(strings.Join(fileContent, "\n")), 0666)
+					break
 				}
 			}
 		}
 	}
 }
 
-func processFile(file *astTestFileData) bool {
-	if !file.ContainsDDTestingImport && len(file.Tests) > 0 {
+func processFile(file *astTestFileData, runner Runner) bool {
+	if !file.ContainsDDTestingImport && (len(file.Tests) > 0 || len(file.HelperSubTests) > 0 || len(file.MethodValues) > 0) {
 		isDirty := false
+		subTests := file.HelperSubTests
 		for _, test := range file.Tests {
 			if test.IsTestMainGoFile && test.MRunCallInTestMainGoFile != nil {
-				newSubTestCall := getTestMainRunCallExpression(ImportName, "m")
-				newSubTestCall.Args = append(newSubTestCall.Args, test.MRunCallInTestMainGoFile.Args...)
+				newSubTestCall := getTestMainRunCallExpression(runner.Name, "m")
 				test.MRunCallInTestMainGoFile.Fun = newSubTestCall.Fun
 				test.MRunCallInTestMainGoFile.Args = newSubTestCall.Args
 				isDirty = true
 			}
-			for _, subTest := range test.SubTests {
-				var newSubTestCall *ast.CallExpr
-				if test.IsMain {
-					newSubTestCall = getTestMainRunCallExpression(ImportName, test.TestingTAttributeName)
-				} else {
-					newSubTestCall = getStartSubTestSentence(ImportName, test.TestingTAttributeName)
-				}
-				newSubTestCall.Args = append(newSubTestCall.Args, subTest.Call.Args...)
-				subTest.Call.Fun = newSubTestCall.Fun
-				subTest.Call.Args = newSubTestCall.Args
+			if test.Kind == testKindBenchmark && test.TestingTAttributeName != "" && test.TestingTAttributeName != "_" {
+				wrapBenchmarkBody(test.AstDeclaration, test.TestingTAttributeName)
+				isDirty = true
+			}
+			if test.Kind == testKindExample && test.ExampleTable != nil {
+				wrapExample(test.ExampleTable, test.ExampleIndex, processors.ImportName(file.AstFile, "testing"))
 				isDirty = true
 			}
+			subTests = append(subTests, test.SubTests...)
 		}
+		isDirty = rewriteSubTests(file, subTests, runner) || isDirty
 
 		if isDirty {
-			if !astutil.UsesImport(file.AstFile, ImportPath) {
-				astutil.AddNamedImport(file.FileSet, file.AstFile, ImportName, ImportPath)
-			}
+			addRunnerImports(file.FileSet, file.AstFile, runner)
 
 			if file.DestinationFilePath == "" {
-				fileName := filepath.Base(file.FilePath)
-				fileNameExt := path.Ext(fileName)
-				fileName = fmt.Sprintf("%v_*_%v", strings.TrimRight(fileName, fileNameExt), fileNameExt)
-				if tmpFile, err := os.CreateTemp("", fileName); err == nil {
+				if tmpFile, err := processors.NewTempGoFile(file.FilePath); err == nil {
 					log.Printf("%s was modified.\n", file.FilePath)
-					file.DestinationFilePath = tmpFile.Name()
-					tmpFile.Close()
+					file.DestinationFilePath = tmpFile
 				}
 			}
 
-			f, err := os.Create(file.DestinationFilePath)
+			err := processors.PrintGoFile(file.DestinationFilePath, file.FileSet, file.AstFile)
 			if err == nil {
-				defer f.Close()
-				err = printer.Fprint(f, file.FileSet, file.AstFile)
-				if err == nil {
-					return true
-				} else {
-					fmt.Println(err)
-				}
+				return true
+			} else {
+				fmt.Println(err)
 			}
 		}
 	}
 	return false
 }
 
-func getStartSubTestSentence(currentImportName string, varName string) *ast.CallExpr {
-	return &ast.CallExpr{
-		Fun: &ast.SelectorExpr{
-			X:   &ast.Ident{Name: currentImportName},
-			Sel: &ast.Ident{Name: "Run"},
-		},
-		Args: []ast.Expr{
-			&ast.Ident{Name: varName},
-		},
+// rewriteSubTests rewrites the calls of subTests and the method values of file into calls to
+// runner and testrun, and reports whether file was modified
+func rewriteSubTests(file *astTestFileData, subTests []*astSubTestData, runner Runner) bool {
+	for _, subTest := range subTests {
+		newSubTestCall := getRunnerCallExpression(runner.Name, subTest.TestingType, subTest.Receiver, subTest.Call.Args)
+		subTest.Call.Fun = newSubTestCall.Fun
+		subTest.Call.Args = newSubTestCall.Args
+	}
+	if len(file.MethodValues) > 0 {
+		testingName := processors.AddImport(file.FileSet, file.AstFile, "testing")
+		astutil.Apply(file.AstFile, nil, func(c *astutil.Cursor) bool {
+			if sel, ok := c.Node().(*ast.SelectorExpr); ok {
+				if methodValue, ok := file.MethodValues[sel]; ok {
+					c.Replace(getMethodValueExpression(runner.Name, testingName, methodValue.TestingType, methodValue.Receiver))
+				}
+			}
+			return true
+		})
 	}
+	return len(subTests) > 0 || len(file.MethodValues) > 0
 }
 
-func getTestMainRunCallExpression(currentImportName string, varName string) *ast.CallExpr {
-	return &ast.CallExpr{
-		Fun: &ast.SelectorExpr{
-			X:   &ast.Ident{Name: currentImportName},
-			Sel: &ast.Ident{Name: "RunM"},
-		},
-		Args: []ast.Expr{
-			&ast.Ident{Name: varName},
+// runnerFunc is the function replacing the method of a testing type
+type runnerFunc struct {
+	// Name is the name of the function
+	Name string
+	// Runtime reports whether the function is declared by testrun rather than by the runner
+	Runtime bool
+	// RunnerArg is the function of the runner given to the testrun function, if any
+	RunnerArg string
+}
+
+// runnerFuncs maps the testing types to the function replacing their method
+var runnerFuncs = map[string]runnerFunc{
+	"T": {Name: "Run", Runtime: true, RunnerArg: "Run"},
+	"B": {Name: "RunB", Runtime: true},
+	"F": {Name: "RunFuzz", Runtime: true},
+	"M": {Name: "RunM", Runtime: true, RunnerArg: "RunM"},
+}
+
+// getRunnerCallExpression returns the call replacing the call of the method of the testing type
+// testingType on receiver with args, through the runner imported as currentImportName
+func getRunnerCallExpression(currentImportName string, testingType string, receiver ast.Expr, args []ast.Expr) *ast.CallExpr {
+	fn := runnerFuncs[testingType]
+	pkg := currentImportName
+	if fn.Runtime {
+		pkg = RuntimeName
+	}
+	call := &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: &ast.Ident{Name: pkg}, Sel: &ast.Ident{Name: fn.Name}},
+		Args: append([]ast.Expr{receiver}, args...),
+	}
+	if fn.RunnerArg != "" {
+		call.Args = append(call.Args, &ast.SelectorExpr{X: &ast.Ident{Name: currentImportName}, Sel: &ast.Ident{Name: fn.RunnerArg}})
+	}
+	return call
+}
+
+// methodValueTemplates are the function literals replacing the method values of the testing
+// types, as in `run := t.Run`. They bind the receiver when evaluated, as method values do. The
+// testing package, the runner and testrun are referred to by %[1]s, %[2]s and %[3]s
+var methodValueTemplates = map[string]string{
+	"T": `func(__dd_t *%[1]s.T) func(string, func(*%[1]s.T)) bool {
+		return func(name string, f func(*%[1]s.T)) bool { return %[3]s.Run(__dd_t, name, f, %[2]s.Run) }
+	}`,
+	"B": `func(__dd_b *%[1]s.B) func(string, func(*%[1]s.B)) bool {
+		return func(name string, f func(*%[1]s.B)) bool { return %[3]s.RunB(__dd_b, name, f) }
+	}`,
+	"F": `func(__dd_f *%[1]s.F) func(any) {
+		return func(ff any) { %[3]s.RunFuzz(__dd_f, ff) }
+	}`,
+	"M": `func(__dd_m *%[1]s.M) func() int {
+		return func() int { return %[3]s.RunM(__dd_m, %[2]s.RunM) }
+	}`,
+}
+
+// getMethodValueExpression returns the expression replacing a method value of the testing type
+// testingType on receiver, in a file importing testing as testingName
+func getMethodValueExpression(currentImportName string, testingName string, testingType string, receiver ast.Expr) ast.Expr {
+	fun, err := parser.ParseExpr(fmt.Sprintf(methodValueTemplates[testingType], testingName, currentImportName, RuntimeName))
+	if err != nil {
+		panic(err)
+	}
+	processors.ClearPositions(fun)
+	return &ast.CallExpr{Fun: fun, Args: []ast.Expr{receiver}}
+}
+
+// addRunnerImports adds the imports of runner and testrun to file, if it refers to them
+func addRunnerImports(fset *token.FileSet, file *ast.File, runner Runner) {
+	for _, pkg := range []Runner{runner, {Name: RuntimeName, ImportPath: RuntimeImportPath}} {
+		if refersTo(file, pkg.Name) {
+			astutil.AddNamedImport(fset, file, pkg.Name, pkg.ImportPath)
+		}
+	}
+}
+
+// refersTo reports whether file selects a member of the package imported as name
+func refersTo(file *ast.File, name string) bool {
+	found := false
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok && x.Name == name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// wrapBenchmarkBody moves the body of the benchmark funcDecl into a function literal run by
+// testrun: `ddtestrun.RunBenchmark(b, func(b *testing.B) {...})`
+func wrapBenchmarkBody(funcDecl *ast.FuncDecl, varName string) {
+	body := funcDecl.Body
+	funcDecl.Body = &ast.BlockStmt{
+		Lbrace: body.Lbrace,
+		List: []ast.Stmt{
+			&ast.ExprStmt{
+				X: &ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   &ast.Ident{Name: RuntimeName},
+						Sel: &ast.Ident{Name: "RunBenchmark"},
+					},
+					Args: []ast.Expr{
+						&ast.Ident{Name: varName},
+						&ast.FuncLit{
+							Type: &ast.FuncType{Func: funcDecl.Type.Func, Params: funcDecl.Type.Params},
+							Body: body,
+						},
+					},
+				},
+			},
 		},
+		Rbrace: body.Rbrace,
 	}
 }
 
-func getTestMainDeclarationSentence(currentImportName string, varName string) *ast.FuncDecl {
+// wrapExample replaces the example at index of the examples table with a call to testrun, which
+// reports it: `ddtestrun.Example(testing.InternalExample{...})`
+func wrapExample(table *ast.CompositeLit, index int, testingName string) {
+	example, ok := table.Elts[index].(*ast.CompositeLit)
+	if !ok {
+		return
+	}
+	if example.Type == nil {
+		example.Type = &ast.SelectorExpr{X: &ast.Ident{Name: testingName}, Sel: &ast.Ident{Name: "InternalExample"}}
+	}
+	table.Elts[index] = &ast.CallExpr{
+		Fun:  &ast.SelectorExpr{X: &ast.Ident{Name: RuntimeName}, Sel: &ast.Ident{Name: "Example"}},
+		Args: []ast.Expr{example},
+	}
+}
+
+// getTestMainRunCallExpression returns the call replacing `m.Run()` in _testmain.go, which runs
+// the tests through the runner imported as currentImportName: `ddtestrun.RunM(m, ddtesting.RunM)`
+func getTestMainRunCallExpression(currentImportName string, varName string) *ast.CallExpr {
+	return getRunnerCallExpression(currentImportName, "M", &ast.Ident{Name: varName}, nil)
+}
+
+// getTestMainDeclarationSentence returns the declaration of a TestMain function running the tests
+// through the runner imported as currentImportName, in a file importing testing as testingName
+func getTestMainDeclarationSentence(currentImportName string, testingName string, varName string) *ast.FuncDecl {
+	var mType ast.Expr = &ast.Ident{Name: "M"}
+	if testingName != "." {
+		mType = &ast.SelectorExpr{
+			X:   &ast.Ident{Name: testingName},
+			Sel: &ast.Ident{Name: "M"},
+		}
+	}
 	return &ast.FuncDecl{
 		Name: &ast.Ident{Name: "TestMain"},
 		Type: &ast.FuncType{
@@ -428,12 +963,7 @@ func getTestMainDeclarationSentence(currentImportName string, varName string) *a
 				List: []*ast.Field{
 					{
 						Names: []*ast.Ident{{Name: varName}},
-						Type: &ast.StarExpr{
-							X: &ast.SelectorExpr{
-								X:   &ast.Ident{Name: "testing"},
-								Sel: &ast.Ident{Name: "M"},
-							},
-						},
+						Type:  &ast.StarExpr{X: mType},
 					},
 				},
 			},
@@ -443,11 +973,12 @@ func getTestMainDeclarationSentence(currentImportName string, varName string) *a
 				&ast.ExprStmt{
 					X: &ast.CallExpr{
 						Fun: &ast.SelectorExpr{
-							X:   &ast.Ident{Name: currentImportName},
+							X:   &ast.Ident{Name: RuntimeName},
 							Sel: &ast.Ident{Name: "RunTestMain"},
 						},
 						Args: []ast.Expr{
 							&ast.Ident{Name: varName},
+							&ast.SelectorExpr{X: &ast.Ident{Name: currentImportName}, Sel: &ast.Ident{Name: "RunM"}},
 						},
 					},
 				},
@@ -0,0 +1,655 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package gotest
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/skiplist"
+	"github.com/tonyredondo/rd-toolexec/testrun"
+)
+
+// parseTestFile parses the Go file at path and type-checks it if typed is true
+func parseTestFile(t *testing.T, path string, typed bool) (*token.FileSet, *ast.File, *types.Info) {
+	t.Helper()
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !typed {
+		return fset, file, nil
+	}
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check(file.Name.Name, fset, []*ast.File{file}, info); err != nil {
+		t.Fatal(err)
+	}
+	return fset, file, info
+}
+
+// rewriteTestFile writes src to a test file, rewrites it with runner and returns the result
+func rewriteTestFile(t *testing.T, src string, runner Runner) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "foo_test.go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fset, astFile, info := parseTestFile(t, path, true)
+	data := createTestData(fset, path, astFile, info, runner)
+	data.Parent = &astTestContainer{Package: data.Package, Files: []*astTestFileData{data}}
+	if !processFile(data, runner) {
+		t.Fatal("file not modified")
+	}
+	t.Cleanup(func() { os.Remove(data.DestinationFilePath) })
+	out, err := os.ReadFile(data.DestinationFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestProcessFileRunner(t *testing.T) {
+	src := `package foo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestFoo(t *testing.T) {
+	t.Run("sub", func(t *testing.T) {})
+}
+`
+	runner := Runner{Name: "ddcustom", ImportPath: "example.com/custom"}
+	out := rewriteTestFile(t, src, runner)
+	for _, expected := range []string{
+		`ddcustom "example.com/custom"`,
+		`os.Exit(ddtestrun.RunM(m, ddcustom.RunM))`,
+		`ddtestrun.Run(t, "sub", func(t *testing.T) {}, ddcustom.Run)`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, ImportPath) {
+		t.Errorf("unexpected import of the SDK in:\n%s", out)
+	}
+}
+
+func TestProcessFileBenchmarks(t *testing.T) {
+	src := `package foo
+
+import "testing"
+
+func BenchmarkFoo(b *testing.B) {
+	b.Run("sub", func(b *testing.B) {})
+	for i := 0; i < b.N; i++ {
+	}
+}
+
+func BenchmarkHelper(n int) {}
+
+func BenchmarkUnnamed(*testing.B) {}
+`
+	out := rewriteTestFile(t, src, SDKRunner)
+	for _, expected := range []string{
+		`ddtestrun "github.com/tonyredondo/rd-toolexec/testrun"`,
+		`ddtestrun.RunBenchmark(b, func(b *testing.B) {`,
+		`ddtestrun.RunB(b, "sub", func(b *testing.B) {})`,
+		`func BenchmarkHelper(n int) {}`,
+		`func BenchmarkUnnamed(*testing.B) {}`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	}
+	// The benchmarks are only run through testrun
+	if strings.Contains(out, ImportPath) {
+		t.Errorf("unexpected import of the SDK in:\n%s", out)
+	}
+}
+
+func TestProcessFileFuzz(t *testing.T) {
+	src := `package foo
+
+import "testing"
+
+func FuzzFoo(f *testing.F) {
+	f.Add("seed")
+	f.Fuzz(func(t *testing.T, s string) {})
+}
+
+func FuzzHelper(s string) {}
+`
+	out := rewriteTestFile(t, src, SDKRunner)
+	for _, expected := range []string{
+		`f.Add("seed")`,
+		`ddtestrun.RunFuzz(f, func(t *testing.T, s string) {})`,
+		`func FuzzHelper(s string) {}`,
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	}
+}
+
+func TestCreateTestDataTestingTypes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		src  string
+	}{
+		{name: "alias", src: `package foo
+
+import tst "testing"
+
+func TestT(t *tst.T)    {}
+func BenchmarkB(b *tst.B) {}
+func FuzzF(f *tst.F)      {}
+func TestMain(m *tst.M)   {}
+func TestTB(tb tst.TB)    {}
+`},
+		{name: "dot", src: `package foo
+
+import . "testing"
+
+func TestT(t *T)        {}
+func BenchmarkB(b *B)   {}
+func FuzzF(f *F)        {}
+func TestMain(m *M)     {}
+func TestTB(tb TB)      {}
+`},
+		{name: "parenthesized", src: `package foo
+
+import "testing"
+
+func TestT(t *(testing.T))      {}
+func BenchmarkB(b *(testing.B)) {}
+func FuzzF(f *(testing.F))      {}
+func TestMain(m *(testing.M))   {}
+func TestTB(tb (testing.TB))    {}
+`},
+	} {
+		for _, typed := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/typed=%v", tc.name, typed), func(t *testing.T) {
+				if tc.name == "parenthesized" && !typed {
+					t.Skip("parenthesized types are only resolved with type information")
+				}
+				path := filepath.Join(t.TempDir(), "foo_test.go")
+				if err := os.WriteFile(path, []byte(tc.src), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				fset, astFile, info := parseTestFile(t, path, typed)
+				data := createTestData(fset, path, astFile, info, SDKRunner)
+
+				params := make(map[string]string)
+				for _, test := range data.Tests {
+					params[test.TestName] = test.TestingTAttributeName
+					if test.TestName == "TestMain" && !test.IsMain {
+						t.Error("TestMain not detected")
+					}
+				}
+				expected := map[string]string{"TestT": "t", "BenchmarkB": "b", "FuzzF": "f", "TestMain": "m", "TestTB": ""}
+				if fmt.Sprint(params) != fmt.Sprint(expected) {
+					t.Errorf("unexpected tests %v, expected %v", params, expected)
+				}
+
+				for _, decl := range astFile.Decls {
+					if fn, ok := decl.(*ast.FuncDecl); ok && fn.Name.Name == "TestTB" {
+						if kind := testingType(astFile, info, fn.Type.Params.List[0].Type); kind != "TB" {
+							t.Errorf("unexpected testing type %q for testing.TB", kind)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCreateTestDataExamples(t *testing.T) {
+	src := `package foo
+
+import "fmt"
+
+func Example() {}
+
+func ExampleFoo() {
+	fmt.Println("hi")
+	// Output: hi
+}
+
+func ExampleFoo_bar() {}
+
+func Examplefoo() {}
+
+func ExampleArgs(n int) {}
+
+func (s S) ExampleMethod() {}
+`
+	path := filepath.Join(t.TempDir(), "foo_test.go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fset, astFile, info := parseTestFile(t, path, false)
+	data := createTestData(fset, path, astFile, info, SDKRunner)
+	var examples []string
+	for _, test := range data.Tests {
+		if test.Kind == testKindExample {
+			examples = append(examples, test.TestName)
+		}
+	}
+	if expected := []string{"Example", "ExampleFoo", "ExampleFoo_bar"}; !slices.Equal(examples, expected) {
+		t.Errorf("unexpected examples %v, expected %v", examples, expected)
+	}
+}
+
+func TestProcessFileTestMainExamples(t *testing.T) {
+	src := `package main
+
+import (
+	"os"
+	"testing"
+	"testing/internal/testdeps"
+
+	_test "example.com/foo"
+)
+
+var tests = []testing.InternalTest{
+	{"TestFoo", _test.TestFoo},
+}
+
+var examples = []testing.InternalExample{
+	{"ExampleFoo", _test.ExampleFoo, "hi\n", false},
+	{"ExampleBar", _test.ExampleBar, "a\nb\n", true},
+}
+
+func main() {
+	m := testing.MainStart(testdeps.TestDeps{}, tests, nil, nil, examples)
+	os.Exit(m.Run())
+}
+`
+	path := filepath.Join(t.TempDir(), "_testmain.go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fset, astFile, info := parseTestFile(t, path, false)
+	data := createTestData(fset, path, astFile, info, SDKRunner)
+	if !processFile(data, SDKRunner) {
+		t.Fatal("file not modified")
+	}
+	t.Cleanup(func() { os.Remove(data.DestinationFilePath) })
+	out, err := os.ReadFile(data.DestinationFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`ddtestrun.Example(testing.InternalExample{"ExampleFoo", _test.ExampleFoo, "hi\n", false})`,
+		`ddtestrun.Example(testing.InternalExample{"ExampleBar", _test.ExampleBar, "a\nb\n", true})`,
+		`{"TestFoo", _test.TestFoo}`,
+		`os.Exit(ddtestrun.RunM(m, ddtesting.RunM))`,
+	} {
+		if !strings.Contains(string(out), expected) {
+			t.Errorf("%s not found in:\n%s", expected, out)
+		}
+	}
+}
+
+func TestSynthesizedTestMainAlias(t *testing.T) {
+	fset := token.NewFileSet()
+	for testingName, expected := range map[string]string{
+		"testing": "func TestMain(m *testing.M) {\n\tddtestrun.RunTestMain(m, ddtesting.RunM)\n}",
+		"tst":     "func TestMain(m *tst.M) {\n\tddtestrun.RunTestMain(m, ddtesting.RunM)\n}",
+		".":       "func TestMain(m *M) {\n\tddtestrun.RunTestMain(m, ddtesting.RunM)\n}",
+	} {
+		var out strings.Builder
+		if err := format.Node(&out, fset, getTestMainDeclarationSentence(ImportName, testingName, "m")); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expected {
+			t.Errorf("unexpected declaration for %s:\n%s", testingName, out.String())
+		}
+	}
+}
+
+func TestProcessFileSubTests(t *testing.T) {
+	src := `package foo
+
+import "testing"
+
+type wrapper struct {
+	*testing.T
+}
+
+func TestFoo(t *testing.T) {
+	t.Run("parent", func(t *testing.T) {
+		t.Run("child", func(tt *testing.T) {
+			tt.Run("grandchild", func(*testing.T) {})
+		})
+	})
+	for _, name := range []string{"a", "b"} {
+		t.Run(name, func(t *testing.T) {})
+	}
+	helper(t)
+	run := t.Run
+	run("value", func(t *testing.T) {})
+	w := wrapper{t}
+	w.Run("embedded", func(t *testing.T) {})
+}
+
+func helper(t *testing.T) {
+	t.Run("helper", func(t *testing.T) {})
+}
+
+func TestShadowed(t *testing.T) {
+	t.Run("outer", func(t *testing.T) {
+		var x struct{ Run func(string, func(*testing.T)) bool }
+		_ = x
+	})
+}
+`
+	for _, typed := range []bool{true, false} {
+		t.Run(fmt.Sprintf("typed=%v", typed), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "foo_test.go")
+			if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			fset, astFile, info := parseTestFile(t, path, typed)
+			data := createTestData(fset, path, astFile, info, SDKRunner)
+
+			names := make(map[string]string)
+			for _, test := range data.Tests {
+				for _, sub := range test.SubTests {
+					parent := test.TestName
+					if sub.Parent != nil {
+						parent = sub.Parent.TestName
+					}
+					names[sub.TestName] = parent
+				}
+			}
+			for _, sub := range data.HelperSubTests {
+				names[sub.TestName] = "helper"
+			}
+			expected := map[string]string{
+				"parent":     "TestFoo",
+				"child":      "parent",
+				"grandchild": "child",
+				"":           "TestFoo",
+				"helper":     "helper",
+				"outer":      "TestShadowed",
+			}
+			if typed {
+				// Embedded fields are only resolved with type information
+				expected["embedded"] = "TestFoo"
+			}
+			if fmt.Sprint(names) != fmt.Sprint(expected) {
+				t.Errorf("unexpected subtests %v, expected %v", names, expected)
+			}
+			if len(data.MethodValues) != 1 {
+				t.Errorf("expected 1 method value, got %d", len(data.MethodValues))
+			}
+
+			data.Parent = &astTestContainer{Package: data.Package, Files: []*astTestFileData{data}}
+			if !processFile(data, SDKRunner) {
+				t.Fatal("file not modified")
+			}
+			defer os.Remove(data.DestinationFilePath)
+			out, err := os.ReadFile(data.DestinationFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedCalls := []string{
+				`ddtestrun.Run(t, "parent"`,
+				`ddtestrun.Run(t, "child"`,
+				`ddtestrun.Run(tt, "grandchild", func(*testing.T) {}, ddtesting.Run)`,
+				`ddtestrun.Run(t, name`,
+				`ddtestrun.Run(t, "helper"`,
+				`return ddtestrun.Run(__dd_t, name, f, ddtesting.Run)`,
+			}
+			if typed {
+				expectedCalls = append(expectedCalls, `ddtestrun.Run(w.T, "embedded"`)
+			}
+			for _, expected := range expectedCalls {
+				if !strings.Contains(string(out), expected) {
+					t.Errorf("expected %q in:\n%s", expected, out)
+				}
+			}
+		})
+	}
+}
+
+// moduleRunner is the runner of the module written by writeRunnerModule
+var moduleRunner = Runner{Name: "ddrunner", ImportPath: "example.com/foo/runner"}
+
+// writeRunnerModule writes the example.com/foo module, declaring the runner example.com/foo/runner
+// which prints the names of the subtests, and requiring this module for testrun. It returns the
+// directory of the module
+func writeRunnerModule(t *testing.T) string {
+	t.Helper()
+	root, err := filepath.Abs(filepath.Join("..", "..", "..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"go.mod": fmt.Sprintf("module example.com/foo\n\ngo 1.22\n\nrequire github.com/tonyredondo/rd-toolexec v0.0.0\n\nreplace github.com/tonyredondo/rd-toolexec => %s\n", root),
+		"go.sum": string(sum),
+		"runner/runner.go": `package runner
+
+import (
+	"fmt"
+	"testing"
+)
+
+func RunM(m *testing.M) int { return m.Run() }
+
+func Run(t *testing.T, name string, f func(*testing.T)) bool {
+	return t.Run(name, func(t *testing.T) {
+		fmt.Println("run", t.Name())
+		f(t)
+	})
+}
+`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// testInstrumented rewrites src with the runner of the module written by writeRunnerModule, and
+// runs its tests with the go test arguments args and the environment env
+func testInstrumented(t *testing.T, src string, env []string, args ...string) string {
+	t.Helper()
+	dir := writeRunnerModule(t)
+	path := filepath.Join(dir, "foo_test.go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := rewriteTestFile(t, src, moduleRunner)
+	if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return goTest(t, dir, env, args...)
+}
+
+// goTest runs the tests of the package in dir with the go test arguments args and the
+// environment env
+func goTest(t *testing.T, dir string, env []string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("go", append([]string{"test", "-count=1", "-v"}, append(args, ".")...)...)
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GOWORK=off"), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, output)
+	}
+	return string(output)
+}
+
+func TestInstrumentedSubTests(t *testing.T) {
+	src := `package foo
+
+import "testing"
+
+func TestFoo(t *testing.T) {
+	run := t.Run
+	t = nil
+	run("value", func(t *testing.T) {
+		t.Run("nested", func(t *testing.T) {})
+	})
+}
+`
+	output := testInstrumented(t, src, nil)
+	for _, expected := range []string{"run TestFoo/value\n", "run TestFoo/value/nested\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in:\n%s", expected, output)
+		}
+	}
+}
+
+func TestInstrumentedBenchmarks(t *testing.T) {
+	// The benchmarks are reported by testrun.RunM, which the m.Run call of _testmain.go is
+	// rewritten into as well
+	src := `package foo
+
+import (
+	"os"
+	"testing"
+)
+
+var sink []int
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func BenchmarkFoo(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		sink = make([]int, 4)
+	}
+}
+
+func BenchmarkParent(b *testing.B) {
+	b.Run("sub", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sink = make([]int, 4)
+		}
+	})
+}
+`
+	dir := t.TempDir()
+	output := testInstrumented(t, src, []string{testrun.ReportDirEnvVar + "=" + dir}, "-run=^$", "-bench=.", "-benchtime=10x")
+	recs, err := testrun.ReadReports(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		names = append(names, rec.Name)
+		if rec.Package != "example.com/foo" || rec.Benchmark == nil || rec.Benchmark.N != 10 || rec.Benchmark.AllocsPerOp != 1 || rec.Benchmark.BytesPerOp < 32 {
+			t.Errorf("unexpected record %+v", rec)
+		}
+	}
+	slices.Sort(names)
+	if expected := []string{"BenchmarkFoo", "BenchmarkParent/sub"}; !slices.Equal(names, expected) {
+		t.Errorf("unexpected benchmarks %v, expected %v in:\n%s", names, expected, output)
+	}
+}
+
+func TestInstrumentedFuzz(t *testing.T) {
+	// The runner declares no RunFuzz, the fuzz inputs are run and reported by testrun
+	src := `package foo
+
+import "testing"
+
+func FuzzFoo(f *testing.F) {
+	f.Add("a")
+	f.Add("b")
+	fuzz := f.Fuzz
+	fuzz(func(t *testing.T, s string) {})
+}
+`
+	dir := t.TempDir()
+	output := testInstrumented(t, src, []string{testrun.ReportDirEnvVar + "=" + dir}, "-run=^FuzzFoo$")
+	recs, err := testrun.ReadReports(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(recs))
+	for _, rec := range recs {
+		names = append(names, rec.Name)
+		if rec.Kind != testrun.KindFuzz || rec.Package != "example.com/foo" || rec.Fuzz == nil || rec.Fuzz.Failed {
+			t.Errorf("unexpected record %+v", rec)
+		}
+	}
+	slices.Sort(names)
+	if expected := []string{"FuzzFoo/seed#0", "FuzzFoo/seed#1"}; !slices.Equal(names, expected) {
+		t.Errorf("unexpected inputs %v, expected %v in:\n%s", names, expected, output)
+	}
+}
+
+func TestInstrumentedSkipList(t *testing.T) {
+	// The runner knows nothing of the skip-list, the tests are skipped by testrun
+	src := `package foo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestFoo(t *testing.T) {
+	t.Run("sub", func(t *testing.T) { t.Error("not skipped") })
+	t.Run("other", func(t *testing.T) {})
+}
+
+func TestBar(t *testing.T) {
+	t.Error("not skipped")
+}
+`
+	path := filepath.Join(t.TempDir(), "skiplist.json")
+	list := `{"example.com/foo": {"TestFoo/sub": "", "TestBar": "not affected"}}`
+	if err := os.WriteFile(path, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	output := testInstrumented(t, src, []string{skiplist.FileEnvVar + "=" + path})
+	for _, expected := range []string{"--- SKIP: TestBar", "not affected", "--- SKIP: TestFoo/sub", "run TestFoo/other\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected %q in:\n%s", expected, output)
+		}
+	}
+}
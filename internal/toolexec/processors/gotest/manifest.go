@@ -0,0 +1,204 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package gotest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/alexflint/go-filemutex"
+)
+
+// SessionManifestFile is the name of the manifest aggregating the package manifests of a
+// manifest directory
+const SessionManifestFile = "manifest.json"
+
+// Manifest is the inventory of the tests of a test package, as found in its test files
+type Manifest struct {
+	// Package is the import path of the test package, ending with _test for external tests
+	Package string          `json:"package"`
+	Tests   []ManifestEntry `json:"tests"`
+}
+
+// ManifestEntry is a test, benchmark, fuzz target or example, or one of their subtests
+type ManifestEntry struct {
+	// Name is the name of the test as reported by go test, as in TestFoo/bar_baz
+	Name string `json:"name"`
+	// Kind is "test", "benchmark", "fuzz" or "example"
+	Kind string `json:"kind"`
+	File string `json:"file"`
+	Line int    `json:"line"`
+	// Parent is the name of the test starting the subtest, empty for top level tests
+	Parent string `json:"parent,omitempty"`
+}
+
+// SessionManifest aggregates the manifests of the packages compiled in a session
+type SessionManifest struct {
+	Packages []Manifest `json:"packages"`
+}
+
+var testKindNames = map[testKind]string{
+	testKindTest:      "test",
+	testKindBenchmark: "benchmark",
+	testKindFuzz:      "fuzz",
+	testKindExample:   "example",
+}
+
+// createManifest returns the manifest of the test files of the package pkg. The subtests are
+// listed when their name and the names of their parents are string literals, and the examples
+// whether or not they have an output comment. It must be called before the files are rewritten,
+// which loses the positions of the subtest calls
+func createManifest(pkg string, files []*astTestFileData) Manifest {
+	manifest := Manifest{Package: pkg, Tests: []ManifestEntry{}}
+	for _, file := range files {
+		if file.IsTestMainGoFile {
+			// The examples table of _testmain.go only repeats the examples of the test files
+			continue
+		}
+		for _, test := range file.Tests {
+			if test.IsMain || test.AstDeclaration == nil {
+				continue
+			}
+			entry := ManifestEntry{
+				Name: test.TestName,
+				Kind: testKindNames[test.Kind],
+				File: file.FilePath,
+				Line: file.FileSet.Position(test.AstDeclaration.Pos()).Line,
+			}
+			manifest.Tests = append(manifest.Tests, entry)
+
+			names := map[*astSubTestData]string{}
+			for _, subTest := range test.SubTests {
+				name := subTestFullName(test.TestName, subTest, names)
+				if name == "" {
+					continue
+				}
+				parent := test.TestName
+				if subTest.Parent != nil {
+					parent = names[subTest.Parent]
+				}
+				manifest.Tests = append(manifest.Tests, ManifestEntry{
+					Name:   name,
+					Kind:   entry.Kind,
+					File:   file.FilePath,
+					Line:   file.FileSet.Position(subTest.Call.Pos()).Line,
+					Parent: parent,
+				})
+			}
+		}
+	}
+	return manifest
+}
+
+// subTestFullName returns the name of subTest, started in the test testName, or "" when it
+// isn't known statically. names caches the names of the parents of the subtests
+func subTestFullName(testName string, subTest *astSubTestData, names map[*astSubTestData]string) string {
+	if name, ok := names[subTest]; ok {
+		return name
+	}
+	name := ""
+	if subTest.TestName != "" && (subTest.TestingType == "T" || subTest.TestingType == "B") {
+		parent := testName
+		if subTest.Parent != nil {
+			parent = subTestFullName(testName, subTest.Parent, names)
+		}
+		if parent != "" {
+			name = parent + "/" + rewriteSubTestName(subTest.TestName)
+		}
+	}
+	names[subTest] = name
+	return name
+}
+
+// rewriteSubTestName rewrites name the way the testing package does in the names of subtests:
+// spaces are replaced with underscores and non printable characters are escaped
+func rewriteSubTestName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsSpace(r):
+			b.WriteByte('_')
+		case !strconv.IsPrint(r):
+			s := strconv.QuoteRune(r)
+			b.WriteString(s[1 : len(s)-1])
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// WriteManifest writes manifest to the directory dir, in a file named after its package, and
+// replaces the entry of the package in the session manifest of dir
+func WriteManifest(dir string, manifest Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	packagesDir := filepath.Join(dir, "packages")
+	if err := os.MkdirAll(packagesDir, 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(packagesDir, url.PathEscape(manifest.Package)+".json"), data, 0o644); err != nil {
+		return err
+	}
+
+	// Test packages are compiled concurrently by separate processes
+	lock, err := filemutex.New(filepath.Join(dir, ".manifest.lock"))
+	if err != nil {
+		return err
+	}
+	if err := lock.Lock(); err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	session, err := ReadSessionManifest(dir)
+	if err != nil {
+		return err
+	}
+	idx, found := slices.BinarySearchFunc(session.Packages, manifest.Package, func(m Manifest, pkg string) int {
+		return strings.Compare(m.Package, pkg)
+	})
+	if found {
+		session.Packages[idx] = manifest
+	} else {
+		session.Packages = slices.Insert(session.Packages, idx, manifest)
+	}
+	if data, err = json.MarshalIndent(session, "", "  "); err != nil {
+		return err
+	}
+	tmpFile := filepath.Join(dir, fmt.Sprintf(".%s.%d", SessionManifestFile, os.Getpid()))
+	if err := os.WriteFile(tmpFile, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, filepath.Join(dir, SessionManifestFile))
+}
+
+// ReadSessionManifest reads the session manifest of the directory dir, which is empty when no
+// package manifest was written to dir
+func ReadSessionManifest(dir string) (SessionManifest, error) {
+	session := SessionManifest{Packages: []Manifest{}}
+	data, err := os.ReadFile(filepath.Join(dir, SessionManifestFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return session, nil
+	} else if err != nil {
+		return session, err
+	}
+	if err := json.Unmarshal(data, &session); err != nil {
+		return session, fmt.Errorf("parsing %s: %w", SessionManifestFile, err)
+	}
+	return session, nil
+}
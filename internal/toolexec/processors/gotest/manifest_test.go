@@ -0,0 +1,107 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package gotest
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCreateManifest(t *testing.T) {
+	src := `package foo
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	m.Run()
+}
+
+func TestFoo(t *testing.T) {
+	t.Run("a b", func(t *testing.T) {
+		t.Run("c", func(t *testing.T) {})
+	})
+	for _, name := range []string{"x", "y"} {
+		t.Run(name, func(t *testing.T) {
+			t.Run("z", func(t *testing.T) {})
+		})
+	}
+}
+
+func BenchmarkFoo(b *testing.B) {
+	b.Run("small", func(b *testing.B) {})
+}
+
+func FuzzFoo(f *testing.F) {
+	f.Fuzz(func(t *testing.T, s string) {})
+}
+
+func ExampleFoo() {
+	fmt.Println("foo")
+	// Output: foo
+}
+
+func ExampleBar() {}
+`
+	path := filepath.Join(t.TempDir(), "foo_test.go")
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fset, astFile, info := parseTestFile(t, path, true)
+	data := createTestData(fset, path, astFile, info, SDKRunner)
+
+	manifest := createManifest("example.com/foo", []*astTestFileData{data})
+	expected := Manifest{Package: "example.com/foo", Tests: []ManifestEntry{
+		{Name: "TestFoo", Kind: "test", File: path, Line: 12},
+		{Name: "TestFoo/a_b", Kind: "test", File: path, Line: 13, Parent: "TestFoo"},
+		{Name: "TestFoo/a_b/c", Kind: "test", File: path, Line: 14, Parent: "TestFoo/a_b"},
+		{Name: "BenchmarkFoo", Kind: "benchmark", File: path, Line: 23},
+		{Name: "BenchmarkFoo/small", Kind: "benchmark", File: path, Line: 24, Parent: "BenchmarkFoo"},
+		{Name: "FuzzFoo", Kind: "fuzz", File: path, Line: 27},
+		{Name: "ExampleFoo", Kind: "example", File: path, Line: 31},
+		{Name: "ExampleBar", Kind: "example", File: path, Line: 36},
+	}}
+	if !reflect.DeepEqual(manifest, expected) {
+		got, _ := json.MarshalIndent(manifest, "", "  ")
+		t.Errorf("unexpected manifest:\n%s", got)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	dir := t.TempDir()
+	foo := Manifest{Package: "example.com/foo", Tests: []ManifestEntry{{Name: "TestFoo", Kind: "test", File: "foo_test.go", Line: 1}}}
+	bar := Manifest{Package: "example.com/bar_test", Tests: []ManifestEntry{{Name: "TestBar", Kind: "test", File: "bar_test.go", Line: 1}}}
+	for _, manifest := range []Manifest{foo, bar, foo} {
+		if err := WriteManifest(dir, manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	session, err := ReadSessionManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (SessionManifest{Packages: []Manifest{bar, foo}}); !reflect.DeepEqual(session, expected) {
+		t.Errorf("unexpected session manifest %+v", session)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "packages", "example.com%2Fbar_test.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(manifest, bar) {
+		t.Errorf("unexpected package manifest %+v", manifest)
+	}
+}
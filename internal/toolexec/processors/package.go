@@ -7,6 +7,7 @@ package processors
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
@@ -59,10 +60,13 @@ func (r *PackageRegister) Combine(other PackageRegister) {
 }
 
 // Import imports the other package into r.
-// It effectively combines both packages and adds a dependency on r2 in r
+// It effectively combines both packages and adds a dependency on r2 in r,
+// unless r already depends on a package with the same import path
 func (r *PackageRegister) Import(other PackageRegister) {
 	r.Combine(other)
-	r.PackageFile[other.ImportPath] = fmt.Sprintf("%s/b001/_pkg_.a", other.SourceDir)
+	if _, ok := r.PackageFile[other.ImportPath]; !ok {
+		r.PackageFile[other.ImportPath] = fmt.Sprintf("%s/b001/_pkg_.a", other.SourceDir)
+	}
 }
 
 // WriteTo writes the content of the package register to the provided writer
@@ -167,6 +171,9 @@ type PackageInjector struct {
 	sourceDir          string
 	buildFlags         []string
 	requiredImportPath string
+	// Selector selects the packages the package is injected in. Link commands are not
+	// filtered, as the linker only keeps the packages that are actually imported
+	Selector PackageSelector
 }
 
 // NewPackageInjector initializes a command processor that will build the package code
@@ -191,6 +198,9 @@ func NewPackageInjectorWithRequired(importPath, sourceDir, requiredImportPath st
 // ProcessCompile visits a compile command, compiles the injected package
 // and includes the package dependency in the target package's importcfg
 func (i *PackageInjector) ProcessCompile(cmd *proxy.CompileCommand) {
+	if len(cmd.GoFiles()) == 0 || !i.Selector.Match(cmd) {
+		return
+	}
 	log.Printf("[%s] Injecting %s at compile\n", cmd.Stage(), i.importPath)
 	// 1 - Build the package
 	pkgReg, err := BuildPackage(i.importPath, i.sourceDir, i.buildFlags...)
@@ -200,6 +210,7 @@ func (i *PackageInjector) ProcessCompile(cmd *proxy.CompileCommand) {
 	}
 
 	outputFolder := filepath.Dir(cmd.Flags.Output)
+	injected := false
 
 	// 2 - Add pkg dependency in importcfg
 	log.Printf("====> Injecting %s in final importcfg [%s]\n", i.importPath, outputFolder)
@@ -212,30 +223,53 @@ func (i *PackageInjector) ProcessCompile(cmd *proxy.CompileCommand) {
 			return nil
 		}
 
-		if i.requiredImportPath != "" {
-			data, err := os.ReadFile(path)
-			if err == nil {
-				if !strings.Contains(string(data), fmt.Sprintf("packagefile %s", i.requiredImportPath)) {
-					log.Printf("Package ''%s doesn't have required import: %s", path, i.requiredImportPath)
-					return nil
-				}
-			}
-		}
-
-		file, err := os.OpenFile(path, os.O_APPEND|os.O_RDWR, 0o640)
-		if err != nil {
-			log.Printf("error opening %s: %v\n", path, err)
-			return err
-		}
-		defer file.Close()
-		str := fmt.Sprintf("packagefile %s=%s/b001/_pkg_.a", i.importPath, pkgReg.SourceDir)
-		_, err = file.WriteString(str)
+		ok, err := i.addPackageFile(path, fmt.Sprintf("%s/b001/_pkg_.a", pkgReg.SourceDir))
+		injected = injected || ok
 		return err
 	})
+	if !injected {
+		return
+	}
 
 	// 3 - Save state to disk for the link invocation (separate process)
-	utils.ExitIfError(state.SaveToFile(ddStateFilePath))
-	log.Printf("====> Saved state to %s\n", ddStateFilePath)
+	statePath := stateFilePath(cmd.WorkDir())
+	utils.ExitIfError(state.UpdateStateFile(statePath))
+	log.Printf("====> Saved state to %s\n", statePath)
+}
+
+// addPackageFile adds the packagefile line of the package, built into archive, to the importcfg
+// file at path, and reports whether it was added. It is not added when the package is already
+// listed, or when the file doesn't list the required package of i
+func (i *PackageInjector) addPackageFile(path string, archive string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("error reading %s: %v\n", path, err)
+		return false, err
+	}
+	if i.requiredImportPath != "" && !strings.Contains(string(data), fmt.Sprintf("packagefile %s=", i.requiredImportPath)) {
+		log.Printf("Package ''%s doesn't have required import: %s", path, i.requiredImportPath)
+		return false, nil
+	}
+	if strings.Contains(string(data), fmt.Sprintf("packagefile %s=", i.importPath)) {
+		log.Printf("Package ''%s already imports %s", path, i.importPath)
+		return false, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		log.Printf("error opening %s: %v\n", path, err)
+		return false, err
+	}
+	defer file.Close()
+	// Every entry is on its own line, including the last one of the file
+	line := fmt.Sprintf("packagefile %s=%s\n", i.importPath, archive)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		line = "\n" + line
+	}
+	if _, err := file.WriteString(line); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // ProcessLink visits a link command and includes all the new package dependencies
@@ -248,23 +282,42 @@ func (i *PackageInjector) ProcessLink(cmd *proxy.LinkCommand) {
 	log.Printf("[%s] Injecting %s at link\n", cmd.Stage(), i.importPath)
 
 	// 1 - Read state from disk (created by ProcessCompile step)
-	log.Printf("====> Reading state from %s\n", ddStateFilePath)
-	state, err := LoadFromFile(ddStateFilePath)
-	utils.ExitIfError(err)
+	statePath := stateFilePath(cmd.WorkDir())
+	log.Printf("====> Reading state from %s\n", statePath)
+	state, err := LoadFromFile(statePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		utils.ExitIfError(err)
+	}
 
 	// 2 - Process importcfg.link
 	log.Printf("====> Reading importcfg.link: %s [%s]\n", cmd.Flags.ImportCfg, cmd.Flags.Output)
 	file, err := os.Open(cmd.Flags.ImportCfg)
 	utils.ExitIfError(err)
-
 	reg := parseImportConfig(file)
+	file.Close()
+
+	if _, ok := state.Deps[i.importPath]; !ok {
+		// The compile commands injecting the package may have been skipped by the go build cache
+		if _, linked := reg.PackageFile[i.importPath]; !linked && referencesPackage(reg, i.importPath) {
+			log.Printf("====> %s is referenced by cached packages\n", i.importPath)
+			pkgReg, err := BuildPackage(i.importPath, i.sourceDir, i.buildFlags...)
+			utils.ExitIfError(err)
+			if state.Deps == nil {
+				state.Deps = make(map[string]PackageRegister)
+			}
+			state.Deps[i.importPath] = *pkgReg
+		}
+	}
+	if len(state.Deps) == 0 {
+		log.Printf("====> No package was injected at compile\n")
+		return
+	}
 
 	for _, r := range state.Deps {
 		reg.Import(r)
 	}
 
 	reg.ImportMap = nil
-	file.Close()
 	log.Printf("====> Injecting dependencies in importcfg.link\n")
 	file, err = os.Create(cmd.Flags.ImportCfg)
 	utils.ExitIfError(err)
@@ -272,3 +325,22 @@ func (i *PackageInjector) ProcessLink(cmd *proxy.LinkCommand) {
 	_, err = reg.WriteTo(file)
 	utils.ExitIfError(err)
 }
+
+// referencesPackage reports whether a non standard library archive of reg imports importPath,
+// meaning that it was compiled with the package injected
+func referencesPackage(reg PackageRegister, importPath string) bool {
+	for pkg, archive := range reg.PackageFile {
+		if first, _, _ := strings.Cut(pkg, "/"); !strings.Contains(first, ".") {
+			continue
+		}
+		references, err := archiveReferences(archive, importPath)
+		if err != nil {
+			log.Printf("couldn't read the imports of %s: %v\n", pkg, err)
+			continue
+		}
+		if references {
+			return true
+		}
+	}
+	return false
+}
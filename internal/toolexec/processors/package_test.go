@@ -0,0 +1,141 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferencesPackage(t *testing.T) {
+	archives := buildArchives(t, map[string]string{
+		"clock": "package clock\n",
+		"lib":   "package lib\n\nimport \"time\"\n\nvar Now = time.Now\n",
+		// The path of the package is mentioned but not imported
+		"doc": "package doc\n\nconst Clock = \"example.com/app/clock\"\n",
+		"app": "package app\n\nimport _ \"example.com/app/clock\"\n",
+	})
+
+	reg := newPackageRegister("", t.TempDir())
+	reg.PackageFile["example.com/app/lib"] = archives["lib"]
+	reg.PackageFile["example.com/app/doc"] = archives["doc"]
+	reg.PackageFile["example.com/missing"] = filepath.Join(t.TempDir(), "missing.a")
+	// Standard library archives are not read
+	reg.PackageFile["fmt"] = archives["app"]
+	require.False(t, referencesPackage(reg, "example.com/app/clock"))
+
+	reg.PackageFile["example.com/app"] = archives["app"]
+	require.True(t, referencesPackage(reg, "example.com/app/clock"))
+}
+
+func TestPackageRegisterImport(t *testing.T) {
+	reg := newPackageRegister("main", "/work/b001")
+	reg.PackageFile["fmt"] = "/cache/fmt.a"
+	reg.PackageFile["example.com/agent"] = "/cache/agent.a"
+
+	agent := newPackageRegister("example.com/agent", "/inject/agent")
+	agent.PackageFile["fmt"] = "/inject/fmt.a"
+	agent.PackageFile["example.com/agent/internal"] = "/inject/internal.a"
+	tracer := newPackageRegister("example.com/tracer", "/inject/tracer")
+
+	reg.Import(agent)
+	reg.Import(tracer)
+	require.Equal(t, map[string]string{
+		// The packages of the build are kept, the injected packages only add missing ones
+		"fmt":                        "/cache/fmt.a",
+		"example.com/agent":          "/cache/agent.a",
+		"example.com/agent/internal": "/inject/internal.a",
+		"example.com/tracer":         "/inject/tracer/b001/_pkg_.a",
+	}, reg.PackageFile)
+}
+
+func TestAddPackageFile(t *testing.T) {
+	for name, tc := range map[string]struct {
+		importcfg string
+		required  string
+		added     bool
+		expected  string
+	}{
+		"append": {
+			importcfg: "# import config\npackagefile fmt=/cache/fmt.a\n",
+			added:     true,
+			expected:  "# import config\npackagefile fmt=/cache/fmt.a\npackagefile example.com/agent=/inject/agent.a\n",
+		},
+		"no-trailing-newline": {
+			importcfg: "packagefile fmt=/cache/fmt.a",
+			added:     true,
+			expected:  "packagefile fmt=/cache/fmt.a\npackagefile example.com/agent=/inject/agent.a\n",
+		},
+		"already-imported": {
+			importcfg: "packagefile example.com/agent=/cache/agent.a\n",
+			expected:  "packagefile example.com/agent=/cache/agent.a\n",
+		},
+		"required": {
+			importcfg: "packagefile testing=/cache/testing.a\n",
+			required:  "testing",
+			added:     true,
+			expected:  "packagefile testing=/cache/testing.a\npackagefile example.com/agent=/inject/agent.a\n",
+		},
+		"required-missing": {
+			importcfg: "packagefile testing/iotest=/cache/iotest.a\n",
+			required:  "testing",
+			expected:  "packagefile testing/iotest=/cache/iotest.a\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "importcfg")
+			require.NoError(t, os.WriteFile(path, []byte(tc.importcfg), 0644))
+			injector := NewPackageInjectorWithRequired("example.com/agent", "/src/agent", tc.required)
+
+			added, err := injector.addPackageFile(path, "/inject/agent.a")
+			require.NoError(t, err)
+			require.Equal(t, tc.added, added)
+			// Injecting twice doesn't add the package again
+			added, err = injector.addPackageFile(path, "/inject/agent.a")
+			require.NoError(t, err)
+			require.False(t, added)
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, string(data))
+		})
+	}
+}
+
+// TestProcessLinkState checks that the link command of a test binary injects the packages saved
+// in the state of the build by its compile commands
+func TestProcessLinkState(t *testing.T) {
+	workDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(workDir, "b001"), 0755))
+	importCfg := filepath.Join(workDir, "b001", "importcfg.link")
+	require.NoError(t, os.WriteFile(importCfg, []byte("packagefile main=/work/b001/_pkg_.a\npackagefile fmt=/cache/fmt.a\nmodinfo \"info\"\n"), 0644))
+
+	agent := newPackageRegister("example.com/agent", "/inject/agent")
+	agent.PackageFile["example.com/agent/internal"] = "/inject/internal.a"
+	state := State{Deps: map[string]PackageRegister{"example.com/agent": agent}}
+	require.NoError(t, state.UpdateStateFile(stateFilePath(workDir)))
+
+	cmd := proxy.MustParseCommand([]string{"/path/link", "-o", filepath.Join(workDir, "b001", "pkg.test"), "-importcfg", importCfg, filepath.Join(workDir, "b001", "_pkg_.a")}).(*proxy.LinkCommand)
+	injector := NewPackageInjector("example.com/agent", "/src/agent")
+	injector.ProcessLink(cmd)
+
+	file, err := os.Open(importCfg)
+	require.NoError(t, err)
+	defer file.Close()
+	reg := parseImportConfig(file)
+	require.Equal(t, map[string]string{
+		"main":                       "/work/b001/_pkg_.a",
+		"fmt":                        "/cache/fmt.a",
+		"example.com/agent":          "/inject/agent/b001/_pkg_.a",
+		"example.com/agent/internal": "/inject/internal.a",
+	}, reg.PackageFile)
+	require.Equal(t, []string{`modinfo "info"`}, reg.RandomData)
+}
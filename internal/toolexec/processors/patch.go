@@ -0,0 +1,352 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// PatchApplier applies unified diff patches to the Go files of selected packages.
+// Patched files are written to the build work directory of the compile command
+// and swapped with the original files
+type PatchApplier struct {
+	// Key: import path pattern, as accepted by MatchImportPath
+	// Value: paths of the patches to apply to the package files
+	patches map[string][]string
+	// patterns are the keys of patches, in the order they are applied
+	patterns []string
+	// Selector selects the packages that can be patched
+	Selector PackageSelector
+}
+
+type (
+	// filePatch holds the hunks of a unified diff that apply to a single file
+	filePatch struct {
+		// Path is the path of the patched file, stripped of its a/ or b/ prefix
+		Path  string
+		Hunks []hunk
+	}
+
+	hunk struct {
+		// OldStart is the line of the original file where the hunk starts, starting at 1
+		OldStart int
+		// Lines are the hunk lines, prefixed by ' ', '-' or '+'
+		Lines []string
+	}
+)
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// NewPatchApplier initializes a command processor that applies the patches
+// to the Go files of the packages whose import path matches their key. The
+// patches of the patterns matching a package are applied in the sorted order
+// of the patterns, so that builds don't depend on the map iteration order
+func NewPatchApplier(patches map[string][]string) PatchApplier {
+	patterns := make([]string, 0, len(patches))
+	for pattern := range patches {
+		patterns = append(patterns, pattern)
+	}
+	slices.Sort(patterns)
+	return PatchApplier{
+		patches:  patches,
+		patterns: patterns,
+	}
+}
+
+// ProcessCompile visits a compile command and swaps the patched Go files in
+func (p *PatchApplier) ProcessCompile(cmd *proxy.CompileCommand) {
+	if !p.Selector.Match(cmd) {
+		return
+	}
+
+	// Patches are applied in order, the same file may be patched several times
+	patched := make(map[string][]string)
+	lineMaps := make(map[string]map[int]int)
+	for _, pattern := range p.patterns {
+		if !MatchImportPath(pattern, cmd.Flags.Package) {
+			continue
+		}
+		for _, patchFile := range p.patches[pattern] {
+			log.Printf("[%s] Applying %s to %s\n", cmd.Stage(), patchFile, cmd.Flags.Package)
+			if err := applyPatchFile(patchFile, cmd.GoFiles(), patched, lineMaps); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", cmd.Flags.Package, err)
+				os.Exit(1)
+			}
+		}
+	}
+	if len(patched) == 0 {
+		return
+	}
+
+	swapMap := make(map[string]string, len(patched))
+	outputDir := filepath.Dir(cmd.Flags.Output)
+	for file, lines := range patched {
+		dst, err := os.CreateTemp(outputDir, fmt.Sprintf("patched_*_%s", filepath.Base(file)))
+		if err == nil {
+			_, err = dst.WriteString(strings.Join(lines, "\n"))
+			dst.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: couldn't write patched %s: %v\n", cmd.Flags.Package, file, err)
+			os.Exit(1)
+		}
+		swapMap[file] = dst.Name()
+	}
+
+	swapper := NewGoFileSwapper(swapMap)
+	proxy.ProcessCommand(cmd, swapper.ProcessCompile)
+	for file, dst := range swapMap {
+		cmd.MapPath(dst, proxy.PathMapping{Original: file, Lines: lineMaps[file]})
+	}
+}
+
+// applyPatchFile applies the patch at path to the matching files of goFiles. The content of
+// patched files is read from and stored in patched, and lineMaps holds the mapping of their
+// patched lines to the original lines
+func applyPatchFile(path string, goFiles []string, patched map[string][]string, lineMaps map[string]map[int]int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	filePatches, err := parsePatch(bufio.NewScanner(f))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, fp := range filePatches {
+		target := ""
+		for _, file := range goFiles {
+			if filepath.Base(file) == filepath.Base(fp.Path) {
+				target = file
+				break
+			}
+		}
+		if target == "" {
+			log.Printf("====> %s is not part of the package, skipping\n", fp.Path)
+			continue
+		}
+
+		lines, ok := patched[target]
+		if !ok {
+			content, err := os.ReadFile(target)
+			if err != nil {
+				return err
+			}
+			lines = strings.Split(string(content), "\n")
+		}
+		newLines, lineMap, err := fp.apply(lines)
+		if err != nil {
+			return fmt.Errorf("patch %s doesn't apply to %s: %w", path, target, err)
+		}
+		patched[target] = newLines
+		lineMaps[target] = composeLineMaps(lineMap, lineMaps[target])
+	}
+
+	return nil
+}
+
+// parsePatch parses the unified diff read by scanner
+func parsePatch(scanner *bufio.Scanner) ([]filePatch, error) {
+	var (
+		patches []filePatch
+		current *filePatch
+		cur     *hunk
+		// remaining original and new lines in the current hunk
+		oldLeft, newLeft int
+	)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if cur != nil && (oldLeft > 0 || newLeft > 0) {
+			if line == "" {
+				// Some editors strip the trailing space of empty context lines
+				line = " "
+			}
+			switch line[0] {
+			case ' ':
+				oldLeft--
+				newLeft--
+			case '-':
+				oldLeft--
+			case '+':
+				newLeft--
+			case '\\':
+				// "\ No newline at end of file"
+				continue
+			default:
+				return nil, fmt.Errorf("unexpected line in hunk: %q", line)
+			}
+			cur.Lines = append(cur.Lines, line)
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			name := strings.Fields(strings.TrimPrefix(line, "+++ "))[0]
+			name = strings.TrimPrefix(strings.TrimPrefix(name, "b/"), "a/")
+			patches = append(patches, filePatch{Path: name})
+			current = &patches[len(patches)-1]
+		case strings.HasPrefix(line, "@@ "):
+			m := hunkHeader.FindStringSubmatch(line)
+			if m == nil || current == nil {
+				return nil, fmt.Errorf("unexpected hunk header: %q", line)
+			}
+			oldStart, _ := strconv.Atoi(m[1])
+			oldLeft, newLeft = 1, 1
+			if m[2] != "" {
+				oldLeft, _ = strconv.Atoi(m[2])
+			}
+			if m[4] != "" {
+				newLeft, _ = strconv.Atoi(m[4])
+			}
+			current.Hunks = append(current.Hunks, hunk{OldStart: oldStart})
+			cur = &current.Hunks[len(current.Hunks)-1]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if oldLeft > 0 || newLeft > 0 {
+		return nil, fmt.Errorf("truncated hunk")
+	}
+
+	return patches, nil
+}
+
+// apply applies the hunks of p to lines and returns the patched lines along with
+// the mapping of the patched line numbers that differ from the original ones
+func (p *filePatch) apply(lines []string) ([]string, map[int]int, error) {
+	var (
+		result  []string
+		lineMap = make(map[int]int)
+		// next original line to copy, starting at 0
+		next int
+	)
+
+	appendLine := func(line string, oldLine int) {
+		result = append(result, line)
+		if len(result) != oldLine {
+			lineMap[len(result)] = oldLine
+		}
+	}
+	copyLine := func(oldIdx int) {
+		appendLine(lines[oldIdx], oldIdx+1)
+	}
+
+	for i, h := range p.Hunks {
+		start, err := h.locate(lines, next)
+		if err != nil {
+			return nil, nil, fmt.Errorf("hunk #%d: %w", i+1, err)
+		}
+		for ; next < start; next++ {
+			copyLine(next)
+		}
+		// Added lines are reported at the line they replace, or else at the following original line
+		lastRemoved := 0
+		for _, l := range h.Lines {
+			switch l[0] {
+			case ' ':
+				copyLine(next)
+				next++
+				lastRemoved = 0
+			case '-':
+				next++
+				lastRemoved = next
+			case '+':
+				if lastRemoved > 0 {
+					appendLine(l[1:], lastRemoved)
+				} else {
+					appendLine(l[1:], min(next+1, len(lines)))
+				}
+			}
+		}
+	}
+	for ; next < len(lines); next++ {
+		copyLine(next)
+	}
+
+	return result, lineMap, nil
+}
+
+// locate returns the index of lines where the original content of h starts. The hunk is searched
+// around its expected position, starting at index from, to allow for shifted content
+func (h *hunk) locate(lines []string, from int) (int, error) {
+	var old []string
+	for _, l := range h.Lines {
+		if l[0] != '+' {
+			old = append(old, l[1:])
+		}
+	}
+
+	expected := max(h.OldStart-1, from)
+	if len(old) == 0 {
+		// Pure addition
+		return min(h.OldStart, len(lines)), nil
+	}
+	matches := func(start int) bool {
+		if start < from || start+len(old) > len(lines) {
+			return false
+		}
+		for i, l := range old {
+			if lines[start+i] != l {
+				return false
+			}
+		}
+		return true
+	}
+
+	for offset := 0; offset < len(lines); offset++ {
+		if matches(expected + offset) {
+			return expected + offset, nil
+		}
+		if matches(expected - offset) {
+			return expected - offset, nil
+		}
+	}
+
+	// Report the first mismatch at the expected position to help updating the patch
+	for i, l := range old {
+		if expected+i >= len(lines) {
+			return 0, fmt.Errorf("context mismatch at line %d: expected %q, found end of file", expected+i+1, l)
+		}
+		if lines[expected+i] != l {
+			return 0, fmt.Errorf("context mismatch at line %d: expected %q, found %q", expected+i+1, l, lines[expected+i])
+		}
+	}
+	return 0, fmt.Errorf("context mismatch at line %d", expected+1)
+}
+
+// composeLineMaps maps the lines of a file patched twice to the lines of the original file,
+// given the line map of the last patch and the line map of the previous patches
+func composeLineMaps(last, previous map[int]int) map[int]int {
+	if len(previous) == 0 {
+		return last
+	}
+	result := make(map[int]int, len(last)+len(previous))
+	for l, prev := range previous {
+		result[l] = prev
+	}
+	for l, prev := range last {
+		if orig, ok := previous[prev]; ok {
+			result[l] = orig
+		} else {
+			result[l] = prev
+		}
+	}
+	return result
+}
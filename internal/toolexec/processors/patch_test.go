@@ -0,0 +1,203 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+const original = `package bar
+
+import "fmt"
+
+func Hello() {
+	fmt.Println("hello")
+}
+
+func Bye() {
+	fmt.Println("bye")
+}`
+
+func TestApplyPatch(t *testing.T) {
+	for name, tc := range map[string]struct {
+		source   string
+		patch    string
+		expected string
+		lineMap  map[int]int
+		error    string
+	}{
+		"apply": {
+			source: original,
+			patch: `--- a/bar/bar.go
++++ b/bar/bar.go
+@@ -5,3 +5,4 @@ import "fmt"
+ func Hello() {
++	fmt.Println("patched")
+ 	fmt.Println("hello")
+ }
+`,
+			expected: strings.Replace(original, "func Hello() {\n", "func Hello() {\n\tfmt.Println(\"patched\")\n", 1),
+			lineMap:  map[int]int{7: 6, 8: 7, 9: 8, 10: 9, 11: 10, 12: 11},
+		},
+		"offset": {
+			source: "// Copyright\n\n" + original,
+			patch: `--- a/bar.go
++++ b/bar.go
+@@ -9,3 +9,3 @@ func Hello() {
+ func Bye() {
+-	fmt.Println("bye")
++	fmt.Println("goodbye")
+ }
+`,
+			expected: "// Copyright\n\n" + strings.Replace(original, `"bye"`, `"goodbye"`, 1),
+			lineMap:  map[int]int{},
+		},
+		"mismatch": {
+			source: strings.Replace(original, `"hello"`, `"hi"`, 1),
+			patch: `--- a/bar.go
++++ b/bar.go
+@@ -5,3 +5,3 @@ import "fmt"
+ func Hello() {
+-	fmt.Println("hello")
++	fmt.Println("hey")
+ }
+`,
+			error: `hunk #1: context mismatch at line 6: expected "\tfmt.Println(\"hello\")", found "\tfmt.Println(\"hi\")"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			patches, err := parsePatch(bufio.NewScanner(strings.NewReader(tc.patch)))
+			require.NoError(t, err)
+			require.Len(t, patches, 1)
+
+			lines, lineMap, err := patches[0].apply(strings.Split(tc.source, "\n"))
+			if tc.error != "" {
+				require.EqualError(t, err, tc.error)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, strings.Join(lines, "\n"))
+			require.Equal(t, tc.lineMap, lineMap)
+		})
+	}
+}
+
+// TestPatchOrder applies two patches to the same file, the second one depending on the first,
+// and checks that they are applied in the sorted order of their patterns whatever the order
+// the patterns are listed in
+func TestPatchOrder(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"bar/bar.go": original,
+		"first.patch": `--- a/bar.go
++++ b/bar.go
+@@ -5,3 +5,3 @@ import "fmt"
+ func Hello() {
+-	fmt.Println("hello")
++	fmt.Println("hi")
+ }
+`,
+		"second.patch": `--- a/bar.go
++++ b/bar.go
+@@ -5,3 +5,3 @@ import "fmt"
+ func Hello() {
+-	fmt.Println("hi")
++	fmt.Println("hey")
+ }
+`,
+	} {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	for i := 0; i < 10; i++ {
+		patcher := NewPatchApplier(map[string][]string{
+			"example.com/bar": {filepath.Join(dir, "second.patch")},
+			"example.com/...": {filepath.Join(dir, "first.patch")},
+			"example.com/baz": {filepath.Join(dir, "missing.patch")},
+		})
+		output := filepath.Join(t.TempDir(), "_pkg_.a")
+		cmd := proxy.MustParseCommand([]string{"compile", "-o", output, "-p", "example.com/bar", filepath.Join(dir, "bar/bar.go")}).(*proxy.CompileCommand)
+		patcher.ProcessCompile(cmd)
+
+		goFiles := cmd.GoFiles()
+		require.Len(t, goFiles, 1)
+		content, err := os.ReadFile(goFiles[0])
+		require.NoError(t, err)
+		require.Equal(t, strings.Replace(original, `"hello"`, `"hey"`, 1), string(content))
+	}
+}
+
+func TestComposeLineMaps(t *testing.T) {
+	// First patch adds a line after line 2, second patch adds a line after line 4
+	first := map[int]int{3: 2, 4: 3, 5: 4}
+	second := map[int]int{5: 4, 6: 5}
+	require.Equal(t, map[int]int{3: 2, 4: 3, 5: 3, 6: 4}, composeLineMaps(second, first))
+}
+
+// TestPatchDiagnostics builds a package whose patch doesn't compile through the proxy of the
+// tests, and checks that the errors refer to the original file and lines, with and without
+// -trimpath, which rewrites the positions recorded in the compiled package
+func TestPatchDiagnostics(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program through the proxy")
+	}
+	dir := t.TempDir()
+	proxyPath := filepath.Join(dir, "proxy")
+	out, err := exec.Command("go", "build", "-o", proxyPath, "../tests/proxy").CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	for path, content := range map[string]string{
+		"app/go.mod":     "module example.com/app\n\ngo 1.22\n",
+		"app/main.go":    "package main\n\nimport \"example.com/app/bar\"\n\nfunc main() { bar.Hello() }\n",
+		"app/bar/bar.go": original,
+		"bar.patch": `--- a/bar/bar.go
++++ b/bar/bar.go
+@@ -5,3 +5,4 @@ import "fmt"
+ func Hello() {
++	fmt.Println("patched")
+ 	fmt.Println("hello")
+ }
+@@ -9,3 +10,3 @@ func Hello() {
+ func Bye() {
+-	fmt.Println("bye")
++	fmt.Println(undefinedName)
+ }
+`,
+		"cfg.yaml": "patches:\n  \"example.com/app/bar\":\n    - \"" + filepath.Join(dir, "bar.patch") + "\"\n",
+	} {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	for name, flags := range map[string][]string{
+		"default":  nil,
+		"trimpath": {"-trimpath"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			args := append([]string{"build", "-o", filepath.Join(t.TempDir(), "app"), "-toolexec", proxyPath + " " + filepath.Join(dir, "cfg.yaml")}, flags...)
+			cmd := exec.Command("go", append(args, ".")...)
+			cmd.Dir = filepath.Join(dir, "app")
+			cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+			out, err := cmd.CombinedOutput()
+			require.Error(t, err)
+			// The patched line 11 stands in for the line 10 of the original file
+			require.Contains(t, string(out), "bar/bar.go:10:14: undefined: undefinedName")
+			require.NotRegexp(t, `patched_\w+\.go:\d+`, string(out))
+		})
+	}
+}
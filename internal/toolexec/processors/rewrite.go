@@ -0,0 +1,193 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// NewTempGoFile creates an empty temporary file named after the Go file at path
+// and returns the path of the new file
+func NewTempGoFile(path string) (string, error) {
+	fileName := filepath.Base(path)
+	fileNameExt := filepath.Ext(fileName)
+	fileName = fmt.Sprintf("%v_*_%v", strings.TrimSuffix(fileName, fileNameExt), fileNameExt)
+	tmpFile, err := os.CreateTemp("", fileName)
+	if err != nil {
+		return "", err
+	}
+	return tmpFile.Name(), tmpFile.Close()
+}
+
+// PrintGoFile writes the source code of file to path. Nodes coming from the original
+// source keep their positions through //line directives, so that the compiler reports
+// diagnostics against the original file and lines
+func PrintGoFile(path string, fset *token.FileSet, file *ast.File) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cfg := printer.Config{Mode: printer.UseSpaces | printer.TabIndent | printer.SourcePos, Tabwidth: 8}
+	return cfg.Fprint(f, fset, file)
+}
+
+// FileRewriter modifies the AST of a Go file and reports whether it was modified
+type FileRewriter func(fset *token.FileSet, file *ast.File) (bool, error)
+
+// TypedFileRewriter is a FileRewriter that also receives the type information of the package
+type TypedFileRewriter func(fset *token.FileSet, file *ast.File, info *types.Info) (bool, error)
+
+// RewriteGoFiles parses the Go files of cmd and applies rewrite to each of them. Modified files
+// are printed to temporary files, which are swapped with the original files and removed once
+// cmd has run. It returns whether any file was modified
+func RewriteGoFiles(cmd *proxy.CompileCommand, rewrite FileRewriter) (bool, error) {
+	return rewriteGoFiles(cmd, false, func(fset *token.FileSet, file *ast.File, _ *types.Info) (bool, error) {
+		return rewrite(fset, file)
+	})
+}
+
+// RewriteTypedGoFiles is like RewriteGoFiles, but type-checks the package with TypeCheck before
+// rewriting its files. Type errors are logged, and the rewriters get the partial information
+func RewriteTypedGoFiles(cmd *proxy.CompileCommand, rewrite TypedFileRewriter) (bool, error) {
+	return rewriteGoFiles(cmd, true, rewrite)
+}
+
+func rewriteGoFiles(cmd *proxy.CompileCommand, typeCheck bool, rewrite TypedFileRewriter) (bool, error) {
+	fset := token.NewFileSet()
+	paths := cmd.GoFiles()
+	files := make([]*ast.File, 0, len(paths))
+	for _, path := range paths {
+		file, err := parser.ParseFile(fset, path, nil, parser.ParseComments)
+		if err != nil {
+			return false, err
+		}
+		files = append(files, file)
+	}
+
+	var info *types.Info
+	if typeCheck {
+		var err error
+		if info, err = TypeCheck(cmd, fset, files); err != nil {
+			log.Printf("====> Type-checking %s: %v\n", cmd.Flags.Package, err)
+		}
+	}
+
+	swapMap := make(map[string]string)
+	for i, file := range files {
+		path := paths[i]
+		modified, err := rewrite(fset, file, info)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", path, err)
+		}
+		if !modified {
+			continue
+		}
+
+		tmpFile, err := NewTempGoFile(path)
+		if err != nil {
+			return false, err
+		}
+		if err := PrintGoFile(tmpFile, fset, file); err != nil {
+			return false, err
+		}
+		log.Printf("%s was modified.\n", path)
+		swapMap[path] = tmpFile
+	}
+	if len(swapMap) == 0 {
+		return false, nil
+	}
+
+	swapper := NewGoFileSwapper(swapMap)
+	proxy.ProcessCommand(cmd, swapper.ProcessCompile)
+	proxy.RegisterPostProcessor(cmd, func(_ *proxy.CompileCommand, _ proxy.CommandResult) error {
+		for _, tmpFile := range swapMap {
+			if err := os.Remove(tmpFile); err != nil {
+				log.Printf("couldn't remove %s: %v\n", tmpFile, err)
+			}
+		}
+		return nil
+	})
+	return true, nil
+}
+
+// ImportName returns the name under which file imports the package at importPath,
+// "." for dot imports, or "" if file doesn't import it
+func ImportName(file *ast.File, importPath string) string {
+	for _, spec := range file.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err != nil || path != importPath {
+			continue
+		}
+		if spec.Name != nil {
+			return spec.Name.Name
+		}
+		return DefaultPackageName(importPath)
+	}
+	return ""
+}
+
+// DefaultPackageName guesses the name of the package at importPath, following the
+// usual naming conventions: the last path element without its major version suffix
+func DefaultPackageName(importPath string) string {
+	elems := strings.Split(importPath, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && majorVersion.MatchString(name) {
+		name = elems[len(elems)-2]
+	}
+	name = majorVersionSuffix.ReplaceAllString(name, "")
+	name = strings.TrimPrefix(name, "go-")
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+var (
+	majorVersion       = regexp.MustCompile(`^v[0-9]+$`)
+	majorVersionSuffix = regexp.MustCompile(`\.v[0-9]+$`)
+)
+
+// AddImport makes file import the package at importPath and returns the name to refer to it.
+// Packages not imported yet are imported under a name that can't conflict with user code
+func AddImport(fset *token.FileSet, file *ast.File, importPath string) string {
+	if name := ImportName(file, importPath); name != "" && name != "." && name != "_" {
+		return name
+	}
+	name := "__dd_" + DefaultPackageName(importPath)
+	astutil.AddNamedImport(fset, file, name, importPath)
+	return name
+}
+
+// RemoveUnusedImport removes the import of importPath from file if it isn't used anymore
+func RemoveUnusedImport(fset *token.FileSet, file *ast.File, importPath string) {
+	name := ImportName(file, importPath)
+	if name == "" || name == "_" || name == "." || astutil.UsesImport(file, importPath) {
+		return
+	}
+	for _, spec := range file.Imports {
+		if path, _ := strconv.Unquote(spec.Path.Value); path == importPath {
+			if spec.Name != nil {
+				astutil.DeleteNamedImport(fset, file, spec.Name.Name, importPath)
+			} else {
+				astutil.DeleteImport(fset, file, importPath)
+			}
+			return
+		}
+	}
+}
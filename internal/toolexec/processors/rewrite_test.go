@@ -0,0 +1,63 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// typeCheckSource parses and type checks a single file package
+func typeCheckSource(t *testing.T, fset *token.FileSet, source string) (*ast.File, *types.Info) {
+	file, err := parser.ParseFile(fset, "main.go", source, parser.ParseComments)
+	require.NoError(t, err)
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	_, err = conf.Check("main", fset, []*ast.File{file}, info)
+	require.NoError(t, err)
+	return file, info
+}
+
+// runRewritten rewrites a main package source and runs it in an example.com module holding
+// the files of packages, keyed by their path in the module
+func runRewritten(t *testing.T, source string, rewrite TypedFileRewriter, packages map[string]string, env ...string) ([]byte, error) {
+	if testing.Short() {
+		t.Skip("builds and runs a program")
+	}
+	dir := t.TempDir()
+	fset := token.NewFileSet()
+	file, info := typeCheckSource(t, fset, source)
+	modified, err := rewrite(fset, file, info)
+	require.NoError(t, err)
+	require.True(t, modified)
+	require.NoError(t, PrintGoFile(filepath.Join(dir, "main.go"), fset, file))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com\n\ngo 1.21\n"), 0644))
+	for path, content := range packages {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off"), env...)
+	return cmd.CombinedOutput()
+}
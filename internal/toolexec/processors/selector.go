@@ -0,0 +1,194 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// PackageSelector selects the compile commands a processor applies to.
+// The zero value selects every package
+type PackageSelector struct {
+	// Include lists the import path patterns of the selected packages. Every package is included when empty.
+	// Patterns follow the `go list` syntax, where `...` matches any string, and additionally accept
+	// `*` and `?` wildcards that don't cross path separators
+	Include []string `yaml:"include,omitempty"`
+	// Exclude lists the import path patterns of the packages left out of the selection
+	Exclude []string `yaml:"exclude,omitempty"`
+	// Modules restricts the selection to the packages of the given module paths. The module of a
+	// package is read from the go.mod file of its source directory, or from vendor/modules.txt
+	// for vendored packages, so the packages of nested modules are not part of their parent
+	Modules []string `yaml:"modules,omitempty"`
+	// Std restricts the selection to standard library packages when true, and to
+	// non standard library packages when false
+	Std *bool `yaml:"std,omitempty"`
+	// Test restricts the selection to test packages (including the generated test main
+	// package) when true, and to non-test packages when false
+	Test *bool `yaml:"test,omitempty"`
+	// Stages restricts the selection to the given build stages (bXXX)
+	Stages []string `yaml:"stages,omitempty"`
+	// And lists selectors the packages must match as well, such as the selector shared by all
+	// the processors of a configuration
+	And []PackageSelector `yaml:"-"`
+}
+
+// Match reports whether the package compiled by cmd is selected by s
+func (s *PackageSelector) Match(cmd *proxy.CompileCommand) bool {
+	importPath := cmd.Flags.Package
+
+	if len(s.Include) > 0 && !slices.ContainsFunc(s.Include, func(p string) bool { return MatchImportPath(p, importPath) }) {
+		return false
+	}
+	if slices.ContainsFunc(s.Exclude, func(p string) bool { return MatchImportPath(p, importPath) }) {
+		return false
+	}
+	if len(s.Modules) > 0 && !inModules(cmd, s.Modules) {
+		return false
+	}
+	if s.Std != nil && *s.Std != cmd.Flags.Std {
+		return false
+	}
+	if s.Test != nil && *s.Test != IsTestCompile(cmd) {
+		return false
+	}
+	if len(s.Stages) > 0 && !slices.Contains(s.Stages, cmd.Stage()) {
+		return false
+	}
+	for i := range s.And {
+		if !s.And[i].Match(cmd) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ModulePath returns the path of the module of the package compiled by cmd, and whether it was
+// found. It is read from the go.mod file of the closest parent directory of the package sources,
+// or from the vendor/modules.txt file listing vendored packages
+func ModulePath(cmd *proxy.CompileCommand) (string, bool) {
+	for _, file := range cmd.GoFiles() {
+		if strings.HasSuffix(file, "_testmain.go") {
+			// The test main package is generated in the work directory
+			continue
+		}
+		for dir := filepath.Dir(file); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+			if filepath.Base(dir) == "vendor" {
+				return vendoredModulePath(filepath.Join(dir, "modules.txt"), cmd.Flags.Package)
+			}
+			if modulePath, ok := readModulePath(filepath.Join(dir, "go.mod")); ok {
+				return modulePath, true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// inModules reports whether the package compiled by cmd belongs to one of the modules. The
+// packages whose module isn't found, such as the test main package, belong to the modules
+// whose path is an element-wise prefix of their import path, as in example.com/foo for
+// example.com/foo/bar.test but not for example.com/foobar
+func inModules(cmd *proxy.CompileCommand, modules []string) bool {
+	if modulePath, ok := ModulePath(cmd); ok {
+		return slices.Contains(modules, modulePath)
+	}
+	importPath := strings.TrimSuffix(cmd.Flags.Package, ".test")
+	return slices.ContainsFunc(modules, func(m string) bool { return modulePathContains(m, importPath) })
+}
+
+// readModulePath returns the module path declared by the go.mod file at path, and whether the
+// file exists
+func readModulePath(path string) (string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "module" {
+			continue
+		}
+		if modulePath, err := strconv.Unquote(fields[1]); err == nil {
+			return modulePath, true
+		}
+		return fields[1], true
+	}
+	return "", true
+}
+
+// vendoredModulePath returns the path of the module providing the vendored package importPath,
+// listed in the vendor/modules.txt file at path as in:
+//
+//	# github.com/foo/bar v1.2.3
+//	## explicit; go 1.21
+//	github.com/foo/bar/baz
+func vendoredModulePath(path string, importPath string) (string, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+	var modulePath string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "## "):
+		case strings.HasPrefix(line, "# "):
+			fields := strings.Fields(line)
+			modulePath = ""
+			if len(fields) >= 2 {
+				modulePath = fields[1]
+			}
+		case line == importPath:
+			return modulePath, modulePath != ""
+		}
+	}
+	return "", false
+}
+
+// IsTestCompile reports whether cmd compiles a test package, i.e. a package with
+// `_test.go` files or the test main package generated by `go test`
+func IsTestCompile(cmd *proxy.CompileCommand) bool {
+	for _, file := range cmd.GoFiles() {
+		if strings.HasSuffix(file, "_test.go") || strings.HasSuffix(file, "_testmain.go") {
+			return true
+		}
+	}
+	return false
+}
+
+// IsTestMainCompile reports whether cmd compiles the test main package generated by `go test`,
+// which is only part of test binaries
+func IsTestMainCompile(cmd *proxy.CompileCommand) bool {
+	return slices.ContainsFunc(cmd.GoFiles(), func(file string) bool { return strings.HasSuffix(file, "_testmain.go") })
+}
+
+// MatchImportPath reports whether importPath matches pattern. Patterns follow the `go list`
+// syntax, where `...` matches any string, and additionally accept `*` and `?` wildcards
+// that don't cross path separators
+func MatchImportPath(pattern, importPath string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.ReplaceAll(expr, `\.\.\.`, `.*`)
+	expr = strings.ReplaceAll(expr, `\*`, `[^/]*`)
+	expr = strings.ReplaceAll(expr, `\?`, `[^/]`)
+	// As with `go list`, a trailing `/...` also matches the parent, so `foo/...` matches `foo`
+	if strings.HasSuffix(expr, `/.*`) {
+		expr = strings.TrimSuffix(expr, `/.*`) + `(/.*)?`
+	}
+	matched, err := regexp.MatchString("^"+expr+"$", importPath)
+	return err == nil && matched
+}
@@ -0,0 +1,159 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchImportPath(t *testing.T) {
+	for name, tc := range map[string]struct {
+		pattern    string
+		importPath string
+		match      bool
+	}{
+		"exact":             {pattern: "github.com/foo/bar", importPath: "github.com/foo/bar", match: true},
+		"exact/mismatch":    {pattern: "github.com/foo/bar", importPath: "github.com/foo/baz"},
+		"dots/self":         {pattern: "github.com/foo/...", importPath: "github.com/foo", match: true},
+		"dots/sub":          {pattern: "github.com/foo/...", importPath: "github.com/foo/bar/baz", match: true},
+		"dots/prefix":       {pattern: "github.com/foo/...", importPath: "github.com/foobar"},
+		"dots/vendor":       {pattern: ".../vendor/...", importPath: "github.com/foo/vendor/github.com/bar", match: true},
+		"dots/inner":        {pattern: "github.com/foo/.../bar", importPath: "github.com/foo/baz/bar", match: true},
+		"dots/inner/parent": {pattern: "github.com/foo/.../bar", importPath: "github.com/foo/bar"},
+		"star":              {pattern: "github.com/*/bar", importPath: "github.com/foo/bar", match: true},
+		"star/no-separator": {pattern: "github.com/*", importPath: "github.com/foo/bar"},
+		"question":          {pattern: "github.com/fo?", importPath: "github.com/foo", match: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.match, MatchImportPath(tc.pattern, tc.importPath))
+		})
+	}
+}
+
+func TestPackageSelector(t *testing.T) {
+	yes, no := true, false
+	for name, tc := range map[string]struct {
+		selector PackageSelector
+		args     []string
+		match    bool
+	}{
+		"zero": {
+			args:  []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go"},
+			match: true,
+		},
+		"include": {
+			selector: PackageSelector{Include: []string{"github.com/foo/..."}},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go"},
+			match:    true,
+		},
+		"include/mismatch": {
+			selector: PackageSelector{Include: []string{"github.com/baz/..."}},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go"},
+		},
+		"exclude": {
+			selector: PackageSelector{Include: []string{"github.com/foo/..."}, Exclude: []string{"github.com/foo/bar"}},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go"},
+		},
+		"module/testmain": {
+			selector: PackageSelector{Modules: []string{"github.com/foo"}},
+			args:     []string{"compile", "-o", "/work/b001/_pkg_.a", "-p", "github.com/foo/bar.test", "/work/b001/_testmain.go"},
+			match:    true,
+		},
+		"module/testmain/prefix": {
+			selector: PackageSelector{Modules: []string{"github.com/foo"}},
+			args:     []string{"compile", "-o", "/work/b001/_pkg_.a", "-p", "github.com/foobar.test", "/work/b001/_testmain.go"},
+		},
+		"std": {
+			selector: PackageSelector{Std: &yes},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "fmt", "-std", "/goroot/src/fmt/print.go"},
+			match:    true,
+		},
+		"std/mismatch": {
+			selector: PackageSelector{Std: &no},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "fmt", "-std", "/goroot/src/fmt/print.go"},
+		},
+		"test": {
+			selector: PackageSelector{Test: &yes},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go", "/src/bar_test.go"},
+			match:    true,
+		},
+		"test/testmain": {
+			selector: PackageSelector{Test: &yes},
+			args:     []string{"compile", "-o", "/work/b001/_pkg_.a", "-p", "main", "/work/b001/_testmain.go"},
+			match:    true,
+		},
+		"test/mismatch": {
+			selector: PackageSelector{Test: &no},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go", "/src/bar_test.go"},
+		},
+		"stage": {
+			selector: PackageSelector{Stages: []string{"b001"}},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go"},
+		},
+		"and": {
+			selector: PackageSelector{Include: []string{"github.com/foo/..."}, And: []PackageSelector{{Test: &yes}}},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go", "/src/bar_test.go"},
+			match:    true,
+		},
+		"and/mismatch": {
+			selector: PackageSelector{Include: []string{"github.com/foo/..."}, And: []PackageSelector{{Exclude: []string{"github.com/foo/bar"}}}},
+			args:     []string{"compile", "-o", "/work/b002/_pkg_.a", "-p", "github.com/foo/bar", "/src/bar.go"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cmd := proxy.MustParseCommand(tc.args).(*proxy.CompileCommand)
+			require.Equal(t, tc.match, tc.selector.Match(cmd))
+		})
+	}
+}
+
+func TestModulePath(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"foo/go.mod":                           "module github.com/foo // main module\n\ngo 1.22\n",
+		"foo/bar/bar.go":                       "package bar\n",
+		"foo/nested/go.mod":                    "module \"github.com/foo/nested\"\n",
+		"foo/nested/nested.go":                 "package nested\n",
+		"foobar/go.mod":                        "module github.com/foobar\n",
+		"foobar/foobar.go":                     "package foobar\n",
+		"foo/vendor/modules.txt":               "# github.com/baz v1.0.0\n## explicit; go 1.21\ngithub.com/baz/qux\n",
+		"foo/vendor/github.com/baz/qux/qux.go": "package qux\n",
+		"nomod/nomod.go":                       "package nomod\n",
+	} {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+
+	for name, tc := range map[string]struct {
+		importPath string
+		file       string
+		modulePath string
+		found      bool
+	}{
+		"package":   {importPath: "github.com/foo/bar", file: "foo/bar/bar.go", modulePath: "github.com/foo", found: true},
+		"nested":    {importPath: "github.com/foo/nested", file: "foo/nested/nested.go", modulePath: "github.com/foo/nested", found: true},
+		"prefix":    {importPath: "github.com/foobar", file: "foobar/foobar.go", modulePath: "github.com/foobar", found: true},
+		"vendor":    {importPath: "github.com/baz/qux", file: "foo/vendor/github.com/baz/qux/qux.go", modulePath: "github.com/baz", found: true},
+		"no-module": {importPath: "nomod", file: "nomod/nomod.go"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cmd := proxy.MustParseCommand([]string{"compile", "-o", "/work/b002/_pkg_.a", "-p", tc.importPath, filepath.Join(dir, tc.file)}).(*proxy.CompileCommand)
+			modulePath, found := ModulePath(cmd)
+			require.Equal(t, tc.found, found)
+			require.Equal(t, tc.modulePath, modulePath)
+
+			selector := PackageSelector{Modules: []string{"github.com/foo"}}
+			require.Equal(t, tc.modulePath == "github.com/foo", selector.Match(cmd))
+		})
+	}
+}
@@ -7,11 +7,20 @@ package processors
 
 import (
 	"encoding/gob"
+	"errors"
 	"os"
 	"path"
+
+	"github.com/alexflint/go-filemutex"
 )
 
-var ddStateFilePath = path.Join(os.TempDir(), ".dd_build.state")
+const ddStateFileName = ".dd_build.state"
+
+// stateFilePath returns the path of the state file of the go build invocation
+// using workDir as work directory. It is removed along with the work directory
+func stateFilePath(workDir string) string {
+	return path.Join(workDir, ddStateFileName)
+}
 
 // State represents the state of compilation of an app
 // It is used to keep track of whatever packages get built
@@ -34,6 +43,31 @@ func (s *State) SaveToFile(path string) error {
 	return enc.Encode(*s)
 }
 
+// UpdateStateFile merges the dependencies of s into the state saved at path, if any. The file is
+// locked during the update, as several commands of the same build may run concurrently
+func (s *State) UpdateStateFile(path string) error {
+	m, err := filemutex.New(path + ".lock")
+	if err != nil {
+		return err
+	}
+	if err := m.Lock(); err != nil {
+		return err
+	}
+	defer m.Unlock()
+
+	saved, err := LoadFromFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if saved.Deps == nil {
+		saved.Deps = make(map[string]PackageRegister, len(s.Deps))
+	}
+	for importPath, reg := range s.Deps {
+		saved.Deps[importPath] = reg
+	}
+	return saved.SaveToFile(path)
+}
+
 // LoadFromFile reads the file at path and deserializes its content into a State object
 func LoadFromFile(path string) (State, error) {
 	var s State
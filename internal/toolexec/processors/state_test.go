@@ -0,0 +1,67 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateFilePath(t *testing.T) {
+	require.Equal(t, "/tmp/go-build1234/.dd_build.state", stateFilePath("/tmp/go-build1234"))
+}
+
+func TestUpdateStateFile(t *testing.T) {
+	path := stateFilePath(t.TempDir())
+	_, err := LoadFromFile(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	first := State{Deps: map[string]PackageRegister{"example.com/a": newPackageRegister("example.com/a", "/work/a")}}
+	require.NoError(t, first.UpdateStateFile(path))
+	second := State{Deps: map[string]PackageRegister{
+		"example.com/a": newPackageRegister("example.com/a", "/work/a2"),
+		"example.com/b": newPackageRegister("example.com/b", "/work/b"),
+	}}
+	require.NoError(t, second.UpdateStateFile(path))
+
+	saved, err := LoadFromFile(path)
+	require.NoError(t, err)
+	require.Len(t, saved.Deps, 2)
+	// The last update of a package wins
+	require.Equal(t, "/work/a2", saved.Deps["example.com/a"].SourceDir)
+	require.Equal(t, "/work/b", saved.Deps["example.com/b"].SourceDir)
+}
+
+// TestUpdateStateFileConcurrent updates the state file like the compile commands of a build
+// running in parallel, none of the updates must be lost
+func TestUpdateStateFileConcurrent(t *testing.T) {
+	path := stateFilePath(t.TempDir())
+	const updates = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, updates)
+	for i := 0; i < updates; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			importPath := fmt.Sprintf("example.com/pkg%d", i)
+			s := State{Deps: map[string]PackageRegister{importPath: newPackageRegister(importPath, "/work")}}
+			errs <- s.UpdateStateFile(path)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	saved, err := LoadFromFile(path)
+	require.NoError(t, err)
+	require.Len(t, saved.Deps, updates)
+}
@@ -0,0 +1,83 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// TypeCheck type-checks files, the parsed Go files of the package compiled by cmd, with the export
+// data of the dependencies listed in the importcfg file of cmd. Type-checking carries on after type
+// errors, in which case the returned information is partial and the errors are returned
+func TypeCheck(cmd *proxy.CompileCommand, fset *token.FileSet, files []*ast.File) (*types.Info, error) {
+	info := &types.Info{
+		Types:      make(map[ast.Expr]types.TypeAndValue),
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+		Instances:  make(map[*ast.Ident]types.Instance),
+		Scopes:     make(map[ast.Node]*types.Scope),
+	}
+
+	cfg, err := os.Open(cmd.Flags.ImportCfg)
+	if err != nil {
+		return info, err
+	}
+	reg := parseImportConfig(cfg)
+	cfg.Close()
+
+	goarch := os.Getenv("GOARCH")
+	if goarch == "" {
+		goarch = runtime.GOARCH
+	}
+	var errs []error
+	conf := types.Config{
+		Importer:  &importCfgImporter{reg: reg, gc: importer.ForCompiler(fset, "gc", reg.lookup)},
+		GoVersion: cmd.Flags.Lang,
+		Sizes:     types.SizesFor("gc", goarch),
+		Error: func(err error) {
+			errs = append(errs, err)
+		},
+	}
+	_, _ = conf.Check(cmd.Flags.Package, fset, files, info)
+
+	return info, errors.Join(errs...)
+}
+
+// importCfgImporter imports packages from the export data listed in an importcfg file
+type importCfgImporter struct {
+	reg PackageRegister
+	gc  types.Importer
+}
+
+func (i *importCfgImporter) Import(path string) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
+	if mapped, ok := i.reg.ImportMap[path]; ok {
+		path = mapped
+	}
+	return i.gc.Import(path)
+}
+
+// lookup opens the export data of the package at importPath
+func (r *PackageRegister) lookup(importPath string) (io.ReadCloser, error) {
+	file, ok := r.PackageFile[importPath]
+	if !ok {
+		return nil, fmt.Errorf("no export data for %s", importPath)
+	}
+	return os.Open(file)
+}
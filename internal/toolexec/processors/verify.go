@@ -0,0 +1,196 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+)
+
+// VerifyPolicy tells what to do when an injected package is missing from a linked binary
+type VerifyPolicy string
+
+const (
+	// VerifyPolicyOff disables the verification
+	VerifyPolicyOff VerifyPolicy = "off"
+	// VerifyPolicyWarn prints a warning on the standard error
+	VerifyPolicyWarn VerifyPolicy = "warn"
+	// VerifyPolicyFail fails the link command
+	VerifyPolicyFail VerifyPolicy = "fail"
+)
+
+// ParseVerifyPolicy parses a policy name. An empty name yields VerifyPolicyWarn, as binaries
+// are only verified where their symbols can be read
+func ParseVerifyPolicy(name string) (VerifyPolicy, error) {
+	switch p := VerifyPolicy(strings.ToLower(name)); p {
+	case "":
+		return VerifyPolicyWarn, nil
+	case VerifyPolicyOff, VerifyPolicyWarn, VerifyPolicyFail:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown verify policy %q", name)
+	}
+}
+
+// LinkVerifier checks that injected packages actually made it into the binaries
+// produced by link commands
+type LinkVerifier struct {
+	importPaths        []string
+	requiredImportPath string
+	policy             VerifyPolicy
+}
+
+// NewLinkVerifier initializes a link post processor that looks for the given import paths
+// in the linked binary and applies policy when one of them is missing
+func NewLinkVerifier(policy VerifyPolicy, importPaths ...string) LinkVerifier {
+	return LinkVerifier{
+		importPaths: importPaths,
+		policy:      policy,
+	}
+}
+
+// NewLinkVerifierWithRequired is like NewLinkVerifier but only verifies binaries
+// whose importcfg.link holds requiredImportPath
+func NewLinkVerifierWithRequired(policy VerifyPolicy, requiredImportPath string, importPaths ...string) LinkVerifier {
+	return LinkVerifier{
+		importPaths:        importPaths,
+		requiredImportPath: requiredImportPath,
+		policy:             policy,
+	}
+}
+
+// PostProcessLink visits a link command once it has run and verifies
+// that the linked binary holds all the injected packages
+func (v *LinkVerifier) PostProcessLink(cmd *proxy.LinkCommand, res proxy.CommandResult) error {
+	if v.policy == VerifyPolicyOff || res.ExitCode != 0 || cmd.Stage() == "." {
+		return nil
+	}
+
+	if v.requiredImportPath != "" {
+		data, err := os.ReadFile(cmd.Flags.ImportCfg)
+		if err == nil && !strings.Contains(string(data), fmt.Sprintf("packagefile %s=", v.requiredImportPath)) {
+			log.Printf("[%s] Skipping verification, %s is not linked\n", cmd.Stage(), v.requiredImportPath)
+			return nil
+		}
+	}
+
+	for _, output := range res.Outputs {
+		log.Printf("[%s] Verifying injected packages in %s\n", cmd.Stage(), output)
+		missing, err := v.missingPackages(output)
+		if err == nil && len(missing) == 0 {
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("%s: injected packages missing from the linked binary: %s", output, strings.Join(missing, ", "))
+			if v.policy == VerifyPolicyFail {
+				return err
+			}
+		} else {
+			// Binaries which can't be verified are not failed, whatever the policy
+			err = fmt.Errorf("%s: couldn't verify injected packages: %w", output, err)
+		}
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+
+	return nil
+}
+
+// missingPackages returns the import paths of v that are not found in the binary at path.
+// Packages are looked up in the ELF symbol table, or in the function table of the Go runtime
+// when the binary is stripped, as go test and go run binaries are. Other binaries, such as the
+// Mach-O and PE ones, can't be verified
+func (v *LinkVerifier) missingPackages(path string) ([]string, error) {
+	symbols, err := readSymbols(path)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]bool, len(v.importPaths))
+	for _, sym := range symbols {
+		for _, importPath := range v.importPaths {
+			if !found[importPath] && symbolInPackage(sym, importPath) {
+				found[importPath] = true
+			}
+		}
+	}
+
+	var missing []string
+	for _, importPath := range v.importPaths {
+		if !found[importPath] {
+			missing = append(missing, importPath)
+		}
+	}
+	return missing, nil
+}
+
+// readSymbols returns the names of the symbols of the ELF binary at path. The symbol table is
+// dropped by the -s linker flag, in which case the functions of the .gopclntab section, which
+// the runtime needs for stack traces, are returned instead
+func readSymbols(path string) ([]string, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	syms, err := f.Symbols()
+	if errors.Is(err, elf.ErrNoSymbols) {
+		return readFuncNames(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(syms))
+	for _, sym := range syms {
+		names = append(names, sym.Name)
+	}
+	return names, nil
+}
+
+// readFuncNames returns the names of the functions of the Go runtime function table of f
+func readFuncNames(f *elf.File) ([]string, error) {
+	pclntab := f.Section(".gopclntab")
+	text := f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil, elf.ErrNoSymbols
+	}
+	data, err := pclntab.Data()
+	if err != nil {
+		return nil, err
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(data, text.Addr))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(table.Funcs))
+	for _, fn := range table.Funcs {
+		names = append(names, fn.Name)
+	}
+	return names, nil
+}
+
+// symbolInPackage reports whether the linker symbol sym belongs to the package importPath
+func symbolInPackage(sym, importPath string) bool {
+	sym = strings.TrimPrefix(sym, "type:")
+	return strings.HasPrefix(sym, importPath+".") || strings.HasPrefix(sym, symbolPrefix(importPath)+".")
+}
+
+// symbolPrefix returns the escaped form of importPath used in linker symbol names,
+// in which the dots of the last path element are escaped
+func symbolPrefix(importPath string) string {
+	i := strings.LastIndex(importPath, "/")
+	return importPath[:i+1] + strings.ReplaceAll(importPath[i+1:], ".", "%2e")
+}
+
+func modulePathContains(modulePath, importPath string) bool {
+	return modulePath != "" && (importPath == modulePath || strings.HasPrefix(importPath, modulePath+"/"))
+}
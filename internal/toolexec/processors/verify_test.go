@@ -0,0 +1,105 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package processors
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVerifyPolicy(t *testing.T) {
+	for name, expected := range map[string]VerifyPolicy{
+		"":     VerifyPolicyWarn,
+		"off":  VerifyPolicyOff,
+		"WARN": VerifyPolicyWarn,
+		"fail": VerifyPolicyFail,
+	} {
+		policy, err := ParseVerifyPolicy(name)
+		require.NoError(t, err)
+		require.Equal(t, expected, policy)
+	}
+	_, err := ParseVerifyPolicy("loud")
+	require.Error(t, err)
+}
+
+// buildVerifyBinary builds a program importing example.com/app/lib.v2 with ldflags and returns
+// the path of the binary
+func buildVerifyBinary(t *testing.T, ldflags string) string {
+	if runtime.GOOS != "linux" {
+		t.Skip("the verifier reads ELF binaries")
+	}
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"go.mod":        "module example.com/app\n\ngo 1.22\n",
+		"main.go":       "package main\n\nimport \"example.com/app/lib.v2\"\n\nfunc main() { lib.Hello() }\n",
+		"lib.v2/lib.go": "package lib\n\nimport \"fmt\"\n\n//go:noinline\nfunc Hello() { fmt.Println(\"hello\") }\n",
+	} {
+		path = filepath.Join(dir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	output := filepath.Join(dir, "app")
+	cmd := exec.Command("go", "build", "-ldflags="+ldflags, "-o", output, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return output
+}
+
+func TestLinkVerifierMissingPackages(t *testing.T) {
+	for name, ldflags := range map[string]string{
+		// The packages are found in the symbol table
+		"symbols": "",
+		// go test links with -s, the packages are found in the function table of the runtime
+		"stripped": "-s -w",
+	} {
+		t.Run(name, func(t *testing.T) {
+			binary := buildVerifyBinary(t, ldflags)
+			verifier := NewLinkVerifier(VerifyPolicyFail, "fmt", "example.com/app/lib.v2", "example.com/missing")
+			missing, err := verifier.missingPackages(binary)
+			require.NoError(t, err)
+			require.Equal(t, []string{"example.com/missing"}, missing)
+		})
+	}
+}
+
+func TestLinkVerifierPolicy(t *testing.T) {
+	binary := buildVerifyBinary(t, "-s -w")
+	cmd, err := proxy.ParseCommand([]string{"/path/link", "-o", binary, "-importcfg", "/buildDir/b001/importcfg.link", "/buildDir/b001/_pkg_.a"})
+	require.NoError(t, err)
+	res := proxy.CommandResult{Outputs: []string{binary}}
+
+	for policy, fails := range map[VerifyPolicy]bool{
+		VerifyPolicyFail: true,
+		VerifyPolicyWarn: false,
+		VerifyPolicyOff:  false,
+	} {
+		verifier := NewLinkVerifier(policy, "example.com/missing")
+		err := verifier.PostProcessLink(cmd.(*proxy.LinkCommand), res)
+		require.Equal(t, fails, err != nil, "policy %s: %v", policy, err)
+	}
+
+	verifier := NewLinkVerifier(VerifyPolicyFail, "example.com/app/lib.v2")
+	require.NoError(t, verifier.PostProcessLink(cmd.(*proxy.LinkCommand), res))
+}
+
+func TestLinkVerifierUnreadableBinary(t *testing.T) {
+	// Binaries whose symbols can't be read, as the Mach-O and PE ones, are not failed
+	binary := filepath.Join(t.TempDir(), "app")
+	require.NoError(t, os.WriteFile(binary, []byte("not an ELF binary"), 0755))
+	cmd, err := proxy.ParseCommand([]string{"/path/link", "-o", binary, "-importcfg", "/buildDir/b001/importcfg.link", "/buildDir/b001/_pkg_.a"})
+	require.NoError(t, err)
+	verifier := NewLinkVerifier(VerifyPolicyFail, "testing")
+	require.NoError(t, verifier.PostProcessLink(cmd.(*proxy.LinkCommand), proxy.CommandResult{Outputs: []string{binary}}))
+}
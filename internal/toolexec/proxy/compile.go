@@ -17,6 +17,8 @@ type compileFlagSet struct {
 	ImportCfg string `ddflag:"-importcfg"`
 	Output    string `ddflag:"-o"`
 	TrimPath  string `ddflag:"-trimpath"`
+	Std       bool   `ddflag:"-std"`
+	Lang      string `ddflag:"-lang"`
 }
 
 // CompileCommand represents a go tool `compile` invocation
@@ -44,10 +46,9 @@ func (cmd *CompileCommand) GoFiles() []string {
 // AddFiles adds the provided go files paths to the list of Go files passed
 // as arguments to cmd
 func (cmd *CompileCommand) AddFiles(files []string) {
-	paramIdx := len(cmd.paramPos)
-
-	for i, f := range files {
-		cmd.paramPos[f] = paramIdx + i
+	for _, f := range files {
+		cmd.paramPos[f] = len(cmd.args)
+		cmd.args = append(cmd.args, f)
 	}
 }
 
@@ -47,3 +47,14 @@ func TestParseCompile(t *testing.T) {
 		})
 	}
 }
+
+func TestAddFiles(t *testing.T) {
+	cmd, err := parseCompileCommand([]string{"/path/compile", "-o", "/buildDir/b002/a.out", "-p", "mypackage", "/buildDir/b002/main.go"})
+	require.NoError(t, err)
+	c := cmd.(*CompileCommand)
+	c.AddFiles([]string{"/tmp/added1.go", "/tmp/added2.go"})
+	require.Equal(t, []string{"/buildDir/b002/main.go", "/tmp/added1.go", "/tmp/added2.go"}, c.GoFiles())
+	// Added files can be replaced like any other parameter
+	require.NoError(t, c.ReplaceParam("/tmp/added1.go", "/tmp/replaced.go"))
+	require.Equal(t, []string{"/buildDir/b002/main.go", "/tmp/replaced.go", "/tmp/added2.go"}, c.GoFiles())
+}
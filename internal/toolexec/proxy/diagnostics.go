@@ -0,0 +1,49 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// PathMapping relates a file passed to a command in place of another one
+// to the original file it stands in for
+type PathMapping struct {
+	// Original is the path of the file the substitute stands in for
+	Original string
+	// Lines maps line numbers of the substitute file to line numbers of the original file.
+	// Lines missing from the map are reported unchanged
+	Lines map[int]int
+}
+
+// diagnosticPosition matches the `path:line[:col]` positions printed by the Go tools
+var diagnosticPosition = regexp.MustCompile(`([^\s:]+\.go):(\d+)(:\d+)?`)
+
+// MapDiagnostics rewrites every position in output that belongs to a substitute file
+// of mappings so that it refers to the original file (and line) instead
+func MapDiagnostics(output []byte, mappings map[string]PathMapping) []byte {
+	if len(mappings) == 0 {
+		return output
+	}
+
+	return diagnosticPosition.ReplaceAllFunc(output, func(pos []byte) []byte {
+		m := diagnosticPosition.FindSubmatch(pos)
+		mapping, ok := mappings[string(m[1])]
+		if !ok {
+			return pos
+		}
+		line, err := strconv.Atoi(string(m[2]))
+		if err != nil {
+			return pos
+		}
+		if l, ok := mapping.Lines[line]; ok {
+			line = l
+		}
+		return []byte(fmt.Sprintf("%s:%d%s", mapping.Original, line, m[3]))
+	})
+}
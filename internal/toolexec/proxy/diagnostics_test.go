@@ -0,0 +1,54 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package proxy_test
+
+import (
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapDiagnostics(t *testing.T) {
+	mappings := map[string]proxy.PathMapping{
+		"/tmp/lib_123_.go": {Original: "/src/lib/lib.go"},
+		"/tmp/patched.go":  {Original: "/src/lib/other.go", Lines: map[int]int{12: 10}},
+	}
+
+	for name, tc := range map[string]struct {
+		output   string
+		expected string
+	}{
+		"unmapped": {
+			output:   "/src/lib/main.go:3:2: undefined: x\n",
+			expected: "/src/lib/main.go:3:2: undefined: x\n",
+		},
+		"path": {
+			output:   "/tmp/lib_123_.go:3:2: undefined: x\n",
+			expected: "/src/lib/lib.go:3:2: undefined: x\n",
+		},
+		"path-no-column": {
+			output:   "/tmp/lib_123_.go:3: undefined: x\n",
+			expected: "/src/lib/lib.go:3: undefined: x\n",
+		},
+		"lines": {
+			output:   "/tmp/patched.go:12:5: x redeclared\n\t/tmp/patched.go:4:5: other declaration of x\n",
+			expected: "/src/lib/other.go:10:5: x redeclared\n\t/src/lib/other.go:4:5: other declaration of x\n",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, tc.expected, string(proxy.MapDiagnostics([]byte(tc.output), mappings)))
+		})
+	}
+}
+
+func TestMapPath(t *testing.T) {
+	cmd := proxy.MustParseCommand([]string{"compile", "-o", "b002/a.out", "main.go"})
+	require.Empty(t, cmd.PathMappings())
+	cmd.MapPath("/tmp/main.go", proxy.PathMapping{Original: "main.go"})
+	require.Equal(t, map[string]proxy.PathMapping{"/tmp/main.go": {Original: "main.go"}}, cmd.PathMappings())
+}
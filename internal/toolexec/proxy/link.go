@@ -8,6 +8,7 @@ package proxy
 import (
 	"errors"
 	"path/filepath"
+	"regexp"
 )
 
 type linkFlagSet struct {
@@ -27,7 +28,24 @@ func (cmd *LinkCommand) Type() CommandType {
 }
 
 func (cmd *LinkCommand) Stage() string {
-	return filepath.Base(filepath.Dir(filepath.Dir(cmd.Flags.Output)))
+	return filepath.Base(cmd.stageDir())
+}
+
+func (cmd *LinkCommand) WorkDir() string {
+	return filepath.Dir(cmd.stageDir())
+}
+
+var stageDirName = regexp.MustCompile(`^b[0-9]+$`)
+
+// stageDir returns the stage directory holding the output of cmd. `go build` links
+// to $WORK/b001/exe/a.out while `go test` links to $WORK/b001/pkg.test
+func (cmd *LinkCommand) stageDir() string {
+	for dir := filepath.Dir(cmd.Flags.Output); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if stageDirName.MatchString(filepath.Base(dir)) {
+			return dir
+		}
+	}
+	return filepath.Dir(filepath.Dir(cmd.Flags.Output))
 }
 
 func parseLinkCommand(args []string) (Command, error) {
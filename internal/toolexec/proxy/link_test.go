@@ -6,6 +6,7 @@
 package proxy
 
 import (
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -31,14 +32,49 @@ func TestParseLink(t *testing.T) {
 				BuildMode: "exe",
 			},
 		},
+		"test": {
+			input: []string{"/path/link", "-o", "/buildDir/b001/pkg.test", "-importcfg", "/buildDir/b001/importcfg.link", "-buildmode=exe", "/buildDir/b001/_pkg_.a"},
+			stage: "b001",
+			flags: linkFlagSet{
+				ImportCfg: "/buildDir/b001/importcfg.link",
+				Output:    "/buildDir/b001/pkg.test",
+				BuildMode: "exe",
+			},
+		},
 	} {
 		t.Run(name, func(t *testing.T) {
 			cmd, err := parseLinkCommand(tc.input)
 			require.NoError(t, err)
 			require.Equal(t, CommandTypeLink, cmd.Type())
 			require.Equal(t, tc.stage, cmd.Stage())
+			if tc.stage != "." {
+				require.Equal(t, "/buildDir", cmd.WorkDir())
+			}
 			c := cmd.(*LinkCommand)
 			require.True(t, reflect.DeepEqual(tc.flags, c.Flags))
 		})
 	}
 }
+
+// TestLinkWorkDir checks that the link commands of the go commands resolve the work directory
+// the compile commands of the same build share, where the state of the injected packages is kept
+func TestLinkWorkDir(t *testing.T) {
+	const workDir = "/tmp/go-build1234"
+	compile, err := ParseCommand([]string{"/path/compile", "-o", workDir + "/b002/_pkg_.a", "-p", "main", "main.go"})
+	require.NoError(t, err)
+	require.Equal(t, workDir, compile.WorkDir())
+
+	for name, output := range map[string]string{
+		"go build":               workDir + "/b001/exe/a.out",
+		"go run":                 workDir + "/b001/exe/main",
+		"go test":                workDir + "/b001/pkg.test",
+		"go test, other package": workDir + "/b042/pkg.test",
+	} {
+		t.Run(name, func(t *testing.T) {
+			cmd, err := ParseCommand([]string{"/path/link", "-o", output, "-importcfg", filepath.Dir(output) + "/importcfg.link", workDir + "/b001/_pkg_.a"})
+			require.NoError(t, err)
+			require.Equal(t, compile.WorkDir(), cmd.WorkDir())
+			require.Regexp(t, `^b\d+$`, cmd.Stage())
+		})
+	}
+}
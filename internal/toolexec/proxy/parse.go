@@ -27,7 +27,8 @@ func ParseCommand(args []string) (Command, error) {
 		return parseLinkCommand(args)
 	// We currently don't need to inject other tool calls, so we parse them as generic unsupported commands
 	default:
-		return &command{args: args}, nil
+		cmd := NewCommand(args)
+		return &cmd, nil
 	}
 }
 
@@ -6,11 +6,13 @@
 package proxy
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"time"
 )
 
 type (
@@ -22,18 +24,49 @@ type (
 		// Args are all the command arguments, starting from the Go tool command
 		Args() []string
 		ReplaceParam(param string, val string) error
+		// RemoveParam removes a parameter from the command arguments
+		RemoveParam(param string) error
 		// Stage returns the build stage of the command. Each stage usually associated
 		// to a specific package and is named using the `bXXX` format, where `X` are numbers.
 		// Stage b001 is the final stage of the go build process
 		Stage() string
+		// WorkDir returns the temporary work directory of the go build invocation running
+		// the command ($WORK), which holds the stage directories
+		WorkDir() string
 		// Type represents the go tool command type (compile, link, asm, etc.)
 		Type() CommandType
+		// MapPath registers substitute as a file standing in for m.Original, so that
+		// diagnostics reported by the tool against substitute are reported against the original file
+		MapPath(substitute string, m PathMapping)
+		// PathMappings returns the path mappings registered with MapPath, keyed by substitute file
+		PathMappings() map[string]PathMapping
+
+		base() *command
 	}
 
 	// CommandProcessor is a function that takes a command as input
 	// and is allowed to modify it or read its data
 	CommandProcessor[T Command] func(T)
 
+	// PostProcessor is a function called with a command and the result of its
+	// execution, once the tool has run. Returning an error fails the command
+	PostProcessor[T Command] func(T, CommandResult) error
+
+	// CommandResult describes the execution of a command
+	CommandResult struct {
+		// ExitCode is the exit status of the tool, or -1 if it couldn't run
+		ExitCode int
+		// Duration is the time spent running the tool
+		Duration time.Duration
+		// Outputs are the paths of the files the tool was asked to write
+		Outputs []string
+	}
+
+	// postProcessError is returned by RunCommand when a post processor fails
+	postProcessError struct {
+		err error
+	}
+
 	commandFlagSet struct {
 		Output string `ddflag:"-o"`
 	}
@@ -45,6 +78,10 @@ type (
 		// paramPos is the index in args of the *value* provided for the parameter stored in the key
 		paramPos map[string]int
 		flags    commandFlagSet
+		// pathMappings maps substitute files to the files they stand in for
+		pathMappings map[string]PathMapping
+		// postProcessors are run in registration order once the command has run
+		postProcessors []func(CommandResult) error
 	}
 )
 
@@ -63,6 +100,20 @@ func ProcessCommand[T Command](cmd Command, p CommandProcessor[T]) {
 	}
 }
 
+// RegisterPostProcessor registers a processor to be called once cmd has been executed
+// by RunCommand, if said command matches the input type of the processor. Failure to
+// match types is not considered to be an error.
+func RegisterPostProcessor[T Command](cmd Command, p PostProcessor[T]) {
+	c, ok := cmd.(T)
+	if !ok {
+		return
+	}
+	b := cmd.base()
+	b.postProcessors = append(b.postProcessors, func(res CommandResult) error {
+		return p(c, res)
+	})
+}
+
 // NewCommand initializes a new command object and takes care of tracking the indexes of its
 // arguments
 func NewCommand(args []string) command {
@@ -92,7 +143,37 @@ func (cmd *command) ReplaceParam(param string, val string) error {
 	return nil
 }
 
-// RunCommand executes the underlying go tool command and forwards the program's standard fluxes
+// RemoveParam will remove any parameter of the command provided it is found
+func (cmd *command) RemoveParam(param string) error {
+	i, ok := cmd.paramPos[param]
+	if !ok {
+		return fmt.Errorf("%s not found", param)
+	}
+	cmd.args = append(cmd.args[:i], cmd.args[i+1:]...)
+	delete(cmd.paramPos, param)
+	for p, pos := range cmd.paramPos {
+		if pos > i {
+			cmd.paramPos[p] = pos - 1
+		}
+	}
+	return nil
+}
+
+// MapPath registers substitute as a file standing in for m.Original
+func (cmd *command) MapPath(substitute string, m PathMapping) {
+	if cmd.pathMappings == nil {
+		cmd.pathMappings = make(map[string]PathMapping)
+	}
+	cmd.pathMappings[substitute] = m
+}
+
+func (cmd *command) PathMappings() map[string]PathMapping {
+	return cmd.pathMappings
+}
+
+// RunCommand executes the underlying go tool command and forwards the program's standard fluxes.
+// The output of compile commands using substitute files is rewritten so that diagnostics
+// refer to the original files
 func RunCommand(cmd Command) error {
 	args := cmd.Args()
 	c := exec.Command(args[0], args[1:]...)
@@ -104,7 +185,40 @@ func RunCommand(cmd Command) error {
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
 
-	return c.Run()
+	var stdout, stderr bytes.Buffer
+	mappings := cmd.PathMappings()
+	mapOutput := cmd.Type() == CommandTypeCompile && len(mappings) > 0
+	if mapOutput {
+		c.Stdout = &stdout
+		c.Stderr = &stderr
+	}
+
+	start := time.Now()
+	err := c.Run()
+	res := CommandResult{
+		ExitCode: c.ProcessState.ExitCode(),
+		Duration: time.Since(start),
+	}
+	if output := cmd.base().flags.Output; output != "" {
+		res.Outputs = []string{output}
+	}
+
+	if mapOutput {
+		_, _ = os.Stdout.Write(MapDiagnostics(stdout.Bytes(), mappings))
+		_, _ = os.Stderr.Write(MapDiagnostics(stderr.Bytes(), mappings))
+	}
+
+	var errs []error
+	for _, p := range cmd.base().postProcessors {
+		if pErr := p(res); pErr != nil {
+			errs = append(errs, pErr)
+		}
+	}
+	if err == nil && len(errs) > 0 {
+		err = &postProcessError{err: errors.Join(errs...)}
+	}
+
+	return err
 }
 
 // MustRunCommand is like RunCommand but panics if the command fails to build or run
@@ -117,13 +231,30 @@ func MustRunCommand(cmd Command) {
 	if errors.As(err, &exitErr) {
 		os.Exit(exitErr.ExitCode())
 	}
+	var postErr *postProcessError
+	if errors.As(err, &postErr) {
+		fmt.Fprintln(os.Stderr, postErr)
+		os.Exit(1)
+	}
 	panic(err)
 }
 
+func (e *postProcessError) Error() string {
+	return e.err.Error()
+}
+
+func (e *postProcessError) Unwrap() error {
+	return e.err
+}
+
 func (cmd *command) Stage() string {
 	return filepath.Base(filepath.Dir(cmd.flags.Output))
 }
 
+func (cmd *command) WorkDir() string {
+	return filepath.Dir(filepath.Dir(cmd.flags.Output))
+}
+
 func (cmd *command) Type() CommandType {
 	return CommandTypeOther
 }
@@ -131,3 +262,7 @@ func (cmd *command) Type() CommandType {
 func (cmd *command) Args() []string {
 	return cmd.args
 }
+
+func (cmd *command) base() *command {
+	return cmd
+}
@@ -6,6 +6,7 @@
 package proxy_test
 
 import (
+	"errors"
 	"reflect"
 	"testing"
 
@@ -49,26 +50,41 @@ func TestReplaceParam(t *testing.T) {
 	}
 }
 
+func TestRemoveParam(t *testing.T) {
+	cmd := proxy.NewCommand([]string{"compile", "-o", "a.out", "-dd_flag", "a.go"})
+	require.NoError(t, cmd.RemoveParam("-dd_flag"))
+	require.Equal(t, []string{"compile", "-o", "a.out", "a.go"}, cmd.Args())
+	require.Error(t, cmd.RemoveParam("-dd_flag"))
+
+	// Parameters after the removed one can still be replaced
+	require.NoError(t, cmd.ReplaceParam("a.go", "b.go"))
+	require.Equal(t, []string{"compile", "-o", "a.out", "b.go"}, cmd.Args())
+}
+
 func TestParseCommand(t *testing.T) {
 	for name, tc := range map[string]struct {
-		input         []string
-		expectedType  proxy.CommandType
-		expectedStage string
+		input           []string
+		expectedType    proxy.CommandType
+		expectedStage   string
+		expectedWorkDir string
 	}{
 		"unknown": {
-			input:         []string{"unknown", "irrelevant"},
-			expectedType:  proxy.CommandTypeOther,
-			expectedStage: ".",
+			input:           []string{"unknown", "irrelevant"},
+			expectedType:    proxy.CommandTypeOther,
+			expectedStage:   ".",
+			expectedWorkDir: ".",
 		},
 		"compile": {
-			input:         []string{"compile", "-o", "b002/a.out", "main.go"},
-			expectedType:  proxy.CommandTypeCompile,
-			expectedStage: "b002",
+			input:           []string{"compile", "-o", "/work/b002/a.out", "main.go"},
+			expectedType:    proxy.CommandTypeCompile,
+			expectedStage:   "b002",
+			expectedWorkDir: "/work",
 		},
 		"link": {
-			input:         []string{"link", "-o", "b001/out/a.out", "main.go"},
-			expectedType:  proxy.CommandTypeLink,
-			expectedStage: "b001",
+			input:           []string{"link", "-o", "/work/b001/out/a.out", "main.go"},
+			expectedType:    proxy.CommandTypeLink,
+			expectedStage:   "b001",
+			expectedWorkDir: "/work",
 		},
 	} {
 
@@ -76,7 +92,54 @@ func TestParseCommand(t *testing.T) {
 			cmd := proxy.MustParseCommand(tc.input)
 			require.Equal(t, tc.expectedType, cmd.Type())
 			require.Equal(t, tc.expectedStage, cmd.Stage())
+			require.Equal(t, tc.expectedWorkDir, cmd.WorkDir())
 			require.True(t, reflect.DeepEqual(tc.input, cmd.Args()))
 		})
 	}
 }
+
+func TestPostProcessor(t *testing.T) {
+	for name, tc := range map[string]struct {
+		input    []string
+		hookErr  error
+		exitCode int
+		error    bool
+	}{
+		"success": {
+			input: []string{"true", "-o", "b002/a.out"},
+		},
+		"tool-failure": {
+			input:    []string{"false", "-o", "b002/a.out"},
+			exitCode: 1,
+			error:    true,
+		},
+		"hook-failure": {
+			input:   []string{"true", "-o", "b002/a.out"},
+			hookErr: errors.New("hook failure"),
+			error:   true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cmd := proxy.MustParseCommand(tc.input)
+			var res *proxy.CommandResult
+			proxy.RegisterPostProcessor(cmd, func(c proxy.Command, r proxy.CommandResult) error {
+				require.Equal(t, cmd, c)
+				res = &r
+				return tc.hookErr
+			})
+			// Hooks for other command types are not registered
+			proxy.RegisterPostProcessor(cmd, func(*proxy.LinkCommand, proxy.CommandResult) error {
+				return errors.New("unexpected call")
+			})
+
+			err := proxy.RunCommand(cmd)
+			require.Equal(t, tc.error, err != nil)
+			if tc.hookErr != nil {
+				require.ErrorIs(t, err, tc.hookErr)
+			}
+			require.NotNil(t, res)
+			require.Equal(t, tc.exitCode, res.ExitCode)
+			require.Equal(t, []string{"b002/a.out"}, res.Outputs)
+		})
+	}
+}
@@ -0,0 +1,30 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package gotest
+
+import "testing"
+
+var flakyAttempts, parentAttempts int
+
+// TestFlaky passes on its second attempt
+func TestFlaky(t *testing.T) {
+	flakyAttempts++
+	if flakyAttempts == 1 {
+		t.Error("first attempt")
+	}
+}
+
+// TestParent passes on its second attempt, as its subtest
+func TestParent(t *testing.T) {
+	parentAttempts++
+	t.Run("sub", func(t *testing.T) {
+		if parentAttempts == 1 {
+			t.Error("first attempt")
+		}
+	})
+}
+
+func TestPass(t *testing.T) {}
@@ -0,0 +1,18 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package autoinstrument is a stub of the testing SDK, declaring the functions of the runner
+// only. The end-to-end tests inject it with DD_TOOLEXEC_SDK, in place of the SDK.
+package autoinstrument
+
+import "testing"
+
+func RunM(m *testing.M) int {
+	return m.Run()
+}
+
+func Run(t *testing.T, name string, f func(*testing.T)) bool {
+	return t.Run(name, f)
+}
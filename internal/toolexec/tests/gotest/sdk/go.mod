@@ -0,0 +1,3 @@
+module github.com/DataDog/dd-sdk-go-testing
+
+go 1.22
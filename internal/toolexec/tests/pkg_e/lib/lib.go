@@ -0,0 +1,18 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// This file is only built in place of base/lib/lib.go, along with the generated file
+//go:build ignore
+
+package lib
+
+import (
+	"fmt"
+)
+
+func Print() {
+	// generatedName is declared by the file generated from name.go.tmpl
+	fmt.Println(generatedName)
+}
@@ -0,0 +1,17 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package agent
+
+import (
+	"fmt"
+	"os"
+)
+
+func init() {
+	// Blank imported packages are initialized before the main package
+	fmt.Println("pkg_f")
+	os.Exit(0)
+}
@@ -0,0 +1,16 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package hook
+
+import "fmt"
+
+// Enter is called at the start of the instrumented functions and skips their body
+func Enter(name string) bool {
+	if name == "lib.Print" {
+		fmt.Println("pkg_h")
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package controller
+
+import (
+	"errors"
+	"os"
+)
+
+// Inject makes every wrapped call fail after reporting it
+func Inject(site string) error {
+	if site == "fmt.Println" {
+		os.Stdout.WriteString("pkg_i\n")
+	}
+	return errors.New("injected")
+}
+
+// Delay doesn't delay the wrapped calls
+func Delay(string) {}
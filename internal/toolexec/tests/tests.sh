@@ -17,5 +17,27 @@ do
     rm -f main
 done
 
+# Run the tests of the gotest module through the default runner, a stub of the testing SDK, and
+# make sure the retried tests are reported
+go build -o rd-toolexec ../../..
+reportDir=$(realpath $(mktemp -d report-XXXXX))
+(cd gotest && GOCACHE=$cacheDir GOWORK=off DD_TOOLEXEC_SDK=$testDir/gotest/sdk DD_RETRY_COUNT=1 DD_TESTRUN_REPORT_DIR=$reportDir \
+    go test -toolexec "$testDir/rd-toolexec" .)
+for test in TestFlaky TestParent
+do
+    grep -q "\"name\":\"$test\",\"test\":{\"failed\":false,\"skipped\":false,\"flaky\":true,\"attempts\":2}" $reportDir/*.jsonl
+done
+
+# Split them across 2 shards and make sure every test ran exactly once
+summaryDir=$(realpath $(mktemp -d summary-XXXXX))
+for index in 0 1
+do
+    (cd gotest && GOCACHE=$cacheDir GOWORK=off DD_TOOLEXEC_SDK=$testDir/gotest/sdk DD_RETRY_COUNT=1 \
+        SHARD_INDEX=$index SHARD_TOTAL=2 SHARD_SUMMARY_DIR=$summaryDir go test -count=1 -toolexec "$testDir/rd-toolexec" .)
+done
+./rd-toolexec verify-shards $summaryDir
+rm -f rd-toolexec
+rm -r $reportDir $summaryDir
+
 rm -f proxy/proxy
 rm -r $cacheDir
@@ -0,0 +1,295 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package leakcheck detects the goroutines leaked by tests. It is the test runner injected by
+// the toolexec proxy in leak detection mode, in place of the testing SDK: top level tests are
+// wrapped by RunM and subtests are started with Run.
+//
+// Every test runs with a goroutine label identifying it, which is inherited by the goroutines
+// it starts. Once the test, its subtests and its cleanups are done, the goroutines still
+// carrying the label are reported as leaked. This makes the check exact for parallel tests,
+// where comparing snapshots of all the goroutines would blame the wrong test.
+//
+// The check is configured with environment variables:
+//
+//	DD_LEAKCHECK          fail (default), warn or off
+//	DD_LEAKCHECK_ALLOW    comma separated functions whose goroutines are ignored
+//	DD_LEAKCHECK_TIMEOUT  time left to the goroutines to exit, 1s by default
+package leakcheck
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tonyredondo/rd-toolexec/testmain"
+)
+
+const (
+	// ModeEnvVar is the environment variable holding the Mode of the check
+	ModeEnvVar = "DD_LEAKCHECK"
+	// AllowEnvVar is the environment variable holding the comma separated allow-list
+	AllowEnvVar = "DD_LEAKCHECK_ALLOW"
+	// TimeoutEnvVar is the environment variable holding the time left to goroutines to exit
+	TimeoutEnvVar = "DD_LEAKCHECK_TIMEOUT"
+)
+
+// Mode is the action taken when a test leaks goroutines
+type Mode string
+
+const (
+	// ModeFail fails the test
+	ModeFail Mode = "fail"
+	// ModeWarn logs the leaked goroutines without failing the test
+	ModeWarn Mode = "warn"
+	// ModeOff disables the check
+	ModeOff Mode = "off"
+)
+
+// DefaultTimeout is the time left to goroutines to exit when TimeoutEnvVar isn't set
+const DefaultTimeout = time.Second
+
+// labelKey is the goroutine label identifying the test that started a goroutine
+const labelKey = "dd_leakcheck"
+
+// defaultAllowed are the functions of goroutines started once for the process lifetime
+var defaultAllowed = []string{
+	"os/signal.signal_recv",
+	"os/signal.loop",
+}
+
+var (
+	mu      sync.Mutex
+	mode    = ModeFail
+	allowed = append([]string(nil), defaultAllowed...)
+	timeout = DefaultTimeout
+
+	nextID  atomic.Int64
+	wrapped sync.Map
+)
+
+func init() {
+	if err := configure(os.Getenv(ModeEnvVar), os.Getenv(AllowEnvVar), os.Getenv(TimeoutEnvVar)); err != nil {
+		fmt.Fprintf(os.Stderr, "leakcheck: %v\n", err)
+	}
+}
+
+func configure(modeValue, allowValue, timeoutValue string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	switch m := Mode(strings.ToLower(strings.TrimSpace(modeValue))); m {
+	case "":
+	case ModeFail, ModeWarn, ModeOff:
+		mode = m
+	default:
+		return fmt.Errorf("invalid %s %q, expected fail, warn or off", ModeEnvVar, modeValue)
+	}
+	for _, fn := range strings.Split(allowValue, ",") {
+		if fn = strings.TrimSpace(fn); fn != "" {
+			allowed = append(allowed, fn)
+		}
+	}
+	if timeoutValue != "" {
+		d, err := time.ParseDuration(timeoutValue)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", TimeoutEnvVar, err)
+		}
+		timeout = d
+	}
+	return nil
+}
+
+// SetMode sets the action taken when a test leaks goroutines
+func SetMode(m Mode) {
+	mu.Lock()
+	defer mu.Unlock()
+	mode = m
+}
+
+// SetTimeout sets the time left to goroutines to exit after a test
+func SetTimeout(d time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	timeout = d
+}
+
+// Allow adds functions to the allow-list. Goroutines with any of them in their stack are not
+// reported. A function is matched by its full name, as in "net/http.(*persistConn).readLoop",
+// or by a prefix ending with a dot or a slash, as in "go.opencensus.io/"
+func Allow(funcs ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	allowed = append(allowed, funcs...)
+}
+
+func settings() (Mode, []string, time.Duration) {
+	mu.Lock()
+	defer mu.Unlock()
+	return mode, allowed, timeout
+}
+
+// RunTestMain runs the tests of m with leak detection and exits
+func RunTestMain(m *testing.M) {
+	os.Exit(RunM(m))
+}
+
+// RunM runs the tests of m with leak detection and returns the exit code of m.Run
+func RunM(m *testing.M) int {
+	if _, loaded := wrapped.LoadOrStore(m, true); !loaded {
+		wrapTests(m)
+	}
+	return m.Run()
+}
+
+// Run runs f as a subtest of t named name with leak detection, see testing.T.Run
+func Run(t *testing.T, name string, f func(*testing.T)) bool {
+	return t.Run(name, func(t *testing.T) {
+		check(t, f)
+	})
+}
+
+// wrapTests wraps the top level tests of m, which are left unchecked if they can't be reached
+func wrapTests(m *testing.M) {
+	ptr, err := testmain.Tests(m)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "leakcheck: %v, top level tests are not checked\n", err)
+		return
+	}
+	tests := *ptr
+	for i := range tests {
+		f := tests[i].F
+		tests[i].F = func(t *testing.T) {
+			check(t, f)
+		}
+	}
+}
+
+// check runs f with the goroutine label of a new test, and registers the cleanup reporting the
+// goroutines left with the label. It is registered first so that it runs after the cleanups of
+// the test, which the testing package runs after the subtests of the test
+func check(t *testing.T, f func(*testing.T)) {
+	if m, _, _ := settings(); m == ModeOff {
+		f(t)
+		return
+	}
+	id := strconv.FormatInt(nextID.Add(1), 10)
+	t.Cleanup(func() {
+		report(t, id)
+	})
+	pprof.Do(context.Background(), pprof.Labels(labelKey, id), func(context.Context) {
+		f(t)
+	})
+}
+
+func report(t *testing.T, id string) {
+	m, allow, d := settings()
+	deadline := time.Now().Add(d)
+	leaked := leakedGoroutines(id, allow)
+	for len(leaked) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		leaked = leakedGoroutines(id, allow)
+	}
+	if len(leaked) == 0 {
+		return
+	}
+	t.Helper()
+	msg := fmt.Sprintf("leakcheck: %d goroutine(s) leaked by %s:\n\n%s", len(leaked), t.Name(), strings.Join(leaked, "\n\n"))
+	if m == ModeWarn {
+		t.Log(msg)
+	} else {
+		t.Error(msg)
+	}
+}
+
+var labelPattern = regexp.MustCompile(`"` + labelKey + `":("(?:[^"\\]|\\.)*")`)
+
+// leakedGoroutines returns the stacks of the goroutines labeled with the test id, except those
+// running an allowed function
+func leakedGoroutines(id string, allow []string) []string {
+	var buf bytes.Buffer
+	_ = pprof.Lookup("goroutine").WriteTo(&buf, 1)
+	var leaked []string
+	for _, record := range parseProfile(buf.String()) {
+		if record.label != id || record.allowed(allow) {
+			continue
+		}
+		for i := 0; i < record.count; i++ {
+			leaked = append(leaked, record.stack)
+		}
+	}
+	return leaked
+}
+
+// goroutines is a record of the goroutine profile, grouping the goroutines with the same stack
+type goroutines struct {
+	count int
+	label string
+	funcs []string
+	stack string
+}
+
+func (g *goroutines) allowed(allow []string) bool {
+	for _, fn := range g.funcs {
+		for _, a := range allow {
+			if fn == a || (strings.HasSuffix(a, ".") || strings.HasSuffix(a, "/")) && strings.HasPrefix(fn, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseProfile parses the goroutine profile in the debug=1 format, in which records are
+// separated by empty lines:
+//
+//	1 @ 0x43e1ae 0x40780c 0x470f21
+//	# labels: {"dd_leakcheck":"3"}
+//	#	0x470f20	example.com/pkg.worker+0x20	/src/pkg/worker.go:12
+func parseProfile(profile string) []goroutines {
+	var records []goroutines
+	for _, block := range strings.Split(profile, "\n\n") {
+		scanner := bufio.NewScanner(strings.NewReader(block))
+		var g goroutines
+		var stack []string
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "# labels: "):
+				if match := labelPattern.FindStringSubmatch(line); match != nil {
+					g.label, _ = strconv.Unquote(match[1])
+				}
+			case strings.HasPrefix(line, "#\t"):
+				fields := strings.Fields(line[1:])
+				if len(fields) < 2 {
+					continue
+				}
+				fn, _, _ := strings.Cut(fields[1], "+0x")
+				g.funcs = append(g.funcs, fn)
+				if len(fields) > 2 {
+					stack = append(stack, fn+"\n\t"+fields[2])
+				} else {
+					stack = append(stack, fn)
+				}
+			case g.count == 0 && strings.Contains(line, " @ "):
+				g.count, _ = strconv.Atoi(strings.Fields(line)[0])
+			}
+		}
+		if g.count > 0 && g.label != "" {
+			g.stack = strings.Join(stack, "\n")
+			records = append(records, g)
+		}
+	}
+	return records
+}
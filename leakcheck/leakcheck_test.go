@@ -0,0 +1,144 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package leakcheck
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	RunTestMain(m)
+}
+
+const leakyEnvVar = "LEAKCHECK_TEST_LEAKY"
+
+var block = make(chan struct{})
+
+// TestLeaky leaks goroutines when run by TestLeaks
+func TestLeaky(t *testing.T) {
+	switch os.Getenv(leakyEnvVar) {
+	case "":
+		t.Skip("run by TestLeaks")
+	case "top":
+		go func() { <-block }()
+	case "subtest":
+		Run(t, "sub", func(t *testing.T) {
+			go func() { <-block }()
+			go func() { <-block }()
+		})
+	case "allowed":
+		Allow("github.com/tonyredondo/rd-toolexec/leakcheck.blockForever")
+		go blockForever()
+	case "cleanup":
+		Run(t, "sub", func(t *testing.T) {
+			done := make(chan struct{})
+			go func() { <-done }()
+			t.Cleanup(func() { close(done) })
+		})
+	}
+}
+
+func blockForever() {
+	<-block
+}
+
+func TestLeaks(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		leaky  string
+		env    string
+		leaked string
+		fails  bool
+	}{
+		{name: "top", leaky: "top", leaked: "1 goroutine(s) leaked by TestLeaky:", fails: true},
+		{name: "subtest", leaky: "subtest", leaked: "2 goroutine(s) leaked by TestLeaky/sub:", fails: true},
+		{name: "allowed", leaky: "allowed"},
+		{name: "cleanup", leaky: "cleanup"},
+		{name: "warn", leaky: "top", env: ModeEnvVar + "=warn", leaked: "1 goroutine(s) leaked by TestLeaky:"},
+		{name: "off", leaky: "top", env: ModeEnvVar + "=off"},
+		{name: "allow-env", leaky: "top", env: AllowEnvVar + "=x/y.z, github.com/tonyredondo/rd-toolexec/"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestLeaky$", "-test.v")
+			cmd.Env = append(os.Environ(), leakyEnvVar+"="+tc.leaky, TimeoutEnvVar+"=50ms", tc.env)
+			out, err := cmd.CombinedOutput()
+			output := string(out)
+
+			if tc.fails != (err != nil) {
+				t.Fatalf("unexpected result %v:\n%s", err, output)
+			}
+			if tc.leaked == "" {
+				if strings.Contains(output, "leaked by") {
+					t.Fatalf("unexpected leak report:\n%s", output)
+				}
+				return
+			}
+			if !strings.Contains(output, tc.leaked) {
+				t.Fatalf("expected %q in:\n%s", tc.leaked, output)
+			}
+			if !strings.Contains(output, "leakcheck.TestLeaky") {
+				t.Fatalf("expected the stack of the leaked goroutine in:\n%s", output)
+			}
+		})
+	}
+}
+
+func TestParallelSubtests(t *testing.T) {
+	for _, name := range []string{"a", "b", "c"} {
+		Run(t, name, func(t *testing.T) {
+			t.Parallel()
+			done := make(chan struct{})
+			go func() { <-done }()
+			time.Sleep(10 * time.Millisecond)
+			close(done)
+		})
+	}
+}
+
+func TestParseProfile(t *testing.T) {
+	profile := `goroutine profile: total 3
+2 @ 0x43e1ae 0x470f21
+# labels: {"dd_leakcheck":"7", "other":"x"}
+#	0x470f20	example.com/pkg.worker+0x20	/src/pkg/worker.go:12
+#	0x470f21	example.com/pkg.start.func1+0x1	/src/pkg/worker.go:5
+
+1 @ 0x43e1ae
+#	0x43e1ad	runtime.gopark+0xd	/go/src/runtime/proc.go:398
+`
+	records := parseProfile(profile)
+	if len(records) != 1 {
+		t.Fatalf("expected 1 labeled record, got %d", len(records))
+	}
+	g := records[0]
+	if g.count != 2 || g.label != "7" {
+		t.Fatalf("unexpected record %+v", g)
+	}
+	if expected := []string{"example.com/pkg.worker", "example.com/pkg.start.func1"}; strings.Join(g.funcs, " ") != strings.Join(expected, " ") {
+		t.Fatalf("unexpected functions %v", g.funcs)
+	}
+	if !strings.HasPrefix(g.stack, "example.com/pkg.worker\n\t/src/pkg/worker.go:12\n") {
+		t.Fatalf("unexpected stack:\n%s", g.stack)
+	}
+
+	for _, tc := range []struct {
+		allow   string
+		allowed bool
+	}{
+		{allow: "example.com/pkg.worker", allowed: true},
+		{allow: "example.com/pkg.", allowed: true},
+		{allow: "example.com/", allowed: true},
+		{allow: "example.com/pkg.work"},
+		{allow: "example.com/pk"},
+	} {
+		if g.allowed([]string{tc.allow}) != tc.allowed {
+			t.Errorf("allowed(%q) != %v", tc.allow, tc.allowed)
+		}
+	}
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"fmt"
 	"github.com/alexflint/go-filemutex"
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/config"
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/mutate"
+	"github.com/tonyredondo/rd-toolexec/internal/toolexec/processors"
 	"github.com/tonyredondo/rd-toolexec/internal/toolexec/processors/gotest"
 	"github.com/tonyredondo/rd-toolexec/internal/toolexec/proxy"
+	"github.com/tonyredondo/rd-toolexec/shard"
 	"io"
 	"log"
 	"os"
@@ -15,19 +19,52 @@ import (
 
 var root string
 
+// leakCheckRunner runs the tests through the leakcheck package instead of the testing SDK
+var leakCheckRunner = gotest.Runner{Name: "ddleakcheck", ImportPath: "github.com/tonyredondo/rd-toolexec/leakcheck"}
+
 func main() {
 	if len(os.Args) == 1 {
 		GetSDKFolder()
 		return
 	}
+	if os.Args[1] == "mutate" {
+		os.Exit(mutate.Main(os.Args[2:]))
+	}
+	if os.Args[1] == "verify-shards" {
+		os.Exit(verifyShards(os.Args[2:]))
+	}
 
 	log.SetOutput(io.Discard)
 	cmdT := proxy.MustParseCommand(os.Args[1:])
 
-	if cmdT.Type() == proxy.CommandTypeOther {
+	if os.Getenv(mutate.EnvVar) != "" {
+		// Mutants are built without any other instrumentation
+		proxy.ProcessCommand(cmdT, mutate.ProcessCompile)
+		proxy.MustRunCommand(cmdT)
+	} else if cmdT.Type() == proxy.CommandTypeOther {
 		proxy.MustRunCommand(cmdT)
 	} else {
-		goTestProcessor := gotest.NewGoTestProcessor(GetSDKFolder())
+		verifyPolicy, err := processors.ParseVerifyPolicy(os.Getenv("DD_TOOLEXEC_VERIFY"))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		var goTestProcessor gotest.GoTestProcessor
+		if os.Getenv("DD_TOOLEXEC_LEAKCHECK") != "" {
+			goTestProcessor = gotest.NewGoTestProcessorWithRunner(leakCheckRunner, path.Join(root, "leakcheck"), path.Join(root, "testrun"), verifyPolicy)
+		} else {
+			goTestProcessor = gotest.NewGoTestProcessor(GetSDKFolder(), path.Join(root, "testrun"), verifyPolicy)
+		}
+		goTestProcessor.ManifestDir = os.Getenv("DD_TOOLEXEC_MANIFEST_DIR")
+		if cfgPath := os.Getenv("DD_TOOLEXEC_CONFIG"); cfgPath != "" {
+			cfg, err := config.Parse(cfgPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed parsing configuration from %s: %v\n", cfgPath, err)
+				os.Exit(1)
+			}
+			goTestProcessor.Selector = cfg.Selector("gotest")
+			cfg.Process(cmdT)
+		}
 		if cmdT.Type() == proxy.CommandTypeCompile {
 			compileCmd := cmdT.(*proxy.CompileCommand)
 			proxy.ProcessCommand(compileCmd, goTestProcessor.ProcessCompile)
@@ -40,6 +77,25 @@ func main() {
 	}
 }
 
+// verifyShards checks that every test ran exactly once across the shards whose summaries were
+// written to the directory given in args
+func verifyShards(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: verify-shards <summary dir>")
+		return 2
+	}
+	summaries, err := shard.ReadSummaries(args[0])
+	if err == nil {
+		err = shard.Verify(summaries)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%d shard summaries verified\n", len(summaries))
+	return 0
+}
+
 func GetSDKFolder() string {
 	finalSdk := ""
 	noArgument := len(os.Args) == 1
@@ -52,6 +108,10 @@ func GetSDKFolder() string {
 		path.Join(root, "external", "dd-sdk-go-testing"),
 		path.Join(os.TempDir(), "dd-sdk-go-testing"),
 	}
+	if sdkPath := os.Getenv("DD_TOOLEXEC_SDK"); sdkPath != "" {
+		// Checkout of the SDK to use instead of the vendored or downloaded one
+		sdkPaths = append([]string{sdkPath}, sdkPaths...)
+	}
 
 	for _, sdkPath := range sdkPaths {
 		autoInstrumentPath := path.Join(sdkPath, "autoinstrument")
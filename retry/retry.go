@@ -0,0 +1,338 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package retry retries the failing tests in-process, along with their subtests. It is used by
+// the testrun package, whichever the test runner is: Run runs the top level tests wrapped by
+// testrun.RunM and the subtests started with testrun.Run, and its results are reported.
+//
+// Retries are opt-in and configured with environment variables:
+//
+//	DD_RETRY_COUNT   retries of the failing top level tests, 0 by default
+//	DD_RETRY_FILE    JSON file with the retries of specific tests and subtests
+//	DD_RETRY_BUDGET  retries left to all the tests of the process, 100 by default
+//
+// The file maps the import paths of the packages to the names of their tests, as reported by
+// go test, and their number of retries, which overrides DD_RETRY_COUNT:
+//
+//	{
+//	  "example.com/foo": {
+//	    "TestFoo": 3,
+//	    "TestBar/sub_test": 5,
+//	    "TestBaz": 0
+//	  }
+//	}
+//
+// A test is failed once marked as failed by the testing package, so the attempts of the tests
+// with retries are run with their own testing.T by testing.RunTests, which also waits for their
+// parallel subtests. The status lines the attempts print, such as `--- FAIL: TestFoo`, are
+// prefixed with `retry attempt N:`, so that neither go test -json nor the readers of the output
+// take them for the result of the test. The test then passes if an attempt passes, and is logged
+// as flaky when it isn't the first. The panics of the attempts and of their subtests started
+// with Run fail the attempt instead of ending the process. Subtests with retries of their own
+// are retried within every attempt of their parent, so that they don't fail it. Parallel tests
+// with retries are run sequentially. Retries are disabled with -failfast, -count and several
+// -cpu values, which apply to the attempts.
+package retry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/testmain"
+)
+
+const (
+	// CountEnvVar is the environment variable holding the retries of the top level tests
+	CountEnvVar = "DD_RETRY_COUNT"
+	// FileEnvVar is the environment variable holding the path of the retries file
+	FileEnvVar = "DD_RETRY_FILE"
+	// BudgetEnvVar is the environment variable holding the retries left to the process
+	BudgetEnvVar = "DD_RETRY_BUDGET"
+)
+
+// DefaultBudget is the retries left to the process when BudgetEnvVar isn't set
+const DefaultBudget = 100
+
+// Outcome is the outcome of a test with retries
+type Outcome string
+
+const (
+	// OutcomePassed is the outcome of the tests passing on their first attempt
+	OutcomePassed Outcome = "passed"
+	// OutcomeFlaky is the outcome of the tests passing after failed attempts
+	OutcomeFlaky Outcome = "flaky"
+	// OutcomeFailed is the outcome of the tests failing every attempt
+	OutcomeFailed Outcome = "failed"
+	// OutcomeSkipped is the outcome of the tests skipped by an attempt
+	OutcomeSkipped Outcome = "skipped"
+)
+
+// Result is the result of a test with retries
+type Result struct {
+	Name     string
+	Attempts int
+	Outcome  Outcome
+}
+
+// Config maps the import paths of the packages to the names of their tests with retries, and
+// their number of retries
+type Config map[string]map[string]int
+
+var (
+	mu         sync.Mutex
+	count      int
+	config     Config
+	budget     atomic.Int64
+	configOnce sync.Once
+
+	// attempting holds the names of the tests whose attempts are running
+	attempting sync.Map
+)
+
+// Load reads the retries file at path
+func Load(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Retries returns the retries of the test name of the package pkg, and whether it is listed
+func (c Config) Retries(pkg string, name string) (int, bool) {
+	tests, ok := c[pkg]
+	if !ok {
+		tests, ok = c[strings.TrimSuffix(pkg, "_test")]
+	}
+	if !ok {
+		return 0, false
+	}
+	retries, ok := tests[name]
+	return retries, ok
+}
+
+// configure reads the configuration from the environment on first use. Tests aren't retried
+// when it is invalid
+func configure() {
+	configOnce.Do(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		budget.Store(DefaultBudget)
+		if s := os.Getenv(BudgetEnvVar); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "retry: invalid %s: %v\n", BudgetEnvVar, err)
+				return
+			}
+			budget.Store(n)
+		}
+		if s := os.Getenv(CountEnvVar); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "retry: invalid %s: %v\n", CountEnvVar, err)
+				return
+			}
+			count = n
+		}
+		if path := os.Getenv(FileEnvVar); path != "" {
+			c, err := Load(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "retry: %v, no test is retried\n", err)
+				count = 0
+				return
+			}
+			config = c
+		}
+	})
+}
+
+// retries returns the retries of the test named name
+func retries(name string) int {
+	configure()
+	if !flagsAllowRetries() {
+		return 0
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if pkg, ok := testmain.PackageOfTest(name); ok {
+		if n, ok := config.Retries(pkg, name); ok {
+			return n
+		}
+	}
+	if strings.Contains(name, "/") {
+		return 0
+	}
+	return count
+}
+
+// flagsAllowRetries reports whether the testing flags applied by testing.RunTests to every
+// attempt are left to their default
+func flagsAllowRetries() bool {
+	for name, allowed := range map[string]func(string) bool{
+		"test.failfast": func(v string) bool { return v == "false" },
+		"test.count":    func(v string) bool { return v == "1" },
+		"test.cpu":      func(v string) bool { return !strings.Contains(v, ",") },
+	} {
+		if f := flag.Lookup(name); f != nil && !allowed(f.Value.String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// Run runs f with t, and retries it when it fails and t has retries. When it is retried, report
+// is called with the result of the test, if not nil, and the test is marked as flaky or failed
+func Run(t *testing.T, f func(*testing.T), report func(Result)) {
+	n := retries(t.Name())
+	if n <= 0 {
+		if inAttempt(t.Name()) {
+			// The panics of the subtests of an attempt would end the process
+			defer recoverAttempt(t)
+		}
+		f(t)
+		return
+	}
+
+	name := t.Name()
+	attempting.Store(name, true)
+	defer attempting.Delete(name)
+	res := Result{Name: name}
+	for {
+		res.Attempts++
+		var attempt *testing.T
+		passed := runAttempt(res.Attempts, func() bool {
+			return testing.RunTests(matchString, []testing.InternalTest{{Name: name, F: func(t *testing.T) {
+				attempt = t
+				defer recoverAttempt(t)
+				f(t)
+			}}})
+		})
+		if passed && attempt != nil && attempt.Skipped() {
+			res.Outcome = OutcomeSkipped
+			break
+		}
+		if passed {
+			res.Outcome = OutcomePassed
+			if res.Attempts > 1 {
+				res.Outcome = OutcomeFlaky
+			}
+			break
+		}
+		if res.Attempts > n || budget.Add(-1) < 0 {
+			res.Outcome = OutcomeFailed
+			break
+		}
+	}
+
+	if report != nil {
+		report(res)
+	}
+	switch res.Outcome {
+	case OutcomeFlaky:
+		t.Logf("flaky: passed on attempt %d of %d", res.Attempts, n+1)
+	case OutcomeFailed:
+		t.Errorf("failed %d attempt(s)", res.Attempts)
+	case OutcomeSkipped:
+		t.Skipf("skipped on attempt %d", res.Attempts)
+	}
+}
+
+// inAttempt reports whether the test named name is run by an attempt of one of its parents
+func inAttempt(name string) bool {
+	for i := strings.LastIndexByte(name, '/'); i > 0; i = strings.LastIndexByte(name[:i], '/') {
+		if _, ok := attempting.Load(name[:i]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// recoverAttempt fails t with the panic of the test, to be deferred
+func recoverAttempt(t *testing.T) {
+	if r := recover(); r != nil {
+		t.Errorf("panic: %v\n%s", r, debug.Stack())
+	}
+}
+
+// runAttempt calls run, which runs the attempt number attempt with testing.RunTests, and marks
+// the status lines printed to the standard output as the ones of the attempt
+func runAttempt(attempt int, run func() bool) bool {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return run()
+	}
+	stdout := os.Stdout
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		markAttempt(stdout, r, attempt)
+	}()
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+		w.Close()
+		<-done
+		r.Close()
+	}()
+	return run()
+}
+
+// markAttempt copies the output of an attempt from r to w, prefixing its status lines, such as
+// `=== RUN` or `--- FAIL:`, with `retry attempt N:`. The framing byte test2json relies on to tell
+// the status lines from the output of the tests is removed
+func markAttempt(w io.Writer, r io.Reader, attempt int) {
+	prefix := fmt.Sprintf("retry attempt %d: ", attempt)
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			trimmed := bytes.TrimPrefix(line, []byte("\x16"))
+			indent := len(trimmed) - len(bytes.TrimLeft(trimmed, " "))
+			if status := trimmed[indent:]; bytes.HasPrefix(status, []byte("=== ")) || bytes.HasPrefix(status, []byte("--- ")) {
+				line = append(append(append([]byte{}, trimmed[:indent]...), prefix...), status...)
+			}
+			w.Write(line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+var (
+	matchMu  sync.Mutex
+	matchRes = map[string]*regexp.Regexp{}
+)
+
+// matchString matches the -run and -skip patterns like the testing package
+func matchString(pat, str string) (bool, error) {
+	matchMu.Lock()
+	defer matchMu.Unlock()
+	re, ok := matchRes[pat]
+	if !ok {
+		var err error
+		if re, err = regexp.Compile(pat); err != nil {
+			return false, err
+		}
+		matchRes[pat] = re
+	}
+	return re.MatchString(str), nil
+}
@@ -0,0 +1,174 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package retry
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/testmain"
+)
+
+func TestMain(m *testing.M) {
+	// Record the packages of the tests, as testrun.RunM does
+	if _, err := testmain.Tests(m); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+const flakyEnvVar = "RETRY_TEST_FLAKY"
+
+var attempts int
+
+// TestFlaky fails its first two attempts when run by TestRetries. Like the tests run by the
+// testrun package, it runs through Run
+func TestFlaky(t *testing.T) {
+	Run(t, flaky, nil)
+}
+
+func flaky(t *testing.T) {
+	switch os.Getenv(flakyEnvVar) {
+	case "":
+		t.Skip("run by TestRetries")
+	case "top":
+		attempts++
+		if attempts < 3 {
+			t.Fatalf("attempt %d failed", attempts)
+		}
+	case "always":
+		t.Fatal("failed")
+	case "panic":
+		attempts++
+		if attempts < 2 {
+			panic("boom")
+		}
+	case "subtest":
+		t.Run("sub test", func(t *testing.T) {
+			Run(t, func(t *testing.T) {
+				attempts++
+				if attempts < 3 {
+					t.Errorf("attempt %d failed", attempts)
+				}
+			}, nil)
+		})
+	case "subtest panic":
+		t.Run("sub test", func(t *testing.T) {
+			Run(t, func(t *testing.T) {
+				attempts++
+				if attempts < 2 {
+					panic("boom")
+				}
+			}, nil)
+		})
+	}
+}
+
+func TestRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		flaky    string
+		env      []string
+		args     []string
+		file     string
+		fails    bool
+		expected string
+		// unexpected is not expected in the output
+		unexpected string
+	}{
+		{name: "flaky", flaky: "top", env: []string{CountEnvVar + "=3"}, expected: "flaky: passed on attempt 3 of 4"},
+		{name: "failed", flaky: "always", env: []string{CountEnvVar + "=2"}, fails: true, expected: "failed 3 attempt(s)"},
+		{name: "budget", flaky: "top", env: []string{CountEnvVar + "=3", BudgetEnvVar + "=1"}, fails: true, expected: "failed 2 attempt(s)"},
+		{name: "panic", flaky: "panic", env: []string{CountEnvVar + "=1"}, expected: "flaky: passed on attempt 2 of 2"},
+		{name: "subtest panic", flaky: "subtest panic", env: []string{CountEnvVar + "=1"}, expected: "flaky: passed on attempt 2 of 2"},
+		{
+			name:       "marked",
+			flaky:      "top",
+			env:        []string{CountEnvVar + "=3"},
+			expected:   "retry attempt 2: --- FAIL: TestFlaky",
+			unexpected: "\n--- FAIL: TestFlaky",
+		},
+		{
+			name:       "marked/test2json",
+			flaky:      "top",
+			env:        []string{CountEnvVar + "=3"},
+			args:       []string{"-test.v=test2json"},
+			expected:   "retry attempt 2: --- FAIL: TestFlaky",
+			unexpected: "\x16--- FAIL: TestFlaky",
+		},
+		{name: "disabled", flaky: "top", fails: true, expected: "attempt 1 failed"},
+		{name: "count", flaky: "top", env: []string{CountEnvVar + "=3"}, args: []string{"-test.count=2"}, fails: true, expected: "attempt 1 failed"},
+		{
+			name:     "file",
+			flaky:    "top",
+			env:      []string{CountEnvVar + "=1"},
+			file:     `{"github.com/tonyredondo/rd-toolexec/retry": {"TestFlaky": 2}}`,
+			expected: "flaky: passed on attempt 3 of 3",
+		},
+		{
+			name:     "subtest",
+			flaky:    "subtest",
+			file:     `{"github.com/tonyredondo/rd-toolexec/retry": {"TestFlaky/sub_test": 2}}`,
+			expected: "flaky: passed on attempt 3 of 3",
+		},
+		{
+			name:     "subtest of a retried test",
+			flaky:    "subtest",
+			env:      []string{CountEnvVar + "=1"},
+			file:     `{"github.com/tonyredondo/rd-toolexec/retry": {"TestFlaky/sub_test": 2}}`,
+			expected: "flaky: passed on attempt 3 of 3",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env := append(os.Environ(), flakyEnvVar+"="+tc.flaky)
+			env = append(env, tc.env...)
+			if tc.file != "" {
+				path := filepath.Join(t.TempDir(), "retry.json")
+				if err := os.WriteFile(path, []byte(tc.file), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				env = append(env, FileEnvVar+"="+path)
+			}
+			cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestFlaky$", "-test.v"}, tc.args...)...)
+			cmd.Env = env
+			out, err := cmd.CombinedOutput()
+			output := string(out)
+
+			if tc.fails != (err != nil) {
+				t.Fatalf("unexpected result %v:\n%s", err, output)
+			}
+			if !strings.Contains(output, tc.expected) {
+				t.Errorf("%q not found in:\n%s", tc.expected, output)
+			}
+			if tc.unexpected != "" && strings.Contains(output, tc.unexpected) {
+				t.Errorf("%q found in:\n%s", tc.unexpected, output)
+			}
+		})
+	}
+}
+
+func TestConfigRetries(t *testing.T) {
+	c := Config{"example.com/foo": {"TestFoo": 3, "TestBar": 0}}
+	for _, tc := range []struct {
+		pkg, name string
+		retries   int
+		listed    bool
+	}{
+		{pkg: "example.com/foo", name: "TestFoo", retries: 3, listed: true},
+		{pkg: "example.com/foo_test", name: "TestFoo", retries: 3, listed: true},
+		{pkg: "example.com/foo", name: "TestBar", listed: true},
+		{pkg: "example.com/foo", name: "TestBaz"},
+		{pkg: "example.com/bar", name: "TestFoo"},
+	} {
+		retries, listed := c.Retries(tc.pkg, tc.name)
+		if retries != tc.retries || listed != tc.listed {
+			t.Errorf("unexpected retries %d, %v for %s %s", retries, listed, tc.pkg, tc.name)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package shard splits the tests of the packages across CI machines. It is used by the testrun
+// package, whichever the test runner is: testrun.RunM runs m with RunWith.
+//
+// The shard of the process is configured with environment variables:
+//
+//	SHARD_INDEX        index of the shard, from 0 to SHARD_TOTAL-1
+//	SHARD_TOTAL        number of shards, the tests aren't sharded when unset
+//	SHARD_SUMMARY_DIR  directory the summaries of the shards are written to
+//
+// The top level tests and the examples of a package are owned by the shard given by a stable
+// hash of their name, and the ones owned by other shards are not run. Every shard writes the
+// summary of the tests of the package, owned or not, telling which ones it ran. The coordinator
+// checks with Verify that every test ran exactly once.
+//
+// The variables are read before the testing package records the environment of the tests, so
+// go test would reuse the cached results of another shard: shards are run with -count=1.
+package shard
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/exampleoutput"
+	"github.com/tonyredondo/rd-toolexec/testmain"
+)
+
+const (
+	// IndexEnvVar is the environment variable holding the index of the shard
+	IndexEnvVar = "SHARD_INDEX"
+	// TotalEnvVar is the environment variable holding the number of shards
+	TotalEnvVar = "SHARD_TOTAL"
+	// SummaryDirEnvVar is the environment variable holding the directory of the summaries
+	SummaryDirEnvVar = "SHARD_SUMMARY_DIR"
+)
+
+// Outcomes of the tests
+const (
+	OutcomePass = "pass"
+	OutcomeFail = "fail"
+	OutcomeSkip = "skip"
+)
+
+// Summary is the summary of the tests of a package run by a shard
+type Summary struct {
+	// Package is the import path of the package, without the _test suffix of external tests
+	Package string `json:"package"`
+	Index   int    `json:"index"`
+	Total   int    `json:"total"`
+	// Tests are the top level tests and examples of the package, in the order they are declared
+	Tests []Test `json:"tests"`
+
+	mu sync.Mutex
+}
+
+// Test is a top level test or example of a package
+type Test struct {
+	Name string `json:"name"`
+	// Kind is "test" or "example"
+	Kind string `json:"kind"`
+	// Owned reports whether the test is owned by the shard
+	Owned bool `json:"owned"`
+	// Ran reports whether the test was run by the shard. Owned tests are not run when filtered
+	// out by -run or -skip
+	Ran     bool   `json:"ran"`
+	Outcome string `json:"outcome,omitempty"`
+}
+
+// Owner returns the index of the shard owning the test name among total shards
+func Owner(name string, total int) int {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int(h.Sum64() % uint64(total))
+}
+
+// config returns the index and the number of shards, or 0 shards when the tests aren't sharded
+func config() (index int, total int, err error) {
+	totalValue, indexValue := os.Getenv(TotalEnvVar), os.Getenv(IndexEnvVar)
+	if totalValue == "" && indexValue == "" {
+		return 0, 0, nil
+	}
+	if total, err = strconv.Atoi(totalValue); err != nil || total <= 0 {
+		return 0, 0, fmt.Errorf("invalid %s %q, expected a positive number", TotalEnvVar, totalValue)
+	}
+	if index, err = strconv.Atoi(indexValue); err != nil || index < 0 || index >= total {
+		return 0, 0, fmt.Errorf("invalid %s %q, expected a number from 0 to %d", IndexEnvVar, indexValue, total-1)
+	}
+	return index, total, nil
+}
+
+// Run runs the tests of m owned by the shard of the process and returns the exit code of m.Run.
+// It writes the summary of the shard to the directory of SummaryDirEnvVar, if set. m is run as
+// is when the tests aren't sharded, and not run when the shard is misconfigured
+func Run(m *testing.M) int {
+	return RunWith(m, (*testing.M).Run)
+}
+
+// RunWith is like Run but runs the tests of m with run, as the RunM function of a test runner
+func RunWith(m *testing.M, run func(*testing.M) int) int {
+	index, total, err := config()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "shard: %v\n", err)
+		return 1
+	}
+	if total == 0 {
+		return run(m)
+	}
+	summary, err := selectTests(m, index, total)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "shard: %v\n", err)
+		return 1
+	}
+	code := run(m)
+	if dir := os.Getenv(SummaryDirEnvVar); dir != "" && summary.Package != "" {
+		if err := WriteSummary(dir, summary); err != nil {
+			fmt.Fprintf(os.Stderr, "shard: %v\n", err)
+			return 1
+		}
+	}
+	return code
+}
+
+// selectTests removes the tests and examples of m owned by other shards, and wraps the others
+// to record their outcome in the returned summary
+func selectTests(m *testing.M, index int, total int) (*Summary, error) {
+	tests, err := testmain.Tests(m)
+	if err != nil {
+		return nil, err
+	}
+	examples, err := testmain.Examples(m)
+	if err != nil {
+		return nil, err
+	}
+	summary := &Summary{Index: index, Total: total, Tests: []Test{}}
+	for _, test := range *tests {
+		if pkg, ok := testmain.PackageOfTest(test.Name); ok {
+			summary.Package = strings.TrimSuffix(pkg, "_test")
+			break
+		}
+	}
+	if summary.Package == "" && len(*examples) > 0 {
+		summary.Package = strings.TrimSuffix(testmain.Package((*examples)[0].F), "_test")
+	}
+
+	*tests = slices.DeleteFunc(*tests, func(test testing.InternalTest) bool {
+		owned := Owner(test.Name, total) == index
+		summary.Tests = append(summary.Tests, Test{Name: test.Name, Kind: "test", Owned: owned})
+		return !owned
+	})
+	for i := range *tests {
+		name, f := (*tests)[i].Name, (*tests)[i].F
+		(*tests)[i].F = func(t *testing.T) {
+			// Cleanups run once the subtests are done, parallel ones included
+			t.Cleanup(func() {
+				outcome := OutcomePass
+				if t.Failed() {
+					outcome = OutcomeFail
+				} else if t.Skipped() {
+					outcome = OutcomeSkip
+				}
+				summary.record(name, "test", outcome)
+			})
+			f(t)
+		}
+	}
+
+	*examples = slices.DeleteFunc(*examples, func(example testing.InternalExample) bool {
+		owned := Owner(example.Name, total) == index
+		summary.Tests = append(summary.Tests, Test{Name: example.Name, Kind: "example", Owned: owned})
+		return !owned
+	})
+	for i := range *examples {
+		(*examples)[i] = exampleoutput.Wrap((*examples)[i], func(r exampleoutput.Result) {
+			outcome := OutcomePass
+			if !r.Passed {
+				outcome = OutcomeFail
+			}
+			summary.record(r.Name, "example", outcome)
+		})
+	}
+	return summary, nil
+}
+
+func (s *Summary) record(name string, kind string, outcome string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.Tests {
+		if s.Tests[i].Name == name && s.Tests[i].Kind == kind {
+			s.Tests[i].Ran = true
+			s.Tests[i].Outcome = outcome
+			return
+		}
+	}
+}
+
+// summaryFileName returns the name of the file of the summary of the shard index of the package
+// pkg
+func summaryFileName(pkg string, index int, total int) string {
+	return fmt.Sprintf("%s.%d-of-%d.json", url.PathEscape(pkg), index, total)
+}
+
+// WriteSummary writes the summary to the directory dir
+func WriteSummary(dir string, summary *Summary) error {
+	summary.mu.Lock()
+	data, err := json.MarshalIndent(summary, "", "  ")
+	summary.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, summaryFileName(summary.Package, summary.Index, summary.Total)), data, 0o644)
+}
+
+// ReadSummaries reads the summaries written to the directory dir
+func ReadSummaries(dir string) ([]*Summary, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var summaries []*Summary
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		summary := new(Summary)
+		if err := json.Unmarshal(data, summary); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries, nil
+}
+
+// Verify checks that the summaries of every package come from all of its shards, and that every
+// test of the package ran exactly once
+func Verify(summaries []*Summary) error {
+	byPackage := map[string][]*Summary{}
+	for _, summary := range summaries {
+		byPackage[summary.Package] = append(byPackage[summary.Package], summary)
+	}
+	packages := make([]string, 0, len(byPackage))
+	for pkg := range byPackage {
+		packages = append(packages, pkg)
+	}
+	slices.Sort(packages)
+
+	var errs []error
+	for _, pkg := range packages {
+		shards := byPackage[pkg]
+		total := shards[0].Total
+		indexes := map[int]int{}
+		runs := map[string]int{}
+		var names []string
+		for _, summary := range shards {
+			if summary.Total != total {
+				errs = append(errs, fmt.Errorf("%s: shards of %d and %d shards", pkg, total, summary.Total))
+			}
+			indexes[summary.Index]++
+			for _, test := range summary.Tests {
+				key := test.Kind + " " + test.Name
+				if _, ok := runs[key]; !ok {
+					names = append(names, key)
+					runs[key] = 0
+				}
+				if test.Ran {
+					runs[key]++
+				}
+			}
+		}
+		for index := 0; index < total; index++ {
+			if n := indexes[index]; n != 1 {
+				errs = append(errs, fmt.Errorf("%s: %d summaries of shard %d", pkg, n, index))
+			}
+		}
+		for _, name := range names {
+			if n := runs[name]; n != 1 {
+				errs = append(errs, fmt.Errorf("%s: %s ran %d times", pkg, name, n))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,160 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package shard
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// runWith reports whether the tests are run by the run function given to RunWith
+var runWith bool
+
+func TestMain(m *testing.M) {
+	os.Exit(RunWith(m, func(m *testing.M) int {
+		runWith = true
+		return m.Run()
+	}))
+}
+
+func TestRunWith(t *testing.T) {
+	if !runWith {
+		t.Error("tests not run by the run function")
+	}
+}
+
+const childEnvVar = "SHARD_TEST_CHILD"
+
+func TestA(t *testing.T) {}
+func TestB(t *testing.T) {}
+func TestC(t *testing.T) {}
+func TestD(t *testing.T) {}
+func TestE(t *testing.T) {}
+func TestF(t *testing.T) {}
+
+func ExampleOwner() {
+	fmt.Println(Owner("TestA", 1))
+	// Output: 0
+}
+
+func TestShards(t *testing.T) {
+	if os.Getenv(childEnvVar) != "" {
+		t.Skip("run by TestShards")
+	}
+	dir := t.TempDir()
+	const total = 3
+	for index := 0; index < total; index++ {
+		cmd := exec.Command(os.Args[0], "-test.v")
+		cmd.Env = append(os.Environ(),
+			childEnvVar+"=1",
+			IndexEnvVar+"="+strconv.Itoa(index),
+			TotalEnvVar+"="+strconv.Itoa(total),
+			SummaryDirEnvVar+"="+dir,
+		)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("shard %d failed %v:\n%s", index, err, out)
+		}
+		for _, name := range []string{"TestA", "TestB", "TestC", "TestD", "TestE", "TestF", "TestRunWith", "ExampleOwner"} {
+			ran := strings.Contains(string(out), "--- PASS: "+name+" ")
+			if owned := Owner(name, total) == index; ran != owned {
+				t.Errorf("%s ran by shard %d: %v, owned: %v\n%s", name, index, ran, owned, out)
+			}
+		}
+	}
+
+	summaries, err := ReadSummaries(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != total {
+		t.Fatalf("unexpected summaries %v", summaries)
+	}
+	for _, summary := range summaries {
+		if summary.Package != "github.com/tonyredondo/rd-toolexec/shard" {
+			t.Errorf("unexpected package %s", summary.Package)
+		}
+	}
+	if err := Verify(summaries); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInvalidShard(t *testing.T) {
+	if os.Getenv(childEnvVar) != "" {
+		t.Skip("run by TestShards")
+	}
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), IndexEnvVar+"=3", TotalEnvVar+"=3")
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "invalid SHARD_INDEX") {
+		t.Errorf("unexpected result %v:\n%s", err, out)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	summary := func(index int, tests ...Test) *Summary {
+		return &Summary{Package: "example.com/foo", Index: index, Total: 2, Tests: tests}
+	}
+	for _, tc := range []struct {
+		name      string
+		summaries []*Summary
+		expected  []string
+	}{
+		{
+			name: "valid",
+			summaries: []*Summary{
+				summary(0, Test{Name: "TestA", Kind: "test", Owned: true, Ran: true}, Test{Name: "TestB", Kind: "test"}),
+				summary(1, Test{Name: "TestA", Kind: "test"}, Test{Name: "TestB", Kind: "test", Owned: true, Ran: true}),
+			},
+		},
+		{
+			name: "missing shard",
+			summaries: []*Summary{
+				summary(0, Test{Name: "TestA", Kind: "test", Owned: true, Ran: true}, Test{Name: "TestB", Kind: "test"}),
+			},
+			expected: []string{"0 summaries of shard 1", "test TestB ran 0 times"},
+		},
+		{
+			name: "ran twice",
+			summaries: []*Summary{
+				summary(0, Test{Name: "TestA", Kind: "test", Owned: true, Ran: true}),
+				summary(1, Test{Name: "TestA", Kind: "test", Ran: true}),
+			},
+			expected: []string{"test TestA ran 2 times"},
+		},
+		{
+			name: "duplicate shard",
+			summaries: []*Summary{
+				summary(0, Test{Name: "TestA", Kind: "test", Owned: true, Ran: true}),
+				summary(0, Test{Name: "TestA", Kind: "test"}),
+			},
+			expected: []string{"2 summaries of shard 0", "0 summaries of shard 1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Verify(tc.summaries)
+			if len(tc.expected) == 0 {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, expected := range tc.expected {
+				if !strings.Contains(err.Error(), expected) {
+					t.Errorf("%q not found in %v", expected, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package skiplist skips the tests listed in a local skip-list file, so that CI can skip the
+// tests unaffected by a change without changing any test code. It is used by the testrun package,
+// whichever the test runner is: Skip is called at the start of the top level tests wrapped by
+// testrun.RunM and of the subtests started with testrun.Run.
+//
+// The path of the file is read from DD_SKIPLIST_FILE. The file maps the import paths of the
+// packages to the names of their tests to skip, along with the reason logged by t.Skip:
+//
+//	{
+//	  "example.com/foo": {
+//	    "TestFoo": "not affected by the change",
+//	    "TestBar/sub_test": ""
+//	  }
+//	}
+//
+// Subtests are named as reported by go test, with the spaces of their names replaced with
+// underscores. The tests of external test packages are listed under the package they test or
+// under their own package, as in example.com/foo_test.
+package skiplist
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/testmain"
+)
+
+// FileEnvVar is the environment variable holding the path of the skip-list file
+const FileEnvVar = "DD_SKIPLIST_FILE"
+
+// DefaultReason is the reason of the tests listed without one
+const DefaultReason = "listed in the skip-list file"
+
+// List maps the import paths of the packages to the names of their tests to skip, and the
+// reasons they are skipped for
+type List map[string]map[string]string
+
+var (
+	mu       sync.Mutex
+	list     List
+	loadOnce sync.Once
+)
+
+// Load reads the skip-list file at path
+func Load(path string) (List, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var l List
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Reason returns the reason the test name of the package pkg is skipped for, and whether it is
+// listed
+func (l List) Reason(pkg string, name string) (string, bool) {
+	tests, ok := l[pkg]
+	if !ok {
+		tests, ok = l[strings.TrimSuffix(pkg, "_test")]
+	}
+	if !ok {
+		return "", false
+	}
+	reason, ok := tests[name]
+	if ok && reason == "" {
+		reason = DefaultReason
+	}
+	return reason, ok
+}
+
+// Set sets the skip-list, replacing the one read from FileEnvVar
+func Set(l List) {
+	loadOnce.Do(func() {})
+	mu.Lock()
+	defer mu.Unlock()
+	list = l
+}
+
+// current returns the skip-list, read from FileEnvVar on first use. Nothing is skipped when the
+// file can't be read, running the tests being the safe choice
+func current() List {
+	loadOnce.Do(func() {
+		path := os.Getenv(FileEnvVar)
+		if path == "" {
+			return
+		}
+		l, err := Load(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "skiplist: %v, no test is skipped\n", err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		list = l
+	})
+	mu.Lock()
+	defer mu.Unlock()
+	return list
+}
+
+// Skip skips the test t if it is listed. Its package is the one of its top level test, as
+// recorded by testmain.Tests
+func Skip(t testing.TB) {
+	t.Helper()
+	pkg, ok := testmain.PackageOfTest(t.Name())
+	if !ok {
+		return
+	}
+	if reason, ok := current().Reason(pkg, t.Name()); ok {
+		t.Skip(reason)
+	}
+}
@@ -0,0 +1,110 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package skiplist
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/testmain"
+)
+
+func TestMain(m *testing.M) {
+	// Record the packages of the tests, as testrun.RunM does
+	if _, err := testmain.Tests(m); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+const listedEnvVar = "SKIPLIST_TEST_LISTED"
+
+// TestListed fails unless it is skipped when run by TestSkip. Like the tests run by the testrun
+// package, it calls Skip first
+func TestListed(t *testing.T) {
+	if os.Getenv(listedEnvVar) == "" {
+		t.Skip("run by TestSkip")
+	}
+	Skip(t)
+	t.Run("sub test", func(t *testing.T) {
+		Skip(t)
+		t.Error("not skipped")
+	})
+	t.Run("other", func(t *testing.T) {
+		Skip(t)
+	})
+	if os.Getenv(listedEnvVar) == "top" {
+		t.Error("not skipped")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		listed   string
+		list     string
+		expected []string
+	}{
+		{
+			name:     "top",
+			listed:   "top",
+			list:     `{"github.com/tonyredondo/rd-toolexec/skiplist": {"TestListed": "unaffected"}}`,
+			expected: []string{"--- SKIP: TestListed", "unaffected"},
+		},
+		{
+			name:     "subtest",
+			listed:   "subtest",
+			list:     `{"github.com/tonyredondo/rd-toolexec/skiplist": {"TestListed/sub_test": ""}}`,
+			expected: []string{"--- PASS: TestListed", "--- SKIP: TestListed/sub_test", DefaultReason, "--- PASS: TestListed/other"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "skiplist.json")
+			if err := os.WriteFile(path, []byte(tc.list), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			cmd := exec.Command(os.Args[0], "-test.run=^TestListed$", "-test.v")
+			cmd.Env = append(os.Environ(), listedEnvVar+"="+tc.listed, FileEnvVar+"="+path)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("unexpected error %v:\n%s", err, out)
+			}
+			for _, expected := range tc.expected {
+				if !strings.Contains(string(out), expected) {
+					t.Errorf("%q not found in:\n%s", expected, out)
+				}
+			}
+		})
+	}
+}
+
+func TestReason(t *testing.T) {
+	l := List{
+		"example.com/foo":      {"TestFoo": "reason", "TestFoo/bar": ""},
+		"example.com/bar_test": {"TestBar": "external"},
+	}
+	for _, tc := range []struct {
+		pkg, name string
+		reason    string
+		listed    bool
+	}{
+		{pkg: "example.com/foo", name: "TestFoo", reason: "reason", listed: true},
+		{pkg: "example.com/foo_test", name: "TestFoo", reason: "reason", listed: true},
+		{pkg: "example.com/foo", name: "TestFoo/bar", reason: DefaultReason, listed: true},
+		{pkg: "example.com/foo", name: "TestBar"},
+		{pkg: "example.com/bar_test", name: "TestBar", reason: "external", listed: true},
+		{pkg: "example.com/bar", name: "TestBar"},
+		{pkg: "example.com/baz", name: "TestFoo"},
+	} {
+		reason, listed := l.Reason(tc.pkg, tc.name)
+		if reason != tc.reason || listed != tc.listed {
+			t.Errorf("unexpected reason %q, %v for %s %s", reason, listed, tc.pkg, tc.name)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package testmain gives the test runners access to the top level tests of testing.M, so that
+// they can wrap or filter them before m.Run runs them.
+package testmain
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"unsafe"
+)
+
+// ErrUnsupported is returned when the layout of testing.M isn't the one expected
+var ErrUnsupported = errors.New("unsupported testing.M layout")
+
+// packages maps the names of the top level tests to the import path of their package
+var packages sync.Map
+
+// Tests returns the top level tests of m. They are only reachable through an unexported field
+// of testing.M, so ErrUnsupported is returned if its layout changes. Changes to the tests are
+// seen by m.Run.
+//
+// The package of the tests is recorded on the first call, for PackageOfTest. It must be made
+// before the tests are wrapped, as their package is told by their function
+func Tests(m *testing.M) (*[]testing.InternalTest, error) {
+	field := reflect.ValueOf(m).Elem().FieldByName("tests")
+	if !field.IsValid() || field.Type() != reflect.TypeOf([]testing.InternalTest(nil)) {
+		return nil, ErrUnsupported
+	}
+	tests := (*[]testing.InternalTest)(unsafe.Pointer(field.UnsafeAddr()))
+	for _, test := range *tests {
+		packages.LoadOrStore(test.Name, Package(test.F))
+	}
+	return tests, nil
+}
+
+// Examples returns the examples of m, like Tests returns its tests
+func Examples(m *testing.M) (*[]testing.InternalExample, error) {
+	field := reflect.ValueOf(m).Elem().FieldByName("examples")
+	if !field.IsValid() || field.Type() != reflect.TypeOf([]testing.InternalExample(nil)) {
+		return nil, ErrUnsupported
+	}
+	return (*[]testing.InternalExample)(unsafe.Pointer(field.UnsafeAddr())), nil
+}
+
+// PackageOfTest returns the import path of the package of the top level test of the test named
+// name, as in TestFoo/bar, and whether it was recorded by Tests
+func PackageOfTest(name string) (string, bool) {
+	root, _, _ := strings.Cut(name, "/")
+	pkg, ok := packages.Load(root)
+	if !ok {
+		return "", false
+	}
+	return pkg.(string), true
+}
+
+// Package returns the import path of the package declaring the function f, as in
+// example.com/foo_test for the tests of an external test package, or "" if f isn't a function
+func Package(f any) string {
+	fn := reflect.ValueOf(f)
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return ""
+	}
+	runtimeFunc := runtime.FuncForPC(fn.Pointer())
+	if runtimeFunc == nil {
+		return ""
+	}
+	// The package path ends at the first dot of its last element, as in example.com/foo.TestFoo
+	name := runtimeFunc.Name()
+	lastSlash := strings.LastIndexByte(name, '/') + 1
+	dot := strings.IndexByte(name[lastSlash:], '.')
+	if dot < 0 {
+		return ""
+	}
+	return name[:lastSlash+dot]
+}
@@ -0,0 +1,77 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package testmain
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var names []string
+
+func TestMain(m *testing.M) {
+	tests, err := Tests(m)
+	if err != nil {
+		panic(err)
+	}
+	for _, test := range *tests {
+		names = append(names, test.Name)
+	}
+	examples, err := Examples(m)
+	if err != nil {
+		panic(err)
+	}
+	for _, example := range *examples {
+		names = append(names, example.Name)
+	}
+	os.Exit(m.Run())
+}
+
+func TestTests(t *testing.T) {
+	for _, name := range []string{"TestTests", "TestPackage", "ExamplePackage"} {
+		if !slices.Contains(names, name) {
+			t.Errorf("%s not found in %v", name, names)
+		}
+	}
+}
+
+func TestPackageOfTest(t *testing.T) {
+	for name, expected := range map[string]string{
+		"TestTests":     "github.com/tonyredondo/rd-toolexec/testmain",
+		"TestTests/sub": "github.com/tonyredondo/rd-toolexec/testmain",
+		"TestUnknown":   "",
+	} {
+		if pkg, ok := PackageOfTest(name); pkg != expected || ok != (expected != "") {
+			t.Errorf("unexpected package %q for %s", pkg, name)
+		}
+	}
+}
+
+func TestPackage(t *testing.T) {
+	for _, tc := range []struct {
+		f        any
+		expected string
+	}{
+		{f: TestPackage, expected: "github.com/tonyredondo/rd-toolexec/testmain"},
+		{f: func() {}, expected: "github.com/tonyredondo/rd-toolexec/testmain"},
+		{f: strings.Cut, expected: "strings"},
+		{f: (*testing.T).Run, expected: "testing"},
+		{f: "TestPackage", expected: ""},
+		{f: (func())(nil), expected: ""},
+	} {
+		if pkg := Package(tc.f); pkg != tc.expected {
+			t.Errorf("unexpected package %q for %v, expected %q", pkg, tc.f, tc.expected)
+		}
+	}
+}
+
+func ExamplePackage() {
+	fmt.Println(Package(strings.Cut))
+	// Output: strings
+}
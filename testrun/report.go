@@ -0,0 +1,177 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package testrun
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// ReportDirEnvVar is the environment variable holding the directory the reports are written to
+const ReportDirEnvVar = "DD_TESTRUN_REPORT_DIR"
+
+// Kind is the kind of result held by a Record
+type Kind string
+
+const (
+	// KindBenchmark is the result of a benchmark
+	KindBenchmark Kind = "benchmark"
+	// KindFuzz is the result of an input of a fuzz test
+	KindFuzz Kind = "fuzz"
+	// KindTest is the result of a test
+	KindTest Kind = "test"
+	// KindExample is the result of a runnable example
+	KindExample Kind = "example"
+)
+
+// Record is a result of the tests of a package
+type Record struct {
+	Kind Kind `json:"kind"`
+	// Package is the import path of the package declaring the test, when it is known
+	Package string `json:"package,omitempty"`
+	// Name is the name of the test, as in BenchmarkFoo/sub
+	Name string `json:"name"`
+	// Benchmark is the result of a benchmark
+	Benchmark *Benchmark `json:"benchmark,omitempty"`
+	// Fuzz is the result of an input of a fuzz test
+	Fuzz *FuzzInput `json:"fuzz,omitempty"`
+	// Test is the result of a test
+	Test *Test `json:"test,omitempty"`
+	// Example is the result of a runnable example
+	Example *ExampleResult `json:"example,omitempty"`
+}
+
+// Benchmark is the result of the last round of a benchmark
+type Benchmark struct {
+	// N is the number of iterations of the round
+	N int `json:"n"`
+	// NsPerOp is the time of an iteration, in nanoseconds
+	NsPerOp int64 `json:"ns_per_op"`
+	// AllocsPerOp is the number of heap allocations of an iteration
+	AllocsPerOp int64 `json:"allocs_per_op"`
+	// BytesPerOp is the number of heap allocated bytes of an iteration
+	BytesPerOp int64 `json:"bytes_per_op"`
+}
+
+// FuzzInput is the result of an input of a fuzz test, named after the fuzz test and the corpus
+// entry or the input, as in FuzzFoo/seed#0
+type FuzzInput struct {
+	// Failed reports whether the input failed the test
+	Failed bool `json:"failed"`
+	// Input is the input, in the format of the corpus files
+	Input string `json:"input,omitempty"`
+	// CorpusPath is the path of the corpus entry of a failing input, relative to the package
+	// directory, as in testdata/fuzz/FuzzFoo/582528ddfad69eb5
+	CorpusPath string `json:"corpus_path,omitempty"`
+	// Panic is the value the fuzz function panicked with, if any
+	Panic string `json:"panic,omitempty"`
+}
+
+// Test is the result of a test of a suite or of a retried test
+type Test struct {
+	// Suite is the name of the test suite running the test, if any
+	Suite string `json:"suite,omitempty"`
+	// Failed reports whether the test failed
+	Failed bool `json:"failed"`
+	// Skipped reports whether the test was skipped
+	Skipped bool `json:"skipped"`
+	// Flaky reports whether the test passed after failed attempts
+	Flaky bool `json:"flaky,omitempty"`
+	// Attempts is the number of attempts of a retried test
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// ExampleResult is the result of a runnable example
+type ExampleResult struct {
+	// Passed reports whether the example printed the expected output without panicking
+	Passed bool `json:"passed"`
+	// Expected is the output expected by the `// Output:` comment of a failed example
+	Expected string `json:"expected,omitempty"`
+	// Actual is the output a failed example wrote to the standard output
+	Actual string `json:"actual,omitempty"`
+	// Unordered reports whether the output is compared regardless of the order of the lines
+	Unordered bool `json:"unordered,omitempty"`
+	// Panic is the value the example panicked with, if any
+	Panic string `json:"panic,omitempty"`
+	// DurationNs is the running time of the example, in nanoseconds
+	DurationNs int64 `json:"duration_ns"`
+}
+
+var reportMu sync.Mutex
+
+// reportFileName is the name of the report file of the process. The fuzzing engine runs the
+// inputs in worker processes, which write their own report
+func reportFileName() string {
+	return strconv.Itoa(os.Getpid()) + ".jsonl"
+}
+
+// report appends the records recs to the report file of the process, if ReportDirEnvVar is set
+func report(recs ...Record) {
+	dir := os.Getenv(ReportDirEnvVar)
+	if dir == "" || len(recs) == 0 {
+		return
+	}
+	var data []byte
+	for _, rec := range recs {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "testrun: %v\n", err)
+			return
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	reportMu.Lock()
+	defer reportMu.Unlock()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		fmt.Fprintf(os.Stderr, "testrun: %v\n", err)
+		return
+	}
+	file, err := os.OpenFile(filepath.Join(dir, reportFileName()), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "testrun: %v\n", err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		fmt.Fprintf(os.Stderr, "testrun: %v\n", err)
+	}
+}
+
+// ReadReports returns the records of the report files written to dir
+func ReadReports(dir string) ([]Record, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	var recs []Record
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var rec Record
+			if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("parsing %s: %w", path, err)
+			}
+			recs = append(recs, rec)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+	return recs, nil
+}
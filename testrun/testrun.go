@@ -0,0 +1,293 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+// Package testrun is the runtime of the instrumented tests. The toolexec proxy injects it in
+// the test binaries along with the test runner, whichever it is, and the rewritten test files
+// call it in place of the methods of the testing package. RunM runs the tests of m with the
+// RunM function of the runner, Run starts a subtest with the Run function of the runner,
+// RunBenchmark runs the body of a benchmark, RunB starts a sub-benchmark and RunFuzz runs the
+// fuzz function of a fuzz test and Example wraps a runnable example. Suite is called by the
+// instrumented test frameworks, and tags the tests of a suite with its name.
+//
+// Whichever the runner is, the tests and subtests started by RunM and Run are skipped when
+// listed in the skip-list file of the skiplist package, and retried as configured for the retry
+// package when they fail. The retried tests are reported as flaky or failed. With the
+// environment of the shard package, RunM only runs the tests owned by the shard of the process.
+//
+// The results of the tests are written as lines of JSON to a report file per process, in the
+// directory given by the DD_TESTRUN_REPORT_DIR environment variable. No report is written
+// when it is unset.
+package testrun
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/tonyredondo/rd-toolexec/exampleoutput"
+	"github.com/tonyredondo/rd-toolexec/fuzzing"
+	"github.com/tonyredondo/rd-toolexec/retry"
+	"github.com/tonyredondo/rd-toolexec/shard"
+	"github.com/tonyredondo/rd-toolexec/skiplist"
+	"github.com/tonyredondo/rd-toolexec/testmain"
+)
+
+var (
+	// wrapped are the testing.M whose tests are wrapped, as m.Run may be called more than once
+	wrapped sync.Map
+
+	benchMu sync.Mutex
+	// benchmarks are the benchmarks run, whose results are reported by RunM once the benchmarks
+	// are done, as there is no telling which round is the last
+	benchmarks []benchmark
+	// parents are the names of the benchmarks running sub-benchmarks, which are not reported
+	parents = make(map[string]bool)
+
+	suitesMu sync.Mutex
+	// suites are the test suites run by the tests, by test name
+	suites = make(map[string]suite)
+)
+
+// benchmark is a benchmark run by RunBenchmark
+type benchmark struct {
+	b    *testing.B
+	pkg  string
+	name string
+}
+
+// suite is a test suite
+type suite struct {
+	name string
+	pkg  string
+}
+
+// RunTestMain runs the tests of m with run, the RunM function of the runner, and exits
+func RunTestMain(m *testing.M, run func(*testing.M) int) {
+	os.Exit(RunM(m, run))
+}
+
+// RunM runs the tests of m owned by the shard of the process with run, the RunM function of the
+// runner, and returns its exit code
+func RunM(m *testing.M, run func(*testing.M) int) int {
+	if _, loaded := wrapped.LoadOrStore(m, true); !loaded {
+		wrapTests(m)
+	}
+	code := shard.RunWith(m, run)
+	reportBenchmarks()
+	return code
+}
+
+// wrapTests wraps the top level tests of m, which are run as is if they can't be reached
+func wrapTests(m *testing.M) {
+	ptr, err := testmain.Tests(m)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "testrun: %v, top level tests are run as is\n", err)
+		return
+	}
+	tests := *ptr
+	for i := range tests {
+		f := tests[i].F
+		tests[i].F = func(t *testing.T) {
+			runTest(t, f)
+		}
+	}
+}
+
+// Run runs f as a subtest of t named name with run, the Run function of the runner, see
+// testing.T.Run
+func Run(t *testing.T, name string, f func(*testing.T), run func(*testing.T, string, func(*testing.T)) bool) bool {
+	return run(t, name, func(t *testing.T) {
+		runTest(t, f)
+	})
+}
+
+// runTest runs the test t with f, unless it is listed in the skip-list, and retries it when it
+// fails. The tests of suites and the retried tests are reported once done
+func runTest(t *testing.T, f func(*testing.T)) {
+	s, inSuite := suiteOf(t.Name())
+	var retried *retry.Result
+	// Registered first, the cleanup runs after the ones of the test and its subtests
+	t.Cleanup(func() {
+		if !inSuite && retried == nil {
+			return
+		}
+		rec := Record{Kind: KindTest, Package: s.pkg, Name: t.Name(), Test: &Test{Suite: s.name, Failed: t.Failed(), Skipped: t.Skipped()}}
+		if rec.Package == "" {
+			rec.Package, _ = testmain.PackageOfTest(t.Name())
+		}
+		if retried != nil {
+			rec.Test.Attempts = retried.Attempts
+			rec.Test.Flaky = retried.Outcome == retry.OutcomeFlaky
+		}
+		report(rec)
+	})
+	skiplist.Skip(t)
+	retry.Run(t, f, func(res retry.Result) {
+		if res.Attempts > 1 {
+			retried = &res
+		}
+	})
+}
+
+// Suite tags the test t and its subtests with the test suite s, whose name is the name of its
+// type. It is called by the test frameworks instrumented by the toolexec proxy
+func Suite(t *testing.T, s any) {
+	typ := reflect.TypeOf(s)
+	for typ != nil && typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ == nil || typ.Name() == "" {
+		return
+	}
+	suitesMu.Lock()
+	suites[t.Name()] = suite{name: typ.Name(), pkg: typ.PkgPath()}
+	suitesMu.Unlock()
+}
+
+// SuiteName returns the name of the test suite run by the test named name or by one of its
+// parents, or "" if it isn't part of a suite
+func SuiteName(name string) string {
+	s, _ := suiteOf(name)
+	return s.name
+}
+
+func suiteOf(name string) (suite, bool) {
+	suitesMu.Lock()
+	defer suitesMu.Unlock()
+	for {
+		if s, ok := suites[name]; ok {
+			return s, true
+		}
+		i := strings.LastIndexByte(name, '/')
+		if i < 0 {
+			return suite{}, false
+		}
+		name = name[:i]
+	}
+}
+
+// RunBenchmark runs the body f of the benchmark b. The time, the allocations and the allocated
+// bytes per iteration are the ones the testing package measures for its last round, as printed
+// with -benchmem, so that the setup and the work done with the timer stopped are not counted
+func RunBenchmark(b *testing.B, f func(*testing.B)) {
+	f(b)
+
+	benchMu.Lock()
+	defer benchMu.Unlock()
+	for i := range benchmarks {
+		if benchmarks[i].b == b {
+			return
+		}
+	}
+	benchmarks = append(benchmarks, benchmark{b: b, pkg: testmain.Package(f), name: b.Name()})
+}
+
+// benchmarkResult returns the result of the last round of b, which the testing package keeps in
+// an unexported field once the benchmark is done, and whether it was found
+func benchmarkResult(b *testing.B) (testing.BenchmarkResult, bool) {
+	field := reflect.ValueOf(b).Elem().FieldByName("result")
+	if !field.IsValid() || field.Type() != reflect.TypeOf(testing.BenchmarkResult{}) {
+		return testing.BenchmarkResult{}, false
+	}
+	return *(*testing.BenchmarkResult)(unsafe.Pointer(field.UnsafeAddr())), true
+}
+
+// RunB runs f as a sub-benchmark of b named name, see testing.B.Run
+func RunB(b *testing.B, name string, f func(*testing.B)) bool {
+	benchMu.Lock()
+	parents[b.Name()] = true
+	benchMu.Unlock()
+	return b.Run(name, func(b *testing.B) {
+		RunBenchmark(b, f)
+	})
+}
+
+// reportBenchmarks reports the results of the benchmarks, except the ones only running
+// sub-benchmarks
+func reportBenchmarks() {
+	benchMu.Lock()
+	var recs []Record
+	for _, bench := range benchmarks {
+		if parents[bench.name] {
+			continue
+		}
+		result, ok := benchmarkResult(bench.b)
+		if !ok || result.N <= 0 {
+			continue
+		}
+		recs = append(recs, Record{
+			Kind:    KindBenchmark,
+			Package: bench.pkg,
+			Name:    bench.name,
+			Benchmark: &Benchmark{
+				N:           result.N,
+				NsPerOp:     result.NsPerOp(),
+				AllocsPerOp: result.AllocsPerOp(),
+				BytesPerOp:  result.AllocedBytesPerOp(),
+			},
+		})
+	}
+	benchmarks = nil
+	benchMu.Unlock()
+	report(recs...)
+}
+
+// RunFuzz runs the fuzz function ff of f, see testing.F.Fuzz. The seed corpus entries are
+// reported, as well as the inputs of the fuzzing engine which fail, with the corpus entry the
+// engine writes them to
+func RunFuzz(f *testing.F, ff any) {
+	pkg := testmain.Package(ff)
+	f.Fuzz(fuzzing.Wrap(ff, func(t *testing.T, in fuzzing.Input) {
+		if !in.Failed && engineRunning() {
+			return
+		}
+		rec := Record{Kind: KindFuzz, Package: pkg, Name: in.Name, Fuzz: &FuzzInput{Failed: in.Failed, CorpusPath: in.CorpusPath}}
+		if data, err := fuzzing.Marshal(in.Values...); err == nil {
+			rec.Fuzz.Input = string(data)
+		}
+		if in.Panic != nil {
+			rec.Fuzz.Panic = fmt.Sprint(in.Panic)
+		}
+		if in.Failed && in.CorpusPath != "" {
+			t.Logf("failing input %s, corpus entry %s", in.Name, in.CorpusPath)
+		}
+		report(rec)
+	}))
+}
+
+// engineRunning reports whether the fuzzing engine is running, rather than the seed corpus
+func engineRunning() bool {
+	for _, name := range []string{"test.fuzz", "test.fuzzworker"} {
+		if f := flag.Lookup(name); f != nil && f.Value.String() != "" && f.Value.String() != "false" {
+			return true
+		}
+	}
+	return false
+}
+
+// Example returns the example e wrapped to report its result once run, with its expected and
+// actual output when it fails. The output is still checked by the testing package
+func Example(e testing.InternalExample) testing.InternalExample {
+	pkg := testmain.Package(e.F)
+	return exampleoutput.Wrap(e, func(res exampleoutput.Result) {
+		rec := Record{Kind: KindExample, Package: pkg, Name: res.Name, Example: &ExampleResult{
+			Passed:     res.Passed,
+			Unordered:  res.Unordered,
+			DurationNs: res.Duration.Nanoseconds(),
+		}}
+		if !res.Passed {
+			rec.Example.Expected, rec.Example.Actual = res.Expected, res.Actual
+		}
+		if res.Panic != nil {
+			rec.Example.Panic = fmt.Sprint(res.Panic)
+		}
+		report(rec)
+	})
+}
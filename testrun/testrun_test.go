@@ -0,0 +1,335 @@
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2023-present Datadog, Inc.
+
+package testrun
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tonyredondo/rd-toolexec/retry"
+	"github.com/tonyredondo/rd-toolexec/skiplist"
+)
+
+func TestMain(m *testing.M) {
+	RunTestMain(m, (*testing.M).Run)
+}
+
+// runReported runs the tests of the binary with args and returns the records of their report.
+// The tests are expected to fail if fails is set
+func runReported(t *testing.T, env []string, fails bool, args ...string) ([]Record, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cmd := exec.Command(os.Args[0], append([]string{"-test.v"}, args...)...)
+	cmd.Env = append(append(os.Environ(), ReportDirEnvVar+"="+dir), env...)
+	out, err := cmd.CombinedOutput()
+	if fails && err == nil {
+		t.Fatalf("expected the tests to fail:\n%s", out)
+	} else if !fails && err != nil {
+		t.Fatalf("unexpected error %v:\n%s", err, out)
+	}
+	recs, err := ReadReports(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recs, string(out)
+}
+
+var sink []byte
+
+func BenchmarkReported(b *testing.B) {
+	RunBenchmark(b, func(b *testing.B) {
+		RunB(b, "alloc", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sink = make([]byte, 64)
+			}
+		})
+		RunB(b, "noalloc", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+			}
+		})
+		RunB(b, "untimed", func(b *testing.B) {
+			// Neither the setup nor the work done with the timer stopped is measured
+			for i := 0; i < 1000; i++ {
+				sink = make([]byte, 64)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				sink = make([]byte, 64)
+				b.StartTimer()
+			}
+		})
+	})
+}
+
+func TestBenchmarkReport(t *testing.T) {
+	recs, out := runReported(t, nil, false, "-test.run=^$", "-test.bench=^BenchmarkReported$", "-test.benchtime=100x")
+	results := make(map[string]*Benchmark)
+	for _, rec := range recs {
+		if rec.Kind != KindBenchmark || rec.Benchmark == nil {
+			t.Fatalf("unexpected record %+v", rec)
+		}
+		if rec.Package != "github.com/tonyredondo/rd-toolexec/testrun" {
+			t.Errorf("unexpected package %q", rec.Package)
+		}
+		results[rec.Name] = rec.Benchmark
+	}
+	// The parent benchmark only runs the sub-benchmarks, it isn't reported
+	if len(results) != 3 {
+		t.Fatalf("expected the 3 sub-benchmarks, got %+v in:\n%s", recs, out)
+	}
+	alloc, noalloc, untimed := results["BenchmarkReported/alloc"], results["BenchmarkReported/noalloc"], results["BenchmarkReported/untimed"]
+	if alloc == nil || noalloc == nil || untimed == nil {
+		t.Fatalf("missing sub-benchmarks in %+v", recs)
+	}
+	if alloc.N != 100 || alloc.NsPerOp <= 0 || alloc.AllocsPerOp != 1 || alloc.BytesPerOp < 64 {
+		t.Errorf("unexpected result %+v", alloc)
+	}
+	if noalloc.N != 100 || noalloc.AllocsPerOp != 0 {
+		t.Errorf("unexpected result %+v", noalloc)
+	}
+	if untimed.N != 100 || untimed.AllocsPerOp != 0 || untimed.BytesPerOp != 0 {
+		t.Errorf("unexpected result %+v", untimed)
+	}
+}
+
+// failEnvVar makes FuzzReported fail, when it is run by TestFuzzReport
+const failEnvVar = "DD_TESTRUN_TEST_FAIL"
+
+func FuzzReported(f *testing.F) {
+	f.Add("ok")
+	f.Add("fail")
+	f.Add("panic")
+	RunFuzz(f, func(t *testing.T, s string) {
+		if os.Getenv(failEnvVar) == "" {
+			return
+		}
+		switch s {
+		case "fail":
+			t.Error("failed")
+		case "panic":
+			panic("boom")
+		}
+	})
+}
+
+func TestFuzzReport(t *testing.T) {
+	recs, out := runReported(t, []string{failEnvVar + "=1"}, true, "-test.run=^FuzzReported$")
+	inputs := make(map[string]*FuzzInput)
+	for _, rec := range recs {
+		if rec.Kind != KindFuzz || rec.Fuzz == nil || rec.Package != "github.com/tonyredondo/rd-toolexec/testrun" {
+			t.Fatalf("unexpected record %+v", rec)
+		}
+		inputs[rec.Name] = rec.Fuzz
+	}
+	if len(inputs) != 3 {
+		t.Fatalf("expected the 3 seed corpus entries, got %+v in:\n%s", recs, out)
+	}
+	if ok := inputs["FuzzReported/seed#0"]; ok == nil || ok.Failed || ok.CorpusPath != "" || ok.Input != "go test fuzz v1\nstring(\"ok\")\n" {
+		t.Errorf("unexpected passing input %+v", ok)
+	}
+	fail := inputs["FuzzReported/seed#1"]
+	if fail == nil || !fail.Failed || fail.Panic != "" || !strings.HasPrefix(fail.CorpusPath, "testdata/fuzz/FuzzReported/") {
+		t.Errorf("unexpected failing input %+v", fail)
+	} else if !strings.Contains(out, "corpus entry "+fail.CorpusPath) {
+		t.Errorf("the corpus entry of the failing input isn't logged:\n%s", out)
+	}
+	if p := inputs["FuzzReported/seed#2"]; p == nil || !p.Failed || p.Panic != "boom" || p.Input != "go test fuzz v1\nstring(\"panic\")\n" {
+		t.Errorf("unexpected panicking input %+v", p)
+	}
+}
+
+type reportedSuite struct{}
+
+// TestSuiteReported is run by TestSuiteReport, as a test framework instrumented by the toolexec
+// proxy runs a suite
+func TestSuiteReported(t *testing.T) {
+	run := (*testing.T).Run
+	Run(t, "before", func(t *testing.T) {}, run)
+	Suite(t, &reportedSuite{})
+	Run(t, "TestPass", func(t *testing.T) {
+		Run(t, "sub", func(t *testing.T) {}, run)
+	}, run)
+	Run(t, "TestSkip", func(t *testing.T) { t.Skip() }, run)
+	Run(t, "TestFail", func(t *testing.T) {
+		if os.Getenv(failEnvVar) != "" {
+			t.Error("failed")
+		}
+	}, run)
+}
+
+func TestSuiteReport(t *testing.T) {
+	recs, out := runReported(t, []string{failEnvVar + "=1"}, true, "-test.run=^TestSuiteReported$")
+	tests := make(map[string]Test)
+	for _, rec := range recs {
+		if rec.Kind != KindTest || rec.Test == nil || rec.Package != "github.com/tonyredondo/rd-toolexec/testrun" {
+			t.Fatalf("unexpected record %+v", rec)
+		}
+		tests[rec.Name] = *rec.Test
+	}
+	// The subtests started before the suite is known aren't part of it
+	expected := map[string]Test{
+		"TestSuiteReported/TestPass":     {Suite: "reportedSuite"},
+		"TestSuiteReported/TestPass/sub": {Suite: "reportedSuite"},
+		"TestSuiteReported/TestSkip":     {Suite: "reportedSuite", Skipped: true},
+		"TestSuiteReported/TestFail":     {Suite: "reportedSuite", Failed: true},
+	}
+	if !reflect.DeepEqual(tests, expected) {
+		t.Errorf("unexpected tests %+v, expected %+v in:\n%s", tests, expected, out)
+	}
+}
+
+func TestSuiteName(t *testing.T) {
+	Suite(t, reportedSuite{})
+	for name, expected := range map[string]string{
+		t.Name():               "reportedSuite",
+		t.Name() + "/TestFoo":  "reportedSuite",
+		t.Name() + "Other/sub": "",
+		"TestOther":            "",
+	} {
+		if actual := SuiteName(name); actual != expected {
+			t.Errorf("unexpected suite %q of %s, expected %q", actual, name, expected)
+		}
+	}
+}
+
+func TestExampleReport(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(ReportDirEnvVar, dir)
+	printer := func(s string) func() {
+		return func() { fmt.Println(s) }
+	}
+	Example(testing.InternalExample{Name: "ExamplePass", F: printer("b\na"), Output: "a\nb\n", Unordered: true}).F()
+	Example(testing.InternalExample{Name: "ExampleFail", F: printer("actual"), Output: "expected\n"}).F()
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("unexpected panic %v", r)
+			}
+		}()
+		Example(testing.InternalExample{Name: "ExamplePanic", F: func() { panic("boom") }}).F()
+	}()
+
+	recs, err := ReadReports(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples := make(map[string]ExampleResult)
+	for _, rec := range recs {
+		if rec.Kind != KindExample || rec.Example == nil || rec.Package != "github.com/tonyredondo/rd-toolexec/testrun" {
+			t.Fatalf("unexpected record %+v", rec)
+		}
+		res := *rec.Example
+		if res.DurationNs < 0 {
+			t.Errorf("unexpected duration of %s", rec.Name)
+		}
+		res.DurationNs = 0
+		examples[rec.Name] = res
+	}
+	// The output is only reported for the failed examples
+	expected := map[string]ExampleResult{
+		"ExamplePass":  {Passed: true, Unordered: true},
+		"ExampleFail":  {Expected: "expected\n", Actual: "actual\n"},
+		"ExamplePanic": {Panic: "boom"},
+	}
+	if !reflect.DeepEqual(examples, expected) {
+		t.Errorf("unexpected examples %+v, expected %+v", examples, expected)
+	}
+}
+
+// TestSkipListed is run by TestSkipList, with the skip-list file listing it and its subtest sub
+func TestSkipListed(t *testing.T) {
+	run := (*testing.T).Run
+	Run(t, "sub", func(t *testing.T) {
+		if os.Getenv(failEnvVar) != "" {
+			t.Error("not skipped")
+		}
+	}, run)
+	Run(t, "other", func(t *testing.T) {}, run)
+}
+
+// TestSkipListedTop is run by TestSkipList, with the skip-list file listing it
+func TestSkipListedTop(t *testing.T) {
+	if os.Getenv(failEnvVar) != "" {
+		t.Error("not skipped")
+	}
+}
+
+func TestSkipList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "skiplist.json")
+	list := `{"github.com/tonyredondo/rd-toolexec/testrun": {"TestSkipListed/sub": "", "TestSkipListedTop": "not affected"}}`
+	if err := os.WriteFile(path, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, out := runReported(t, []string{failEnvVar + "=1", skiplist.FileEnvVar + "=" + path}, false, "-test.run=^TestSkipListed")
+	for _, expected := range []string{
+		"--- SKIP: TestSkipListedTop",
+		"not affected",
+		"--- SKIP: TestSkipListed/sub",
+		"--- PASS: TestSkipListed/other",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%s", expected, out)
+		}
+	}
+}
+
+var retriedAttempts, retriedSubAttempts int
+
+// TestRetried is run by TestRetryReport, and passes on its second attempt
+func TestRetried(t *testing.T) {
+	retriedAttempts++
+	if os.Getenv(failEnvVar) != "" && retriedAttempts == 1 {
+		t.Error("first attempt")
+	}
+}
+
+// TestRetriedFail is run by TestRetryReport, and fails every attempt
+func TestRetriedFail(t *testing.T) {
+	if os.Getenv(failEnvVar) != "" {
+		t.Error("every attempt")
+	}
+}
+
+// TestRetriedSub is run by TestRetryReport, and its subtest passes on its second attempt
+func TestRetriedSub(t *testing.T) {
+	Run(t, "sub", func(t *testing.T) {
+		retriedSubAttempts++
+		if os.Getenv(failEnvVar) != "" && retriedSubAttempts == 1 {
+			t.Error("first attempt")
+		}
+	}, (*testing.T).Run)
+}
+
+func TestRetryReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "retry.json")
+	if err := os.WriteFile(path, []byte(`{"github.com/tonyredondo/rd-toolexec/testrun": {"TestRetriedSub/sub": 1}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := []string{failEnvVar + "=1", retry.CountEnvVar + "=2", retry.FileEnvVar + "=" + path}
+	recs, out := runReported(t, env, true, "-test.run=^TestRetried")
+	tests := make(map[string]Test)
+	for _, rec := range recs {
+		if rec.Kind != KindTest || rec.Test == nil || rec.Package != "github.com/tonyredondo/rd-toolexec/testrun" {
+			t.Fatalf("unexpected record %+v", rec)
+		}
+		tests[rec.Name] = *rec.Test
+	}
+	// The tests passing on their first attempt aren't retried, nor reported
+	expected := map[string]Test{
+		"TestRetried":        {Flaky: true, Attempts: 2},
+		"TestRetriedFail":    {Failed: true, Attempts: 3},
+		"TestRetriedSub/sub": {Flaky: true, Attempts: 2},
+	}
+	if !reflect.DeepEqual(tests, expected) {
+		t.Errorf("unexpected tests %+v, expected %+v in:\n%s", tests, expected, out)
+	}
+}